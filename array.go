@@ -0,0 +1,468 @@
+package bitindex
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Loc is the location of the bit in array consisting of the byte
+// position and the relative bit.
+type Loc [2]uint32
+
+// Array is a bit array for a single key in the table. The representation
+// is chosen per key, see Pack.
+type Array interface {
+	// Bytes returns the number of bytes used by the array.
+	Bytes() int
+
+	// Set sets the bit to 1.
+	Set(bit uint32)
+
+	// Clear sets the bit to 0.
+	Clear(bit uint32)
+
+	// Flip flips the bit.
+	Flip(bit uint32) bool
+
+	// Has returns true if the bit is set.
+	Has(bit uint32) bool
+
+	// Loc returns the location of the bit in this array.
+	Loc(bit uint32) Loc
+
+	// Any returns true any of the bits set.
+	Any(bits ...uint32) bool
+
+	// All returns true if all of the bits are set.
+	All(bits ...uint32) bool
+
+	// NotAny returns true if any of the bits are not set.
+	NotAny(bits ...uint32) bool
+
+	// NotAll returns true if all of the bits are not set.
+	NotAll(bits ...uint32) bool
+
+	// AnyMask returns true if any of the bits in the mask are set.
+	AnyMask(m Mask) bool
+
+	// AllMask returns true if all of the bits in the mask are set.
+	AllMask(m Mask) bool
+
+	// CountMask returns the number of bits in the mask that are set.
+	CountMask(m Mask) int
+
+	// Bits returns the set bits in ascending order.
+	Bits() []uint32
+}
+
+// Mask is a precomputed word-packed set of bits used to test arrays
+// without a lookup per bit.
+type Mask []uint64
+
+// NewMask builds a mask from a set of bits.
+func NewMask(bits ...uint32) Mask {
+	var max uint32
+
+	for _, bit := range bits {
+		if bit > max {
+			max = bit
+		}
+	}
+
+	m := make(Mask, max/64+1)
+
+	for _, bit := range bits {
+		m[bit/64] |= 1 << (bit % 64)
+	}
+
+	return m
+}
+
+// byteAt returns the byte at byte position p of the mask.
+func (m Mask) byteAt(p uint32) byte {
+	w := p / 8
+
+	if w >= uint32(len(m)) {
+		return 0
+	}
+
+	return byte(m[w] >> ((p % 8) * 8))
+}
+
+// SparseArray is a bit array that only stores the bytes that have
+// at least one bit set. It is suited for arrays with few bits set
+// relative to the domain.
+type SparseArray map[uint32]byte
+
+// Bytes returns the number of bytes used by the array.
+func (a SparseArray) Bytes() int {
+	return len(a)
+}
+
+// Set sets the bit to 1.
+func (a SparseArray) Set(bit uint32) {
+	off := bit / 8
+	bit = bit % 8
+
+	if _, ok := a[off]; !ok {
+		a[off] = 1 << bit
+	} else {
+		a[off] |= (1 << bit)
+	}
+}
+
+// Clear sets the bit to 0.
+func (a SparseArray) Clear(bit uint32) {
+	off := bit / 8
+	bit = bit % 8
+
+	if _, ok := a[off]; ok {
+		a[off] &= ^(1 << bit)
+	}
+}
+
+// Flip flips the bit.
+func (a SparseArray) Flip(bit uint32) bool {
+	t := a.Has(bit)
+
+	if t {
+		a.Clear(bit)
+	} else {
+		a.Set(bit)
+	}
+
+	return !t
+}
+
+// Has returns true if the bit is set.
+func (a SparseArray) Has(bit uint32) bool {
+	off := bit / 8
+	bit = bit % 8
+
+	if bt, ok := a[off]; ok {
+		v := bt & (1 << bit)
+		return v > 0
+	}
+
+	return false
+}
+
+// Loc returns the location of the bit in this array.
+func (a SparseArray) Loc(bit uint32) Loc {
+	return Loc{bit / 8, bit % 8}
+}
+
+// Any returns true any of the bits set.
+func (a SparseArray) Any(bits ...uint32) bool {
+	for _, bit := range bits {
+		if a.Has(bit) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// All returns true if all of the bits are set.
+func (a SparseArray) All(bits ...uint32) bool {
+	for _, bit := range bits {
+		if !a.Has(bit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NotAny returns true if any of the bits are not set.
+func (a SparseArray) NotAny(bits ...uint32) bool {
+	for _, bit := range bits {
+		if a.Has(bit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NotAll returns true if all of the bits are not set.
+func (a SparseArray) NotAll(bits ...uint32) bool {
+	for _, bit := range bits {
+		if !a.Has(bit) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AnyMask returns true if any of the bits in the mask are set. Only the
+// populated bytes of the array are visited.
+func (a SparseArray) AnyMask(m Mask) bool {
+	for p, y := range a {
+		if m.byteAt(p)&y != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllMask returns true if all of the bits in the mask are set. Only the
+// non-zero bytes of the mask are visited.
+func (a SparseArray) AllMask(m Mask) bool {
+	for i, w := range m {
+		for j := uint32(0); w != 0; j++ {
+			if mb := byte(w); mb != 0 {
+				if a[uint32(i)*8+j]&mb != mb {
+					return false
+				}
+			}
+
+			w >>= 8
+		}
+	}
+
+	return true
+}
+
+// CountMask returns the number of bits in the mask that are set.
+func (a SparseArray) CountMask(m Mask) int {
+	var n int
+
+	for p, y := range a {
+		n += bits.OnesCount8(m.byteAt(p) & y)
+	}
+
+	return n
+}
+
+// Bits returns the set bits in ascending order.
+func (a SparseArray) Bits() []uint32 {
+	ps := make(Uint32Array, 0, len(a))
+
+	for p := range a {
+		ps = append(ps, p)
+	}
+
+	sort.Sort(ps)
+
+	var bits []uint32
+
+	for _, p := range ps {
+		y := a[p]
+
+		for j := uint32(0); j < 8; j++ {
+			if y&(1<<j) != 0 {
+				bits = append(bits, p*8+j)
+			}
+		}
+	}
+
+	return bits
+}
+
+// DenseArray is a bit array backed by a contiguous slice of 64-bit words.
+// It is suited for arrays with many bits set relative to the domain.
+type DenseArray struct {
+	words []uint64
+}
+
+// Bytes returns the number of bytes used by the array.
+func (a *DenseArray) Bytes() int {
+	return len(a.words) * 8
+}
+
+// Set sets the bit to 1.
+func (a *DenseArray) Set(bit uint32) {
+	w := int(bit / 64)
+
+	if w >= len(a.words) {
+		words := make([]uint64, w+1)
+		copy(words, a.words)
+		a.words = words
+	}
+
+	a.words[w] |= 1 << (bit % 64)
+}
+
+// Clear sets the bit to 0.
+func (a *DenseArray) Clear(bit uint32) {
+	w := int(bit / 64)
+
+	if w < len(a.words) {
+		a.words[w] &= ^(1 << (bit % 64))
+	}
+}
+
+// Flip flips the bit.
+func (a *DenseArray) Flip(bit uint32) bool {
+	t := a.Has(bit)
+
+	if t {
+		a.Clear(bit)
+	} else {
+		a.Set(bit)
+	}
+
+	return !t
+}
+
+// Has returns true if the bit is set.
+func (a *DenseArray) Has(bit uint32) bool {
+	w := int(bit / 64)
+
+	if w >= len(a.words) {
+		return false
+	}
+
+	return a.words[w]&(1<<(bit%64)) != 0
+}
+
+// Loc returns the location of the bit in this array.
+func (a *DenseArray) Loc(bit uint32) Loc {
+	return Loc{bit / 8, bit % 8}
+}
+
+// Any returns true any of the bits set.
+func (a *DenseArray) Any(bits ...uint32) bool {
+	for _, bit := range bits {
+		if a.Has(bit) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// All returns true if all of the bits are set.
+func (a *DenseArray) All(bits ...uint32) bool {
+	for _, bit := range bits {
+		if !a.Has(bit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NotAny returns true if any of the bits are not set.
+func (a *DenseArray) NotAny(bits ...uint32) bool {
+	return !a.Any(bits...)
+}
+
+// NotAll returns true if all of the bits are not set.
+func (a *DenseArray) NotAll(bits ...uint32) bool {
+	return !a.All(bits...)
+}
+
+// AnyMask returns true if any of the bits in the mask are set.
+func (a *DenseArray) AnyMask(m Mask) bool {
+	n := len(m)
+
+	if len(a.words) < n {
+		n = len(a.words)
+	}
+
+	for i := 0; i < n; i++ {
+		if a.words[i]&m[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllMask returns true if all of the bits in the mask are set.
+func (a *DenseArray) AllMask(m Mask) bool {
+	for i, w := range m {
+		if w == 0 {
+			continue
+		}
+
+		if i >= len(a.words) || a.words[i]&w != w {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CountMask returns the number of bits in the mask that are set.
+func (a *DenseArray) CountMask(m Mask) int {
+	var c int
+
+	n := len(m)
+
+	if len(a.words) < n {
+		n = len(a.words)
+	}
+
+	for i := 0; i < n; i++ {
+		c += bits.OnesCount64(a.words[i] & m[i])
+	}
+
+	return c
+}
+
+// Bits returns the set bits in ascending order.
+func (a *DenseArray) Bits() []uint32 {
+	var bits []uint32
+
+	for i, w := range a.words {
+		for j := uint32(0); w != 0; j++ {
+			if w&1 != 0 {
+				bits = append(bits, uint32(i)*64+j)
+			}
+
+			w >>= 1
+		}
+	}
+
+	return bits
+}
+
+// NewArray initializes a new array. New arrays are sparse until packed.
+func NewArray() Array {
+	return make(SparseArray)
+}
+
+// NewDenseArray initializes a new dense array with capacity for n bits.
+func NewDenseArray(n int) *DenseArray {
+	return &DenseArray{
+		words: make([]uint64, (n+63)/64),
+	}
+}
+
+// Pack returns the representation of the array best suited for its
+// density. Arrays are converted into whichever of a dense array or
+// a roaring bitmap uses fewer bytes.
+func Pack(a Array) Array {
+	bits := a.Bits()
+
+	if len(bits) == 0 {
+		return a
+	}
+
+	b, ok := a.(*Bitmap)
+
+	if !ok {
+		b = NewBitmap(bits...)
+		b.RunOptimize()
+	}
+
+	// Size of the dense array, which is only allocated if it is chosen.
+	max := bits[len(bits)-1]
+
+	if int(max/64+1)*8 > b.Bytes() {
+		return b
+	}
+
+	d := NewDenseArray(int(max) + 1)
+
+	for _, bit := range bits {
+		d.Set(bit)
+	}
+
+	return d
+}
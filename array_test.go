@@ -0,0 +1,139 @@
+package bitindex
+
+import "testing"
+
+func TestDenseArray(t *testing.T) {
+	bits := []uint32{2, 17, 30, 54, 63, 64, 200}
+
+	a := NewDenseArray(64)
+
+	for _, b := range bits {
+		a.Set(b)
+
+		if !a.Has(b) {
+			t.Errorf("bit %d should be set", b)
+		}
+
+		a.Clear(b)
+
+		if a.Has(b) {
+			t.Errorf("bit %d should be cleared", b)
+		}
+
+		a.Flip(b)
+
+		if !a.Has(b) {
+			t.Errorf("bit %d should be set", b)
+		}
+	}
+
+	// Grown to fit bit 200.
+	if a.Bytes() != 32 {
+		t.Errorf("expected size 32, got %d", a.Bytes())
+	}
+
+	out := a.Bits()
+
+	if len(out) != len(bits) {
+		t.Fatalf("expected %v, got %v", bits, out)
+	}
+
+	for i, b := range bits {
+		if out[i] != b {
+			t.Errorf("expected bit %d at %d, got %d", b, i, out[i])
+		}
+	}
+}
+
+func TestArrayMask(t *testing.T) {
+	arrays := map[string]Array{
+		"sparse": NewArray(),
+		"dense":  NewDenseArray(0),
+	}
+
+	for name, a := range arrays {
+		a.Set(3)
+		a.Set(70)
+		a.Set(130)
+
+		if !a.AnyMask(NewMask(1, 70)) {
+			t.Errorf("%s: expected any to match", name)
+		}
+
+		if a.AnyMask(NewMask(1, 71, 500)) {
+			t.Errorf("%s: expected any not to match", name)
+		}
+
+		if !a.AllMask(NewMask(3, 130)) {
+			t.Errorf("%s: expected all to match", name)
+		}
+
+		if a.AllMask(NewMask(3, 130, 131)) {
+			t.Errorf("%s: expected all not to match", name)
+		}
+
+		if a.AllMask(NewMask(3, 500)) {
+			t.Errorf("%s: expected all not to match beyond the array", name)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	a := NewArray()
+
+	// One bit in a span of 16 words is compressed.
+	a.Set(1000)
+
+	if _, ok := Pack(a).(*Bitmap); !ok {
+		t.Errorf("expected bitmap")
+	}
+
+	// Populate every byte.
+	for i := uint32(0); i < 1000; i += 8 {
+		a.Set(i)
+	}
+
+	p := Pack(a)
+
+	if _, ok := p.(*DenseArray); !ok {
+		t.Fatalf("expected dense array")
+	}
+
+	for i := uint32(0); i < 1000; i += 8 {
+		if !p.Has(i) {
+			t.Errorf("bit %d should be set", i)
+		}
+	}
+
+	if !p.Has(1000) {
+		t.Errorf("bit 1000 should be set")
+	}
+}
+
+func BenchmarkSparseAnyMask(b *testing.B) {
+	a := NewArray()
+
+	for i := uint32(0); i < 20000; i += 97 {
+		a.Set(i)
+	}
+
+	m := NewMask(5, 1000, 19999)
+
+	for i := 0; i < b.N; i++ {
+		a.AnyMask(m)
+	}
+}
+
+func BenchmarkDenseAnyMask(b *testing.B) {
+	a := NewDenseArray(20000)
+
+	for i := uint32(0); i < 20000; i += 7 {
+		a.Set(i)
+	}
+
+	m := NewMask(5, 1000, 19999)
+
+	for i := 0; i < b.N; i++ {
+		a.AnyMask(m)
+	}
+}
@@ -0,0 +1,617 @@
+package bitindex
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// DefaultRunSize is the number of pairs a Builder buffers in memory
+// before spilling them to a temporary file. Each pair takes 8 bytes.
+const DefaultRunSize = 1 << 24
+
+// Streamer is implemented by sources that can emit key/member pairs one
+// at a time rather than building the whole index in memory.
+type Streamer interface {
+	Stream(f func(k uint32, m uint32) error) error
+}
+
+// pair is a key and bit, or a bit and key when sorted for the postings.
+type pair struct {
+	a, b uint32
+}
+
+type pairSlice []pair
+
+func (p pairSlice) Len() int {
+	return len(p)
+}
+
+func (p pairSlice) Less(i, j int) bool {
+	if p[i].a != p[j].a {
+		return p[i].a < p[j].a
+	}
+
+	return p[i].b < p[j].b
+}
+
+func (p pairSlice) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+// runReader reads a sorted run of pairs from a temporary file.
+type runReader struct {
+	r   *bufio.Reader
+	b   []byte
+	cur pair
+}
+
+// next reads the next pair and returns false at the end of the run.
+func (r *runReader) next() (bool, error) {
+	if _, err := io.ReadFull(r.r, r.b); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	r.cur.a = binary.LittleEndian.Uint32(r.b)
+	r.cur.b = binary.LittleEndian.Uint32(r.b[4:])
+
+	return true, nil
+}
+
+// runHeap orders the runs by their current pair.
+type runHeap []*runReader
+
+func (h runHeap) Len() int {
+	return len(h)
+}
+
+func (h runHeap) Less(i, j int) bool {
+	return pairSlice{h[i].cur, h[j].cur}.Less(0, 1)
+}
+
+func (h runHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the sorted runs and calls f for each distinct pair
+// in ascending order.
+func mergeRuns(files []*os.File, f func(p pair) error) error {
+	h := make(runHeap, 0, len(files))
+
+	for _, file := range files {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		r := &runReader{
+			r: bufio.NewReader(file),
+			b: make([]byte, 8),
+		}
+
+		ok, err := r.next()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			h = append(h, r)
+		}
+	}
+
+	heap.Init(&h)
+
+	var (
+		last  pair
+		first = true
+	)
+
+	for len(h) > 0 {
+		r := h[0]
+		p := r.cur
+
+		// Pairs added more than once are only emitted once.
+		if first || p != last {
+			if err := f(p); err != nil {
+				return err
+			}
+
+			last = p
+			first = false
+		}
+
+		ok, err := r.next()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return nil
+}
+
+// spool is a temporary file a section is written to before it is copied
+// into the index file.
+type spool struct {
+	f *os.File
+	w *bufio.Writer
+	n uint64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.n += uint64(n)
+	return n, err
+}
+
+// copyTo copies the contents of the spool to w.
+func (s *spool) copyTo(w io.Writer) error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(w, s.f)
+
+	return err
+}
+
+// bitmapSpool writes a list of bitmaps built from pairs arriving in
+// ascending order. The offsets and bitmaps are spooled separately and
+// copied into the index file one after the other.
+type bitmapSpool struct {
+	offs *spool
+	data *spool
+	b    []byte
+
+	// Bitmap being built and the highest value set in it.
+	cur *Bitmap
+	max uint32
+}
+
+// flush writes the current bitmap and returns the number of bytes it
+// would use once packed in memory.
+func (s *bitmapSpool) flush() (int, error) {
+	bm := s.cur
+	bm.RunOptimize()
+
+	s.cur = NewBitmap()
+
+	if err := dumpBitmap(s.data, bm, s.b); err != nil {
+		return 0, err
+	}
+
+	if err := writeUint64(s.offs, s.b, s.data.n); err != nil {
+		return 0, fmt.Errorf("Error writing offset: %s", err)
+	}
+
+	// Mirrors Pack without building the dense array.
+	if bm.Cardinality() == 0 {
+		return 0, nil
+	}
+
+	if n := int(s.max/64+1) * 8; n <= bm.Bytes() {
+		return n, nil
+	}
+
+	return bm.Bytes(), nil
+}
+
+func (s *bitmapSpool) set(v uint32) {
+	s.cur.Set(v)
+	s.max = v
+}
+
+func (s *bitmapSpool) size() uint64 {
+	return s.offs.n + s.data.n
+}
+
+func (s *bitmapSpool) dump(w io.Writer, b []byte) error {
+	if err := s.offs.copyTo(w); err != nil {
+		return fmt.Errorf("Error writing offsets: %s", err)
+	}
+
+	if err := s.data.copyTo(w); err != nil {
+		return fmt.Errorf("Error writing bitmaps: %s", err)
+	}
+
+	return nil
+}
+
+// Builder builds an index file from key/member pairs without holding the
+// index in memory. Pairs are buffered, sorted and spilled to temporary
+// files as runs. Dump merges the runs and writes them directly in the
+// binary format. Only the domain and one array at a time are kept in
+// memory, so inputs larger than the available memory can be indexed.
+type Builder struct {
+	// Directory for the temporary files. Defaults to the system
+	// temporary directory.
+	TempDir string
+
+	// Number of pairs buffered before a run is spilled.
+	RunSize int
+
+	// If true, the postings are built along with the table.
+	Postings bool
+
+	// Optional dictionaries of the key and member labels. They are
+	// held in memory and written with the index.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+
+	// Optional map of 64-bit keys. It is held in memory and written
+	// with the index.
+	WideKeys *KeyMap
+
+	// Optional hierarchy over the members.
+	Hierarchy *Hierarchy
+
+	// Codec of the sections of the index file. Each section is
+	// compressed to a temporary file before it is written.
+	Codec Codec
+
+	domain *Domain
+	buf    pairSlice
+
+	// Runs sorted by key and by bit.
+	rows  []*os.File
+	posts []*os.File
+
+	spools []*spool
+}
+
+// NewBuilder initializes a new builder.
+func NewBuilder() *Builder {
+	return &Builder{
+		RunSize: DefaultRunSize,
+		domain:  NewDomain(nil),
+	}
+}
+
+// Add adds the key/member pair.
+func (b *Builder) Add(k uint32, m uint32) error {
+	b.buf = append(b.buf, pair{k, b.domain.Add(m)})
+
+	if len(b.buf) >= b.RunSize {
+		return b.spill()
+	}
+
+	return nil
+}
+
+// Build adds all pairs of the streamer.
+func (b *Builder) Build(s Streamer) error {
+	return s.Stream(b.Add)
+}
+
+// writeRun sorts the buffer and writes it to a temporary file.
+func (b *Builder) writeRun() (*os.File, error) {
+	sort.Sort(b.buf)
+
+	f, err := ioutil.TempFile(b.TempDir, "bitindex-run")
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating run: %s", err)
+	}
+
+	w := bufio.NewWriter(f)
+	x := make([]byte, 8)
+
+	for _, p := range b.buf {
+		binary.LittleEndian.PutUint32(x, p.a)
+		binary.LittleEndian.PutUint32(x[4:], p.b)
+
+		if _, err = w.Write(x); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("Error writing run: %s", err)
+	}
+
+	return f, nil
+}
+
+// spill writes the buffered pairs as a run sorted by key and, if postings
+// are built, another sorted by bit.
+func (b *Builder) spill() error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+
+	f, err := b.writeRun()
+
+	if err != nil {
+		return err
+	}
+
+	b.rows = append(b.rows, f)
+
+	if b.Postings {
+		for i, p := range b.buf {
+			b.buf[i] = pair{p.b, p.a}
+		}
+
+		if f, err = b.writeRun(); err != nil {
+			return err
+		}
+
+		b.posts = append(b.posts, f)
+	}
+
+	b.buf = b.buf[:0]
+
+	return nil
+}
+
+func (b *Builder) newSpool() (*spool, error) {
+	f, err := ioutil.TempFile(b.TempDir, "bitindex-section")
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		f: f,
+		w: bufio.NewWriter(f),
+	}
+
+	b.spools = append(b.spools, s)
+
+	return s, nil
+}
+
+func (b *Builder) newBitmapSpool() (*bitmapSpool, error) {
+	offs, err := b.newSpool()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := b.newSpool()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &bitmapSpool{
+		offs: offs,
+		data: data,
+		b:    make([]byte, 8),
+		cur:  NewBitmap(),
+	}
+
+	// Offset of the first bitmap.
+	if err = writeUint64(offs, s.b, 0); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Dump merges the runs and writes the index to w. The statistics of the
+// index are returned.
+func (b *Builder) Dump(w io.Writer) (*Stats, error) {
+	if err := b.spill(); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		DomainSize: b.domain.Size(),
+		Postings:   b.Postings,
+	}
+
+	keys, err := b.newSpool()
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating spool: %s", err)
+	}
+
+	rows, err := b.newBitmapSpool()
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating spool: %s", err)
+	}
+
+	x := make([]byte, 8)
+
+	// Flushes the array of the previous key.
+	flush := func() error {
+		n, err := rows.flush()
+		stats.Bytes += n
+		return err
+	}
+
+	// Key of the array being built.
+	var (
+		key  uint32
+		open bool
+	)
+
+	err = mergeRuns(b.rows, func(p pair) error {
+		if open && p.a != key {
+			if err := flush(); err != nil {
+				return err
+			}
+
+			open = false
+		}
+
+		if !open {
+			if err := writeUint32(keys, x, p.a); err != nil {
+				return err
+			}
+
+			key, open = p.a, true
+			stats.TableSize++
+		}
+
+		rows.set(p.b)
+
+		return nil
+	})
+
+	if err == nil && open {
+		err = flush()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error merging runs: %s", err)
+	}
+
+	secs := []sectionWriter{
+		{sectionStats, statsSize, func(w io.Writer, x []byte) error {
+			return dumpStats(w, stats, x)
+		}},
+		{sectionDomain, 4 + 4*uint64(b.domain.Size()), func(w io.Writer, x []byte) error {
+			return dumpDomain(w, b.domain, x)
+		}},
+		{sectionKeys, 4 + keys.n, func(w io.Writer, x []byte) error {
+			if err := writeUint32(w, x, uint32(stats.TableSize)); err != nil {
+				return fmt.Errorf("Error writing table length: %s", err)
+			}
+
+			return keys.copyTo(w)
+		}},
+		{sectionRows, rows.size(), rows.dump},
+	}
+
+	var flags uint16
+
+	if b.Postings {
+		if secs, err = b.dumpPostings(secs); err != nil {
+			return nil, err
+		}
+
+		flags |= flagPostings
+	}
+
+	secs = appendDictionaries(secs, b.KeyDict, b.MemberDict)
+	secs = appendKeyMap(secs, b.WideKeys)
+	secs = appendHierarchy(secs, b.Hierarchy)
+
+	if b.WideKeys != nil {
+		flags |= flagWideKeys
+	}
+
+	flags |= dictionaryFlags(b.KeyDict, b.MemberDict)
+
+	if b.Hierarchy != nil {
+		flags |= flagHierarchy
+	}
+
+	// Compressed sections are spooled rather than held in memory.
+	if err = dumpFile(w, flags, b.Codec, secs, b.newSpool); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// dumpPostings merges the runs sorted by bit and appends the postings
+// section.
+func (b *Builder) dumpPostings(secs []sectionWriter) ([]sectionWriter, error) {
+	ps, err := b.newBitmapSpool()
+
+	if err != nil {
+		return nil, fmt.Errorf("Error creating spool: %s", err)
+	}
+
+	// Next bit to be written.
+	var bit uint32
+
+	err = mergeRuns(b.posts, func(p pair) error {
+		// Bits before this one are done, including any without keys.
+		for ; bit < p.a; bit++ {
+			if _, err := ps.flush(); err != nil {
+				return err
+			}
+		}
+
+		ps.set(p.b)
+
+		return nil
+	})
+
+	for ; err == nil && int(bit) < b.domain.Size(); bit++ {
+		_, err = ps.flush()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Error merging runs: %s", err)
+	}
+
+	n := b.domain.Size()
+
+	return append(secs, sectionWriter{sectionPostings, 4 + ps.size(), func(w io.Writer, x []byte) error {
+		if err := writeUint32(w, x, uint32(n)); err != nil {
+			return fmt.Errorf("Error writing postings length: %s", err)
+		}
+
+		return ps.dump(w, x)
+	}}), nil
+}
+
+// Close removes the temporary files.
+func (b *Builder) Close() error {
+	var files []*os.File
+
+	files = append(files, b.rows...)
+	files = append(files, b.posts...)
+
+	for _, s := range b.spools {
+		files = append(files, s.f)
+	}
+
+	var err error
+
+	for _, f := range files {
+		f.Close()
+
+		if e := os.Remove(f.Name()); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	b.rows = nil
+	b.posts = nil
+	b.spools = nil
+
+	return err
+}
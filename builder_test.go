@@ -0,0 +1,103 @@
+package bitindex
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func newFruitCSV() *CSVIndexer {
+	var rows []string
+
+	for _, k := range people {
+		for _, m := range pairs[k] {
+			rows = append(rows, strconv.Itoa(int(k))+","+strconv.Itoa(int(m)))
+		}
+	}
+
+	// Duplicates are ignored.
+	rows = append(rows, rows[0])
+
+	p := NewCSVIndexer(strings.NewReader(strings.Join(rows, "\n")))
+
+	p.Parse = func(row []string) (uint32, uint32, error) {
+		k, err := strconv.Atoi(row[0])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		m, err := strconv.Atoi(row[1])
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return uint32(k), uint32(m), nil
+	}
+
+	return p
+}
+
+func TestBuilder(t *testing.T) {
+	for _, postings := range []bool{false, true} {
+		// The in-memory build is the reference.
+		p := newFruitCSV()
+		p.Postings = postings
+
+		ix, err := p.Index()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := bytes.NewBuffer(nil)
+
+		if err = DumpIndex(exp, ix); err != nil {
+			t.Fatal(err)
+		}
+
+		b := NewBuilder()
+		b.RunSize = 2
+		b.Postings = postings
+
+		if err = b.Build(newFruitCSV()); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := bytes.NewBuffer(nil)
+
+		stats, err := b.Dump(buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = b.Close(); err != nil {
+			t.Error(err)
+		}
+
+		if *stats != *ix.Stats() {
+			t.Errorf("postings %v: expected %+v, got %+v", postings, ix.Stats(), stats)
+		}
+
+		if !bytes.Equal(buf.Bytes(), exp.Bytes()) {
+			t.Errorf("postings %v: external build differs from in-memory build", postings)
+		}
+
+		ix2, err := LoadIndex(buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for k, ms := range pairs {
+			for _, m := range ms {
+				if !ix2.Has(k, m) {
+					t.Errorf("postings %v: key %d should have member %d", postings, k, m)
+				}
+			}
+		}
+	}
+}
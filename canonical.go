@@ -0,0 +1,162 @@
+package bitindex
+
+import "sort"
+
+// Canonicalize renumbers the index so its encoding only depends on the
+// pairs it holds and not on the order they were added in, so identical
+// data is written to identical files. Labeled keys and members are
+// assigned ids in label order, 64-bit keys are assigned ids in key order
+// and labels that are no longer used are dropped. If sortDomain is true,
+// members are also assigned bits in member order, or label order if the
+// members are labeled; otherwise members keep their bits. Retired
+// members are compacted first. It returns ErrReadOnly if the index was
+// opened with OpenIndex.
+func (ix *Index) Canonicalize(sortDomain bool) error {
+	if err := ix.Compact(); err != nil {
+		return err
+	}
+
+	// Old id -> new id of members and keys.
+	member := func(m uint32) uint32 { return m }
+	key := func(k uint32) uint32 { return k }
+
+	if d := ix.MemberDict; d != nil {
+		used := make(map[uint32]struct{}, d.Size())
+
+		for _, m := range ix.Domain.Members() {
+			used[m] = struct{}{}
+		}
+
+		if ix.Hierarchy != nil {
+			ix.Hierarchy.each(func(p, c uint32) {
+				used[p] = struct{}{}
+				used[c] = struct{}{}
+			})
+		}
+
+		var ids map[uint32]uint32
+
+		ix.MemberDict, ids = sortDictionary(d, used)
+		member = func(m uint32) uint32 { return ids[m] }
+	}
+
+	if d := ix.KeyDict; d != nil {
+		var ids map[uint32]uint32
+
+		ix.KeyDict, ids = sortDictionary(d, tableKeys(ix.Table))
+		key = func(k uint32) uint32 { return ids[k] }
+	}
+
+	if km := ix.WideKeys; km != nil {
+		var ids map[uint32]uint32
+
+		ix.WideKeys, ids = sortKeyMap(km, tableKeys(ix.Table))
+		key = func(k uint32) uint32 { return ids[k] }
+	}
+
+	old := ix.Domain.Members()
+	ms := make([]uint32, len(old))
+
+	for b, m := range old {
+		ms[b] = member(m)
+	}
+
+	if sortDomain {
+		sort.Sort(Uint32Array(ms))
+	}
+
+	d := NewDomain(ms)
+
+	// Old bit -> new bit.
+	bits := make([]uint32, len(old))
+
+	for b, m := range old {
+		bits[b] = d.Bit(member(m))
+	}
+
+	// Rows are rebuilt so their representation does not depend on
+	// how they were built either.
+	t := make(Table, len(ix.Table))
+
+	for k, a := range ix.Table {
+		c := NewArray()
+
+		for _, b := range a.Bits() {
+			c.Set(bits[b])
+		}
+
+		t[key(k)] = Pack(c)
+	}
+
+	ix.Domain = d
+	ix.Table = t
+
+	if ix.Postings != nil {
+		ix.Postings = BuildPostings(t)
+	}
+
+	if h := ix.Hierarchy; h != nil {
+		ix.Hierarchy = NewHierarchy()
+
+		h.each(func(p, c uint32) {
+			ix.Hierarchy.AddEdge(member(p), member(c))
+		})
+	}
+
+	return nil
+}
+
+// tableKeys returns the set of keys in the table.
+func tableKeys(t Table) map[uint32]struct{} {
+	keys := make(map[uint32]struct{}, len(t))
+
+	for k := range t {
+		keys[k] = struct{}{}
+	}
+
+	return keys
+}
+
+// sortDictionary returns a dictionary of the used labels added in label
+// order and the new id of each used id.
+func sortDictionary(d *Dictionary, used map[uint32]struct{}) (*Dictionary, map[uint32]uint32) {
+	ids := make([]uint32, 0, len(used))
+
+	for id := range used {
+		ids = append(ids, id)
+	}
+
+	sort.Sort(&labelOrder{d, ids})
+
+	nd := NewDictionary()
+	m := make(map[uint32]uint32, len(ids))
+
+	for _, id := range ids {
+		s, _ := d.Label(id)
+		m[id] = nd.Add(s)
+	}
+
+	return nd, m
+}
+
+// sortKeyMap returns a key map of the used keys added in key order and
+// the new id of each used id.
+func sortKeyMap(km *KeyMap, used map[uint32]struct{}) (*KeyMap, map[uint32]uint32) {
+	ids := make([]uint32, 0, len(used))
+
+	for id := range used {
+		ids = append(ids, id)
+	}
+
+	sort.Sort(&keyOrder{km, ids})
+
+	nk := NewKeyMap()
+	m := make(map[uint32]uint32, len(ids))
+
+	for _, id := range ids {
+		k, _ := km.Key(id)
+		m[id] = nk.Add(k)
+	}
+
+	return nk, m
+}
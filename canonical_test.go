@@ -0,0 +1,119 @@
+package bitindex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	rows := []string{"A100,E11.9", "A100,I10", "B200,J45", "C300,J45", "C300,E11.9", "D400,K21"}
+
+	// The same pairs in reverse order, with a deleted key in between.
+	rev := []string{"X900,Z99"}
+
+	for i := len(rows) - 1; i >= 0; i-- {
+		rev = append(rev, rows[i])
+	}
+
+	build := func(rows []string) *Index {
+		p := NewCSVIndexer(strings.NewReader(strings.Join(rows, "\n")))
+		p.Postings = true
+		p.ParseLabels = func(row []string) (string, string, error) {
+			return row[0], row[1], nil
+		}
+
+		ix, err := p.Index()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ix
+	}
+
+	dump := func(ix *Index) []byte {
+		buf := bytes.NewBuffer(nil)
+
+		if err := DumpIndex(buf, ix); err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	a := build(rows)
+	b := build(rev)
+
+	k, _ := b.KeyDict.ID("X900")
+	m, _ := b.MemberDict.ID("Z99")
+
+	b.Delete(k)
+	b.Retire(m)
+	b.Compact()
+
+	if bytes.Equal(dump(a), dump(b)) {
+		t.Fatal("expected different files before canonicalizing")
+	}
+
+	// Members keep the order they were added in.
+	a.Canonicalize(false)
+	b.Canonicalize(false)
+
+	if bytes.Equal(dump(a), dump(b)) {
+		t.Error("expected different domains without sorting")
+	}
+
+	a.Canonicalize(true)
+	b.Canonicalize(true)
+
+	if !bytes.Equal(dump(a), dump(b)) {
+		t.Error("expected identical files")
+	}
+
+	sameLabels(t, "members", b.MemberLabels(b.Domain.Members()), []string{"E11.9", "I10", "J45", "K21"})
+
+	if s, _ := b.MemberDict.Label(b.Domain.Member(0)); s != "E11.9" {
+		t.Errorf("expected E11.9 at bit 0, got %s", s)
+	}
+
+	ms, err := b.LookupMembers("J45")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := b.Any(ms...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameLabels(t, "any(J45)", b.KeyLabels(keys), []string{"B200", "C300"})
+}
+
+func TestCanonicalizeWideKeys(t *testing.T) {
+	a := NewIndex(nil)
+	a.Add64(1<<40, 7)
+	a.Add64(5, 8)
+	a.Add64(1<<33, 7)
+
+	b := NewIndex(nil)
+	b.Add64(1<<33, 7)
+	b.Add64(5, 8)
+	b.Add64(1<<40, 7)
+
+	for _, ix := range []*Index{a, b} {
+		ix.Canonicalize(true)
+	}
+
+	for id, exp := range []uint64{5, 1 << 33, 1 << 40} {
+		if k, _ := b.WideKeys.Key(uint32(id)); k != exp {
+			t.Errorf("id %d: expected %d, got %d", id, exp, k)
+		}
+	}
+
+	if !sameKeys(a.Get(2).Bits(), b.Get(2).Bits()) || b.Domain.Member(0) != 7 {
+		t.Errorf("expected key 1<<40 to have member 7")
+	}
+}
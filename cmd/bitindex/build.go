@@ -1,17 +1,21 @@
 package main
 
 import (
-	"compress/bzip2"
-	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/chop-dbhi/bitindex"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	_ "modernc.org/sqlite"
 )
 
 func openFile(name string) (*os.File, io.Reader, error) {
@@ -21,142 +25,680 @@ func openFile(name string) (*os.File, io.Reader, error) {
 		return nil, nil, err
 	}
 
-	var r io.Reader
+	// Detect compression from the content.
+	r, err := bitindex.Decompress(f)
 
-	// Detect compression.
-	switch filepath.Ext(name) {
-	case ".gzip", ".gz":
-		r, err = gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, r, nil
+}
+
+// readHierarchy reads a CSV edge list of parent and child members. If the
+// members are labels, they are added to the dictionary.
+func readHierarchy(name string, header bool, dict *bitindex.Dictionary) (*bitindex.Hierarchy, error) {
+	f, r, err := openFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	cr := csv.NewReader(r)
+
+	if header {
+		if _, err = cr.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	h := bitindex.NewHierarchy()
+
+	for {
+		row, err := cr.Read()
+
+		if err == io.EOF {
+			break
+		}
 
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 
-	case ".bzip2", ".bz2":
-		r = bzip2.NewReader(f)
+		if len(row) < 2 {
+			return nil, fmt.Errorf("Expected parent and child columns, got %d", len(row))
+		}
+
+		var ms [2]uint32
+
+		for i, s := range row[:2] {
+			if dict != nil {
+				ms[i] = dict.Add(s)
+				continue
+			}
+
+			m, err := strconv.ParseUint(s, 10, 32)
+
+			if err != nil {
+				return nil, err
+			}
+
+			ms[i] = uint32(m)
+		}
 
-	default:
-		r = f
+		h.AddEdge(ms[0], ms[1])
 	}
 
-	return f, r, nil
+	return h, nil
 }
 
-var buildCmd = &cobra.Command{
-	Use: "build [<path>]",
+// columns returns the key and member columns of the row.
+func columns(row []string, kc, dc int) (string, string, error) {
+	if kc < 0 || dc < 0 || kc >= len(row) || dc >= len(row) {
+		return "", "", fmt.Errorf("Expected key and member columns %d and %d, got %d columns", kc, dc, len(row))
+	}
 
-	Short: "Build an index.",
+	return row[kc], row[dc], nil
+}
 
-	Run: func(cmd *cobra.Command, args []string) {
-		var (
-			r   io.Reader
-			err error
-		)
+// newCSVIndexer returns a CSV indexer of the input configured by the
+// build flags.
+func newCSVIndexer(r io.Reader) *bitindex.CSVIndexer {
+	ix := bitindex.NewCSVIndexer(r)
+	ix.Header = viper.GetBool("build.csv-header")
 
-		switch len(args) {
-		case 0:
-			r = os.Stdin
+	kc := viper.GetInt("build.csv-key")
+	dc := viper.GetInt("build.csv-domain")
 
-		case 1:
-			var f *os.File
+	// Rows with many members are expanded to rows of a key and member.
+	switch viper.GetString("build.csv-layout") {
+	case "list":
+		ix.Expand = bitindex.ListExpander(kc, dc, viper.GetString("build.csv-separator"))
+		kc, dc = 0, 1
 
-			if f, r, err = openFile(args[0]); err != nil {
-				cmd.Printf("Cannot open file: %s\n", err)
-				os.Exit(1)
+	case "matrix":
+		ix.Expand = ix.MatrixExpander(kc)
+		kc, dc = 0, 1
+	}
+
+	// Keys and members are labels encoded with dictionaries.
+	if viper.GetBool("build.labels") {
+		ix.ParseLabels = func(row []string) (string, string, error) {
+			return columns(row, kc, dc)
+		}
+	}
+
+	// Keys that do not fit in 32 bits are mapped to ids.
+	if viper.GetBool("build.wide-keys") {
+		ix.Parse64 = func(row []string) (uint64, uint32, error) {
+			ks, ds, err := columns(row, kc, dc)
+
+			if err != nil {
+				return 0, 0, err
 			}
 
-			defer f.Close()
+			ki, err := strconv.ParseUint(ks, 10, 64)
 
-		default:
-			cmd.Println("Stdin or a single file must be passed.")
-			os.Exit(1)
+			if err != nil {
+				return 0, 0, err
+			}
+
+			di, err := strconv.ParseUint(ds, 10, 32)
+
+			if err != nil {
+				return 0, 0, err
+			}
+
+			return ki, uint32(di), nil
+		}
+	}
+
+	// Values out of range are errors rather than being truncated.
+	ix.Parse = func(row []string) (uint32, uint32, error) {
+		ks, ds, err := columns(row, kc, dc)
+
+		if err != nil {
+			return 0, 0, err
 		}
 
-		var ixer bitindex.Indexer
+		ki, err := strconv.ParseUint(ks, 10, 32)
 
-		switch viper.GetString("build.format") {
-		case "csv":
-			ix := bitindex.NewCSVIndexer(r)
-			ix.Header = viper.GetBool("build.csv-header")
+		if err != nil {
+			return 0, 0, err
+		}
+
+		di, err := strconv.ParseUint(ds, 10, 32)
+
+		if err != nil {
+			return 0, 0, err
+		}
 
-			kc := viper.GetInt("build.csv-key")
-			dc := viper.GetInt("build.csv-domain")
+		return uint32(ki), uint32(di), nil
+	}
 
-			ix.Parse = func(row []string) (uint32, uint32, error) {
-				ki, err := strconv.Atoi(row[kc])
+	return ix
+}
 
-				if err != nil {
-					return 0, 0, err
-				}
+// newJSONIndexer returns a JSON Lines indexer of the input configured by
+// the build flags.
+func newJSONIndexer(r io.Reader) *bitindex.JSONIndexer {
+	ix := bitindex.NewJSONIndexer(r)
+	ix.KeyField = viper.GetString("build.json-key")
+	ix.MemberField = viper.GetString("build.json-domain")
+	ix.Labels = viper.GetBool("build.labels")
+	ix.Keys64 = viper.GetBool("build.wide-keys")
 
-				di, err := strconv.Atoi(row[dc])
+	return ix
+}
 
-				if err != nil {
-					return 0, 0, err
-				}
+// newSQLIndexer returns an indexer of the query of the build flags.
+func newSQLIndexer(db *sql.DB) *bitindex.SQLIndexer {
+	ix := bitindex.NewSQLIndexer(db, viper.GetString("build.sql"))
+	ix.Labels = viper.GetBool("build.labels")
+	ix.Keys64 = viper.GetBool("build.wide-keys")
+
+	return ix
+}
+
+// newParquetIndexer returns an indexer of the row group of the Parquet
+// file configured by the build flags.
+func newParquetIndexer(f *os.File, rowGroup int) (*bitindex.ParquetIndexer, error) {
+	fi, err := f.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ix := bitindex.NewParquetIndexer(f, fi.Size())
+	ix.KeyColumn = viper.GetString("build.parquet-key")
+	ix.MemberColumn = viper.GetString("build.parquet-domain")
+	ix.RowGroup = rowGroup
+	ix.Labels = viper.GetBool("build.labels")
+	ix.Keys64 = viper.GetBool("build.wide-keys")
+
+	return ix, nil
+}
+
+// formatIndexer is an indexer of one of the input formats along with
+// the fields they have in common.
+type formatIndexer struct {
+	bitindex.Indexer
+	bitindex.Streamer
+
+	postings *bool
+	keys     **bitindex.Dictionary
+	members  **bitindex.Dictionary
+	wide     **bitindex.KeyMap
+}
+
+// newIndexer returns an indexer of the input in the format of the build
+// flags.
+func newIndexer(r io.Reader) *formatIndexer {
+	switch viper.GetString("build.format") {
+	case "csv":
+		p := newCSVIndexer(r)
+		return &formatIndexer{p, p, &p.Postings, &p.KeyDict, &p.MemberDict, &p.WideKeys}
+
+	case "jsonl":
+		p := newJSONIndexer(r)
+		return &formatIndexer{p, p, &p.Postings, &p.KeyDict, &p.MemberDict, &p.WideKeys}
+	}
+
+	return nil
+}
+
+// sharedLabels are the dictionaries and key map shared by the inputs
+// that are streamed into the same index, so their ids do not collide.
+type sharedLabels struct {
+	keys    *bitindex.Dictionary
+	members *bitindex.Dictionary
+	wide    *bitindex.KeyMap
+}
+
+// input is stdin or a file to index. Files are only opened when they
+// are read so that many can be passed.
+type input struct {
+	path     string
+	postings bool
+	shared   *sharedLabels
+
+	// Row group of a Parquet file.
+	rowGroup int
+}
+
+// read calls f with the indexer of the input.
+func (in *input) read(f func(p *formatIndexer) error) error {
+	// Pairs are queried from the database rather than read from a file.
+	if viper.GetString("build.format") == "sql" {
+		db, err := sql.Open("sqlite", viper.GetString("build.dsn"))
+
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		p := newSQLIndexer(db)
+		return f(&formatIndexer{p, p, &p.Postings, &p.KeyDict, &p.MemberDict, &p.WideKeys})
+	}
+
+	// Parquet files are read in place rather than streamed.
+	if viper.GetString("build.format") == "parquet" {
+		file, err := os.Open(in.path)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		p, err := newParquetIndexer(file, in.rowGroup)
+
+		if err == nil {
+			err = f(&formatIndexer{p, p, &p.Postings, &p.KeyDict, &p.MemberDict, &p.WideKeys})
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", in.path, err)
+		}
+
+		return nil
+	}
+
+	if in.path == "" {
+		r, err := bitindex.Decompress(os.Stdin)
+
+		if err != nil {
+			return err
+		}
+
+		return f(newIndexer(r))
+	}
+
+	file, r, err := openFile(in.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err = f(newIndexer(r)); err != nil {
+		return fmt.Errorf("%s: %s", in.path, err)
+	}
+
+	return nil
+}
+
+// Index implements bitindex.Indexer.
+func (in *input) Index() (*bitindex.Index, error) {
+	var idx *bitindex.Index
+
+	err := in.read(func(p *formatIndexer) (err error) {
+		*p.postings = in.postings
+		idx, err = p.Index()
+		return err
+	})
+
+	return idx, err
+}
+
+// Stream implements bitindex.Streamer.
+func (in *input) Stream(f func(k uint32, m uint32) error) error {
+	return in.read(func(p *formatIndexer) error {
+		*p.keys = in.shared.keys
+		*p.members = in.shared.members
+		*p.wide = in.shared.wide
+
+		err := p.Stream(f)
+
+		// Set by the first input if they were not shared yet.
+		in.shared.keys = *p.keys
+		in.shared.members = *p.members
+		in.shared.wide = *p.wide
+
+		return err
+	})
+}
+
+// inputs streams the inputs one after the other.
+type inputs []*input
+
+// Stream implements bitindex.Streamer.
+func (ins inputs) Stream(f func(k uint32, m uint32) error) error {
+	for _, in := range ins {
+		if err := in.Stream(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expandInputs returns the input paths with the glob patterns expanded.
+// Stdin is read if no paths are passed.
+func expandInputs(args []string, shared *sharedLabels) (inputs, error) {
+	if len(args) == 0 {
+		return inputs{{shared: shared}}, nil
+	}
 
-				return uint32(ki), uint32(di), nil
+	var ins inputs
+
+	for _, arg := range args {
+		paths := []string{arg}
+
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+
+			if paths, err = filepath.Glob(arg); err != nil {
+				return nil, err
+			}
+
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("No files match %s", arg)
 			}
+		}
 
-			ixer = ix
+		for _, path := range paths {
+			ins = append(ins, &input{path: path, shared: shared})
+		}
+	}
+
+	return ins, nil
+}
+
+// expandRowGroups returns an input for each row group of the Parquet
+// files so they are indexed in parallel.
+func expandRowGroups(ins inputs) (inputs, error) {
+	var out inputs
+
+	for _, in := range ins {
+		f, err := os.Open(in.path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := f.Stat()
+
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		n, err := bitindex.ParquetRowGroups(f, fi.Size())
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", in.path, err)
+		}
+
+		for i := 0; i < n; i++ {
+			out = append(out, &input{path: in.path, shared: in.shared, rowGroup: i})
+		}
+	}
+
+	return out, nil
+}
+
+// appendDelta streams the pairs into the delta file of the index and
+// returns the number of pairs appended. Adding a pair is idempotent, so
+// a failed append can be retried.
+func appendDelta(ins inputs, shared *sharedLabels, path string) (int, error) {
+	dw, err := bitindex.OpenDelta(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	labels := viper.GetBool("build.labels")
+
+	// New labels and keys are added to the ones of the index.
+	switch {
+	case labels != (dw.KeyDict != nil):
+		err = fmt.Errorf("--labels must be passed if and only if the index is labeled")
+
+	case !labels && viper.GetBool("build.wide-keys") != (dw.WideKeys != nil):
+		err = fmt.Errorf("--wide-keys must be passed if and only if the index has 64-bit keys")
+	}
+
+	if err != nil {
+		dw.Close()
+		return 0, err
+	}
+
+	shared.keys = dw.KeyDict
+	shared.members = dw.MemberDict
+	shared.wide = dw.WideKeys
+
+	var n int
+
+	err = ins.Stream(func(k uint32, m uint32) error {
+		n++
+		return dw.Add(k, m)
+	})
+
+	if cerr := dw.Close(); err == nil {
+		err = cerr
+	}
+
+	return n, err
+}
+
+var buildCmd = &cobra.Command{
+	Use: "build [<path>...]",
+
+	Short: "Build an index.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		switch viper.GetString("build.format") {
+		case "csv", "jsonl":
+		case "sql":
+			if len(args) > 0 || viper.GetString("build.dsn") == "" || viper.GetString("build.sql") == "" {
+				cmd.Println("--dsn and --sql are required instead of files for the sql format")
+				os.Exit(1)
+			}
+
+		case "parquet":
+			if len(args) == 0 {
+				cmd.Println("Parquet files must be passed rather than stdin")
+				os.Exit(1)
+			}
 
 		default:
-			cmd.Println("--format flag is required")
+			cmd.Println("--format must be csv, jsonl, sql or parquet")
 			os.Exit(1)
 		}
 
-		t0 := time.Now()
-		idx, err := ixer.Index()
-		bt := time.Now().Sub(t0)
+		switch viper.GetString("build.csv-layout") {
+		case "pairs", "list":
+		case "matrix":
+			if !viper.GetBool("build.csv-header") {
+				cmd.Println("--csv-header is required to name the members of a matrix")
+				os.Exit(1)
+			}
 
-		if err != nil {
-			cmd.Printf("Error building index: %s\n", err)
+		default:
+			cmd.Println("--csv-layout must be pairs, list or matrix")
 			os.Exit(1)
 		}
 
-		output := viper.GetString("build.output")
+		shared := &sharedLabels{}
 
-		var w io.Writer
+		ins, err := expandInputs(args, shared)
 
-		// Build output file.
-		if output == "" {
-			w = os.Stdout
-		} else {
-			o, err := os.Create(output)
+		if err == nil && viper.GetString("build.format") == "parquet" {
+			ins, err = expandRowGroups(ins)
+		}
+
+		if err != nil {
+			cmd.Printf("Cannot open file: %s\n", err)
+			os.Exit(1)
+		}
+
+		// Append to the delta of an existing index instead of building one.
+		if base := viper.GetString("build.delta"); base != "" {
+			n, err := appendDelta(ins, shared, base)
 
 			if err != nil {
-				cmd.Println("Error opening file to write:", err)
+				cmd.Printf("Error appending to delta: %s\n", err)
 				os.Exit(1)
 			}
 
-			w = o
+			cmd.Println("Pairs appended:", n)
+			return
 		}
 
-		t0 = time.Now()
+		output := viper.GetString("build.output")
+
+		codec, err := bitindex.ParseCodec(viper.GetString("build.compress"))
 
-		if err := bitindex.DumpIndex(w, idx); err != nil {
-			cmd.Println("Error dumping index:", err)
+		if err != nil {
+			cmd.Println(err)
 			os.Exit(1)
 		}
 
-		wt := time.Now().Sub(t0)
+		// The index is written to a temporary file that replaces the
+		// output once it is complete, so a failed build leaves an
+		// existing index in place. The delta of the index it replaces
+		// is removed.
+		write := func(f func(w io.Writer) error) error {
+			if output == "" {
+				return f(os.Stdout)
+			}
+
+			return replaceIndex(output, f)
+		}
+
+		var (
+			stats  *bitindex.Stats
+			bt, wt time.Duration
+			hier   = viper.GetString("build.hierarchy")
+		)
+
+		t0 := time.Now()
+
+		if viper.GetBool("build.external") {
+			if viper.GetBool("build.canonical") || viper.GetBool("build.sort-domain") {
+				cmd.Println("--canonical cannot be used with --external, use the compact command instead")
+				os.Exit(1)
+			}
+
+			// Pairs are spilled to disk and merged straight into the
+			// output, so the index is never held in memory. Multiple
+			// inputs are read one after the other.
+
+			b := bitindex.NewBuilder()
+			b.TempDir = viper.GetString("build.temp-dir")
+			b.RunSize = viper.GetInt("build.run-size")
+			b.Postings = viper.GetBool("build.postings")
+			b.Codec = codec
+
+			// The builder is closed before exiting to remove its runs.
+			if err = b.Build(ins); err != nil {
+				err = fmt.Errorf("Error building index: %s", err)
+			}
+
+			b.KeyDict = shared.keys
+			b.MemberDict = shared.members
+			b.WideKeys = shared.wide
+
+			if err == nil && hier != "" {
+				if b.Hierarchy, err = readHierarchy(hier, viper.GetBool("build.hierarchy-header"), b.MemberDict); err != nil {
+					err = fmt.Errorf("Error reading hierarchy: %s", err)
+				}
+			}
+
+			bt = time.Now().Sub(t0)
+			t0 = time.Now()
+
+			if err == nil {
+				err = write(func(w io.Writer) (err error) {
+					stats, err = b.Dump(w)
+					return err
+				})
+
+				if err != nil {
+					err = fmt.Errorf("Error dumping index: %s", err)
+				}
+			}
+
+			wt = time.Now().Sub(t0)
+
+			b.Close()
+
+			if err != nil {
+				cmd.Println(err)
+				os.Exit(1)
+			}
+		} else {
+			var idx *bitindex.Index
+
+			postings := viper.GetBool("build.postings")
+
+			// Multiple inputs are indexed in parallel and merged. The
+			// postings are built once they are merged.
+			if len(ins) == 1 {
+				ins[0].postings = postings
+				idx, err = ins[0].Index()
+			} else {
+				ixers := make([]bitindex.Indexer, len(ins))
+
+				for i, in := range ins {
+					ixers[i] = in
+				}
+
+				if idx, err = bitindex.IndexParallel(viper.GetInt("build.jobs"), ixers...); err == nil && postings {
+					idx.Postings = bitindex.BuildPostings(idx.Table)
+				}
+			}
+
+			bt = time.Now().Sub(t0)
+
+			if err != nil {
+				cmd.Printf("Error building index: %s\n", err)
+				os.Exit(1)
+			}
 
-		// Coerce to file, sync and close.
-		if f, ok := w.(*os.File); ok {
-			if err = f.Sync(); err != nil {
-				cmd.Printf("Error syncing file: %s\n", err)
+			if hier != "" {
+				if idx.Hierarchy, err = readHierarchy(hier, viper.GetBool("build.hierarchy-header"), idx.MemberDict); err != nil {
+					cmd.Printf("Error reading hierarchy: %s\n", err)
+					os.Exit(1)
+				}
 			}
 
-			if err = f.Close(); err != nil {
-				cmd.Printf("Error closing file: %s\n", err)
+			// Ids and bits are renumbered so identical pairs are written
+			// to identical files.
+			if sorted := viper.GetBool("build.sort-domain"); sorted || viper.GetBool("build.canonical") {
+				if err = idx.Canonicalize(sorted); err != nil {
+					cmd.Println("Error canonicalizing index:", err)
+					os.Exit(1)
+				}
 			}
+
+			t0 = time.Now()
+
+			err = write(func(w io.Writer) error {
+				return bitindex.DumpCompressed(w, idx, codec)
+			})
+
+			if err != nil {
+				cmd.Println("Error dumping index:", err)
+				os.Exit(1)
+			}
+
+			wt = time.Now().Sub(t0)
+			stats = idx.Stats()
 		}
 
 		cmd.Println("Build time:", bt)
 		cmd.Println("Write time:", wt)
-		cmd.Println("Domain size:", idx.Domain.Size())
-		cmd.Println("Table size:", idx.Table.Size())
-		cmd.Println("Sparsity:", idx.Sparsity()*100)
+		cmd.Println("Domain size:", stats.DomainSize)
+		cmd.Println("Table size:", stats.TableSize)
+		cmd.Println("Sparsity:", stats.Sparsity()*100)
 	},
 }
 
@@ -164,21 +706,90 @@ func init() {
 	flags := buildCmd.Flags()
 
 	// General.
-	flags.String("format", "", "Format of the input stream: csv")
+	flags.String("format", "", "Format of the input stream: csv, jsonl, sql or parquet")
 	flags.String("output", "", "Specify an output file to write the stream to.")
+	flags.Bool("postings", false, "Build the inverted postings for faster queries.")
+	flags.Bool("labels", false, "Keys and members are string labels rather than integers.")
+	flags.Bool("wide-keys", false, "Keys are 64-bit integers.")
 
 	// format is required.
 	buildCmd.MarkFlagRequired("format")
 
 	viper.BindPFlag("build.format", flags.Lookup("format"))
 	viper.BindPFlag("build.output", flags.Lookup("output"))
+	viper.BindPFlag("build.postings", flags.Lookup("postings"))
+	viper.BindPFlag("build.labels", flags.Lookup("labels"))
+	viper.BindPFlag("build.wide-keys", flags.Lookup("wide-keys"))
+
+	// Reproducible output.
+	flags.Bool("canonical", false, "Renumber labels and keys in sorted order so identical pairs yield identical files.")
+	flags.Bool("sort-domain", false, "Also assign the bits of the domain in sorted member order. Implies --canonical.")
+
+	viper.BindPFlag("build.canonical", flags.Lookup("canonical"))
+	viper.BindPFlag("build.sort-domain", flags.Lookup("sort-domain"))
+
+	// Compression of the index file.
+	flags.String("compress", "none", "Codec of the index file: none, gzip, zstd or xz.")
+
+	viper.BindPFlag("build.compress", flags.Lookup("compress"))
+
+	// Multiple inputs.
+	flags.Int("jobs", runtime.NumCPU(), "Number of inputs indexed in parallel.")
+
+	viper.BindPFlag("build.jobs", flags.Lookup("jobs"))
+
+	// Delta of an existing index.
+	flags.String("delta", "", "Append the pairs to the delta file of this index instead of building one.")
+
+	viper.BindPFlag("build.delta", flags.Lookup("delta"))
+
+	// Member hierarchy.
+	flags.String("hierarchy", "", "CSV file of parent and child members to expand with descendants().")
+	flags.Bool("hierarchy-header", false, "Hierarchy file has a header")
+
+	viper.BindPFlag("build.hierarchy", flags.Lookup("hierarchy"))
+	viper.BindPFlag("build.hierarchy-header", flags.Lookup("hierarchy-header"))
+
+	// External build.
+	flags.Bool("external", false, "Build the index on disk for inputs that do not fit in memory.")
+	flags.Int("run-size", bitindex.DefaultRunSize, "Number of rows sorted in memory before spilling to disk.")
+	flags.String("temp-dir", "", "Directory for temporary files of an external build.")
+
+	viper.BindPFlag("build.external", flags.Lookup("external"))
+	viper.BindPFlag("build.run-size", flags.Lookup("run-size"))
+	viper.BindPFlag("build.temp-dir", flags.Lookup("temp-dir"))
 
 	// CSV indexer.
 	flags.Bool("csv-header", false, "CSV file has a header")
 	flags.Int("csv-key", 0, "Index of the column containing set keys.")
 	flags.Int("csv-domain", 1, "Index of the column containing domain members.")
+	flags.String("csv-layout", "pairs", "Layout of the rows: pairs of a key and member, list of a key and delimited members, or matrix of a key and a 0/1 column per member.")
+	flags.String("csv-separator", ";", "Separator of the members of a list.")
 
 	viper.BindPFlag("build.csv-header", flags.Lookup("csv-header"))
 	viper.BindPFlag("build.csv-key", flags.Lookup("csv-key"))
 	viper.BindPFlag("build.csv-domain", flags.Lookup("csv-domain"))
+	viper.BindPFlag("build.csv-layout", flags.Lookup("csv-layout"))
+	viper.BindPFlag("build.csv-separator", flags.Lookup("csv-separator"))
+
+	// JSON Lines indexer.
+	flags.String("json-key", "key", "Path of the field containing set keys. Nested fields are separated by dots.")
+	flags.String("json-domain", "member", "Path of the field containing domain members or an array of them.")
+
+	viper.BindPFlag("build.json-key", flags.Lookup("json-key"))
+	viper.BindPFlag("build.json-domain", flags.Lookup("json-domain"))
+
+	// SQL indexer.
+	flags.String("dsn", "", "Data source name of the SQLite database, e.g. the path of the file.")
+	flags.String("sql", "", "Query returning the key and member columns of each pair.")
+
+	viper.BindPFlag("build.dsn", flags.Lookup("dsn"))
+	viper.BindPFlag("build.sql", flags.Lookup("sql"))
+
+	// Parquet indexer.
+	flags.String("parquet-key", "key", "Path of the column containing set keys. Nested columns are separated by dots.")
+	flags.String("parquet-domain", "member", "Path of the column containing domain members, which may be repeated.")
+
+	viper.BindPFlag("build.parquet-key", flags.Lookup("parquet-key"))
+	viper.BindPFlag("build.parquet-domain", flags.Lookup("parquet-domain"))
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+
+	"github.com/chop-dbhi/bitindex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var compactCmd = &cobra.Command{
+	Use: "compact <index>",
+
+	Short: "Folds the delta file into the index.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Println("An index file is required.")
+			os.Exit(1)
+		}
+
+		codec, err := outputCodec(viper.GetString("compact.compress"), args[0])
+
+		if err != nil {
+			cmd.Println("Error reading codec:", err)
+			os.Exit(1)
+		}
+
+		idx, err := bitindex.LoadIndexFile(args[0])
+
+		if err != nil {
+			cmd.Println("Error loading index file:", err)
+			os.Exit(1)
+		}
+
+		idx.Pack()
+
+		if err = idx.Compact(); err != nil {
+			cmd.Println("Error compacting index:", err)
+			os.Exit(1)
+		}
+
+		output := viper.GetString("compact.output")
+
+		if output == "" {
+			output = args[0]
+		}
+
+		if sorted := viper.GetBool("compact.sort-domain"); sorted || viper.GetBool("compact.canonical") {
+			if err = idx.Canonicalize(sorted); err != nil {
+				cmd.Println("Error canonicalizing index:", err)
+				os.Exit(1)
+			}
+		}
+
+		if err = writeIndex(output, idx, codec); err != nil {
+			cmd.Println("Error dumping index:", err)
+			os.Exit(1)
+		}
+
+		stats := idx.Stats()
+
+		cmd.Println("Domain size:", stats.DomainSize)
+		cmd.Println("Table size:", stats.TableSize)
+		cmd.Println("Sparsity:", stats.Sparsity()*100)
+	},
+}
+
+func init() {
+	flags := compactCmd.Flags()
+
+	flags.String("output", "", "Write the compacted index to this file instead of in place.")
+	flags.Bool("canonical", false, "Renumber labels and keys in sorted order so identical pairs yield identical files.")
+	flags.Bool("sort-domain", false, "Also assign the bits of the domain in sorted member order. Implies --canonical.")
+	flags.String("compress", "", "Codec of the index file: none, gzip, zstd or xz. Defaults to the codec of the index.")
+
+	viper.BindPFlag("compact.output", flags.Lookup("output"))
+	viper.BindPFlag("compact.canonical", flags.Lookup("canonical"))
+	viper.BindPFlag("compact.sort-domain", flags.Lookup("sort-domain"))
+	viper.BindPFlag("compact.compress", flags.Lookup("compress"))
+}
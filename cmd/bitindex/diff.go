@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+
+	"github.com/chop-dbhi/bitindex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var diffCmd = &cobra.Command{
+	Use: "diff <old> <new>",
+
+	Short: "Outputs the changes from one index to another.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 2 {
+			cmd.Println("An old and a new index file are required.")
+			os.Exit(1)
+		}
+
+		format := viper.GetString("diff.format")
+
+		if format != "summary" && format != "jsonl" {
+			cmd.Println("--format must be summary or jsonl")
+			os.Exit(1)
+		}
+
+		idxs := make([]*bitindex.Index, 2)
+
+		for i, path := range args {
+			idx, err := openIndex(path)
+
+			if err != nil {
+				cmd.Printf("Error opening index file %s: %s\n", path, err)
+				os.Exit(1)
+			}
+
+			defer idx.Close()
+
+			idxs[i] = idx
+		}
+
+		d, err := bitindex.Diff(idxs[0], idxs[1])
+
+		if err != nil {
+			cmd.Println("Error comparing indexes:", err)
+			os.Exit(1)
+		}
+
+		if format == "summary" {
+			added, removed := d.Pairs()
+
+			cmd.Println("Members added:", len(d.MembersAdded))
+			cmd.Println("Members removed:", len(d.MembersRemoved))
+			cmd.Println("Keys added:", len(d.KeysAdded))
+			cmd.Println("Keys removed:", len(d.KeysRemoved))
+			cmd.Println("Keys changed:", len(d.KeysChanged))
+			cmd.Println("Pairs added:", added)
+			cmd.Println("Pairs removed:", removed)
+			return
+		}
+
+		if output := viper.GetString("diff.output"); output == "" {
+			err = d.WriteJSON(os.Stdout)
+		} else {
+			err = writeFile(output, d.WriteJSON)
+		}
+
+		if err != nil {
+			cmd.Println("Error writing changes:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	flags := diffCmd.Flags()
+
+	flags.String("format", "summary", "Format of the changes: summary of the counts or jsonl with a change per line.")
+	flags.String("output", "", "File to write the jsonl changes to instead of stdout.")
+
+	viper.BindPFlag("diff.format", flags.Lookup("format"))
+	viper.BindPFlag("diff.output", flags.Lookup("output"))
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/chop-dbhi/bitindex"
@@ -9,6 +10,36 @@ import (
 	"github.com/spf13/viper"
 )
 
+// loadDomain loads the domain and, if labels is true, the member
+// dictionary of the index file without reading the rest of it.
+func loadDomain(path string, labels bool) (*bitindex.Domain, *bitindex.Dictionary, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer f.Close()
+
+	d, err := bitindex.LoadDomain(f)
+
+	if err != nil || !labels {
+		return d, nil, err
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	dict, err := bitindex.LoadMemberDict(f)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return d, dict, nil
+}
+
 var domainCmd = &cobra.Command{
 	Use: "domain <index>",
 
@@ -20,16 +51,26 @@ var domainCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		f, err := os.Open(args[0])
+		path := args[0]
 
-		if err != nil {
-			cmd.Println("Error opening file:", err)
-			os.Exit(1)
-		}
+		var (
+			d    *bitindex.Domain
+			dict *bitindex.Dictionary
+			err  error
+		)
+
+		// The delta can only be applied to the whole index.
+		if hasDelta(path) {
+			var idx *bitindex.Index
 
-		var d *bitindex.Domain
+			if idx, err = bitindex.LoadIndexFile(path); err != nil {
+				cmd.Println("Error loading index file:", err)
+				os.Exit(1)
+			}
 
-		if d, err = bitindex.LoadDomain(f); err != nil {
+			d = idx.Domain
+			dict = idx.MemberDict
+		} else if d, dict, err = loadDomain(path, viper.GetBool("domain.members")); err != nil {
 			cmd.Println("Error loading index file:", err)
 			os.Exit(1)
 		}
@@ -40,7 +81,12 @@ var domainCmd = &cobra.Command{
 
 		if viper.GetBool("domain.members") {
 			for _, m := range d.Members() {
-				fmt.Fprintln(os.Stdout, m)
+				if dict != nil {
+					l, _ := dict.Label(m)
+					fmt.Fprintln(os.Stdout, l)
+				} else {
+					fmt.Fprintln(os.Stdout, m)
+				}
 			}
 		}
 	},
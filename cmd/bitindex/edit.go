@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chop-dbhi/bitindex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// wildcard matches every key or member in a row of a delete file.
+const wildcard = "*"
+
+// edits counts the changes applied from a delete file.
+type edits struct {
+	keys    int
+	removed int
+	retired int
+
+	// Rows whose key or member is not in the index.
+	skipped int
+}
+
+// applyDeletes applies the rows of a delete file to the index. Each row
+// is a key and a member. A key with the member `*` is deleted, a member
+// with the key `*` is retired and otherwise the key's membership is
+// removed. Rows whose key or member is not in the index are skipped so
+// the same file can be applied again.
+func applyDeletes(idx *bitindex.Index, r io.Reader, header bool) (*edits, error) {
+	cr := csv.NewReader(r)
+
+	if header {
+		if _, err := cr.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	var e edits
+
+	for {
+		row, err := cr.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(row) < 2 {
+			return nil, fmt.Errorf("Expected key and member columns, got %d", len(row))
+		}
+
+		switch {
+		case row[0] == wildcard && row[1] == wildcard:
+			return nil, fmt.Errorf("Key and member cannot both be %s", wildcard)
+
+		case row[1] == wildcard:
+			ks, err := idx.LookupKeys(row[0])
+
+			if err != nil {
+				e.skipped++
+				continue
+			}
+
+			ok, err := idx.Delete(ks[0])
+
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				e.keys++
+			}
+
+		case row[0] == wildcard:
+			ms, err := idx.LookupMembers(row[1])
+
+			if err != nil {
+				e.skipped++
+				continue
+			}
+
+			for _, m := range ms {
+				ok, err := idx.Retire(m)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					e.retired++
+				}
+			}
+
+		default:
+			ks, err := idx.LookupKeys(row[0])
+
+			if err != nil {
+				e.skipped++
+				continue
+			}
+
+			ms, err := idx.LookupMembers(row[1])
+
+			if err != nil {
+				e.skipped++
+				continue
+			}
+
+			for _, m := range ms {
+				ok, err := idx.Remove(ks[0], m)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if ok {
+					e.removed++
+				}
+			}
+		}
+	}
+
+	return &e, nil
+}
+
+var editCmd = &cobra.Command{
+	Use: "edit <index>",
+
+	Short: "Applies a delete file to an index.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Println("An index file is required.")
+			os.Exit(1)
+		}
+
+		deletes := viper.GetString("edit.deletes")
+
+		if deletes == "" {
+			cmd.Println("--deletes flag is required")
+			os.Exit(1)
+		}
+
+		codec, err := outputCodec(viper.GetString("edit.compress"), args[0])
+
+		if err != nil {
+			cmd.Println("Error reading codec:", err)
+			os.Exit(1)
+		}
+
+		idx, err := bitindex.LoadIndexFile(args[0])
+
+		if err != nil {
+			cmd.Println("Error loading index file:", err)
+			os.Exit(1)
+		}
+
+		df, r, err := openFile(deletes)
+
+		if err != nil {
+			cmd.Printf("Cannot open file: %s\n", err)
+			os.Exit(1)
+		}
+
+		e, err := applyDeletes(idx, r, viper.GetBool("edit.csv-header"))
+		df.Close()
+
+		if err != nil {
+			cmd.Println("Error applying deletes:", err)
+			os.Exit(1)
+		}
+
+		if err = idx.Compact(); err != nil {
+			cmd.Println("Error compacting index:", err)
+			os.Exit(1)
+		}
+
+		output := viper.GetString("edit.output")
+
+		if output == "" {
+			output = args[0]
+		}
+
+		if err = writeIndex(output, idx, codec); err != nil {
+			cmd.Println("Error dumping index:", err)
+			os.Exit(1)
+		}
+
+		cmd.Println("Keys deleted:", e.keys)
+		cmd.Println("Members removed:", e.removed)
+		cmd.Println("Members retired:", e.retired)
+		cmd.Println("Rows skipped:", e.skipped)
+		cmd.Println("Domain size:", idx.Domain.Size())
+		cmd.Println("Table size:", idx.Size())
+	},
+}
+
+func init() {
+	flags := editCmd.Flags()
+
+	flags.String("deletes", "", "CSV file of keys and members to delete.")
+	flags.String("output", "", "Write the edited index to this file instead of in place.")
+	flags.Bool("csv-header", false, "Delete file has a header")
+	flags.String("compress", "", "Codec of the index file: none, gzip, zstd or xz. Defaults to the codec of the index.")
+
+	viper.BindPFlag("edit.deletes", flags.Lookup("deletes"))
+	viper.BindPFlag("edit.output", flags.Lookup("output"))
+	viper.BindPFlag("edit.csv-header", flags.Lookup("csv-header"))
+	viper.BindPFlag("edit.compress", flags.Lookup("compress"))
+}
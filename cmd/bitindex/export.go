@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/chop-dbhi/bitindex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var exportLayouts = map[string]bitindex.ExportLayout{
+	"pairs":  bitindex.ExportPairs,
+	"list":   bitindex.ExportList,
+	"matrix": bitindex.ExportMatrix,
+}
+
+var exportCmd = &cobra.Command{
+	Use: "export <index>",
+
+	Short: "Writes the key and member pairs of an index.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Println("An index file is required.")
+			os.Exit(1)
+		}
+
+		format := viper.GetString("export.format")
+
+		switch format {
+		case "csv", "tsv", "jsonl", "parquet":
+		default:
+			cmd.Println("--format must be csv, tsv, jsonl or parquet")
+			os.Exit(1)
+		}
+
+		layout, ok := exportLayouts[viper.GetString("export.layout")]
+
+		if !ok {
+			cmd.Println("--layout must be pairs, list or matrix")
+			os.Exit(1)
+		}
+
+		if format == "parquet" && layout != bitindex.ExportPairs {
+			cmd.Println("Parquet is only written in the pairs layout")
+			os.Exit(1)
+		}
+
+		idx, err := openIndex(args[0])
+
+		if err != nil {
+			cmd.Println("Error opening index file:", err)
+			os.Exit(1)
+		}
+
+		defer idx.Close()
+
+		e := bitindex.NewExporter()
+		e.Layout = layout
+		e.KeyName = viper.GetString("export.key-name")
+		e.MemberName = viper.GetString("export.domain-name")
+		e.Separator = viper.GetString("export.separator")
+		e.Header = viper.GetBool("export.header")
+
+		// The output file replaces an existing one only once the export
+		// is complete.
+		export := func(w io.Writer) (err error) {
+			bw := bufio.NewWriter(w)
+
+			switch format {
+			case "csv":
+				err = e.CSV(bw, idx)
+
+			case "tsv":
+				e.Comma = '\t'
+				err = e.CSV(bw, idx)
+
+			case "jsonl":
+				err = e.JSON(bw, idx)
+
+			case "parquet":
+				err = bitindex.DumpParquet(bw, idx, e.KeyName, e.MemberName, viper.GetInt("export.row-group-size"))
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return bw.Flush()
+		}
+
+		if output := viper.GetString("export.output"); output == "" {
+			err = export(os.Stdout)
+		} else {
+			err = writeFile(output, export)
+		}
+
+		if err != nil {
+			cmd.Println("Error exporting index:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	flags := exportCmd.Flags()
+
+	flags.String("format", "", "Format of the output: csv, tsv, jsonl or parquet")
+	flags.String("layout", "pairs", "Layout of the rows: pairs of a key and member, list of a key and its members, or matrix of a key and a 0/1 column per member.")
+	flags.String("output", "", "File to write to instead of stdout.")
+	flags.String("key-name", "key", "Name of the key column or field.")
+	flags.String("domain-name", "member", "Name of the member column or field.")
+	flags.String("separator", ";", "Separator of the members of a list in CSV and TSV.")
+	flags.Bool("header", true, "Write a header row in CSV and TSV.")
+	flags.Int("row-group-size", 1<<20, "Number of pairs per Parquet row group, or 0 for a single row group.")
+
+	viper.BindPFlag("export.format", flags.Lookup("format"))
+	viper.BindPFlag("export.layout", flags.Lookup("layout"))
+	viper.BindPFlag("export.output", flags.Lookup("output"))
+	viper.BindPFlag("export.key-name", flags.Lookup("key-name"))
+	viper.BindPFlag("export.domain-name", flags.Lookup("domain-name"))
+	viper.BindPFlag("export.separator", flags.Lookup("separator"))
+	viper.BindPFlag("export.header", flags.Lookup("header"))
+	viper.BindPFlag("export.row-group-size", flags.Lookup("row-group-size"))
+}
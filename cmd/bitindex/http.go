@@ -13,14 +13,152 @@ import (
 
 const StatusUnprocessableEntity = 422
 
+// labels is a list of members given as JSON numbers or, for an index
+// with dictionaries, strings.
+type labels []string
+
+func (l *labels) UnmarshalJSON(b []byte) error {
+	// Like an omitted operation, null leaves the labels nil so the
+	// operation is skipped.
+	if string(b) == "null" {
+		return nil
+	}
+
+	var vs []json.RawMessage
+
+	if err := json.Unmarshal(b, &vs); err != nil {
+		return err
+	}
+
+	*l = make(labels, len(vs))
+
+	for i, v := range vs {
+		if len(v) > 0 && v[0] == '"' {
+			if err := json.Unmarshal(v, &(*l)[i]); err != nil {
+				return err
+			}
+		} else {
+			(*l)[i] = string(v)
+		}
+	}
+
+	return nil
+}
+
+type threshold struct {
+	N       int
+	Members labels
+}
+
 type query struct {
-	Any      []uint32
-	Nany     []uint32
-	All      []uint32
-	Nall     []uint32
+	Any      labels
+	Nany     labels
+	All      labels
+	Nall     labels
+	Atleast  *threshold
+	Atmost   *threshold
+	Expr     string
 	Smallest bool
 }
 
+// hasOperations returns true if the query has an operation other than
+// the expression.
+func (q *query) hasOperations() bool {
+	return len(q.Any) > 0 || len(q.All) > 0 || len(q.Nany) > 0 || len(q.Nall) > 0 || q.Atleast != nil || q.Atmost != nil
+}
+
+// decodeQuery decodes the body of a /query or /count request. It writes
+// the error and returns false if the body is invalid.
+func decodeQuery(w http.ResponseWriter, r *http.Request, q *query) bool {
+	if err := json.NewDecoder(r.Body).Decode(q); err != nil {
+		w.WriteHeader(StatusUnprocessableEntity)
+		fmt.Fprint(w, err)
+		return false
+	}
+
+	// Like the query command, an expression is not combined with the
+	// other operations rather than silently ignoring them.
+	if q.Expr != "" && q.hasOperations() {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, errExprOperations)
+		return false
+	}
+
+	return true
+}
+
+// operations are the members of the query looked up in the index.
+type operations struct {
+	any, all, nany, nall []uint32
+	thresholds           []bitindex.Threshold
+}
+
+// operations looks up the members of the query in the index.
+func (q *query) operations(idx *bitindex.Index) (*operations, error) {
+	var (
+		o   operations
+		err error
+	)
+
+	if o.any, err = idx.LookupMembers(q.Any...); err != nil {
+		return nil, err
+	}
+
+	if o.all, err = idx.LookupMembers(q.All...); err != nil {
+		return nil, err
+	}
+
+	if o.nany, err = idx.LookupMembers(q.Nany...); err != nil {
+		return nil, err
+	}
+
+	if o.nall, err = idx.LookupMembers(q.Nall...); err != nil {
+		return nil, err
+	}
+
+	ts := []struct {
+		t    *threshold
+		most bool
+	}{
+		{q.Atleast, false},
+		{q.Atmost, true},
+	}
+
+	for _, t := range ts {
+		if t.t == nil {
+			continue
+		}
+
+		ms, err := idx.LookupMembers(t.t.Members...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		o.thresholds = append(o.thresholds, bitindex.Threshold{
+			N:       t.t.N,
+			Members: ms,
+			AtMost:  t.most,
+		})
+	}
+
+	return &o, nil
+}
+
+// keysJSON returns the keys, or their labels if the index has a key
+// dictionary, for encoding.
+func keysJSON(idx *bitindex.Index, keys []uint32) interface{} {
+	if idx.KeyDict != nil {
+		return idx.KeyLabels(keys)
+	}
+
+	if idx.WideKeys != nil {
+		return idx.Keys64(keys)
+	}
+
+	return keys
+}
+
 var httpCmd = &cobra.Command{
 	Use: "http <index>",
 
@@ -30,26 +168,21 @@ var httpCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		f, err := os.Open(args[0])
+		idx, err := openIndex(args[0])
 
 		if err != nil {
-			cmd.Println("Error opening file:", err)
+			cmd.Println("Error opening index file:", err)
 			os.Exit(1)
 		}
 
-		var idx *bitindex.Index
-
-		if idx, err = bitindex.LoadIndex(f); err != nil {
-			cmd.Println("Error loading index file:", err)
-			os.Exit(1)
-		}
+		defer idx.Close()
 
 		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("content-type", "application/json")
 
 			v := map[string]interface{}{
 				"domain_size":    idx.Domain.Size(),
-				"table_size":     idx.Table.Size(),
+				"table_size":     idx.Size(),
 				"index_sparsity": idx.Sparsity(),
 			}
 
@@ -63,18 +196,36 @@ var httpCmd = &cobra.Command{
 		http.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("content-type", "application/json")
 
-			// Expected up to 4 keys, one for each operator.
+			// Expected up to 6 keys, one for each operator.
 			q := query{}
 
 			defer r.Body.Close()
 
-			if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
-				w.WriteHeader(StatusUnprocessableEntity)
-				fmt.Fprint(w, err)
+			if !decodeQuery(w, r, &q) {
 				return
 			}
 
-			res, err := idx.Query(q.Any, q.All, q.Nany, q.Nall)
+			var (
+				res  *bitindex.Result
+				expr bitindex.Expr
+				err  error
+			)
+
+			if q.Expr != "" {
+				if expr, err = bitindex.ParseExpr(q.Expr); err != nil {
+					w.WriteHeader(StatusUnprocessableEntity)
+					fmt.Fprint(w, err)
+					return
+				}
+
+				res, err = idx.QueryExpr(expr)
+			} else {
+				var o *operations
+
+				if o, err = q.operations(idx); err == nil {
+					res, err = idx.Query(o.any, o.all, o.nany, o.nall, o.thresholds...)
+				}
+			}
 
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
@@ -104,7 +255,7 @@ var httpCmd = &cobra.Command{
 			}
 
 			resp := map[string]interface{}{
-				"items":      items,
+				"items":      keysJSON(idx, items),
 				"complement": complement,
 			}
 
@@ -115,10 +266,61 @@ var httpCmd = &cobra.Command{
 			}
 		})
 
+		http.HandleFunc("/count", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/json")
+
+			// Same body as /query.
+			q := query{}
+
+			defer r.Body.Close()
+
+			if !decodeQuery(w, r, &q) {
+				return
+			}
+
+			var (
+				n    int
+				expr bitindex.Expr
+				err  error
+			)
+
+			if q.Expr != "" {
+				if expr, err = bitindex.ParseExpr(q.Expr); err != nil {
+					w.WriteHeader(StatusUnprocessableEntity)
+					fmt.Fprint(w, err)
+					return
+				}
+
+				n, err = idx.CountExpr(expr)
+			} else {
+				var o *operations
+
+				if o, err = q.operations(idx); err == nil {
+					n, err = idx.Count(o.any, o.all, o.nany, o.nall, o.thresholds...)
+				}
+			}
+
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, err)
+				return
+			}
+
+			resp := map[string]interface{}{
+				"count": n,
+			}
+
+			if err = json.NewEncoder(w).Encode(resp); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, err)
+				return
+			}
+		})
+
 		http.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("content-type", "application/json")
 
-			if err := json.NewEncoder(w).Encode(idx.Table.Keys()); err != nil {
+			if err := json.NewEncoder(w).Encode(keysJSON(idx, idx.Keys().Bits())); err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, err)
 				return
@@ -128,7 +330,13 @@ var httpCmd = &cobra.Command{
 		http.HandleFunc("/domain", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("content-type", "application/json")
 
-			if err := json.NewEncoder(w).Encode(idx.Domain.Members()); err != nil {
+			var ms interface{} = idx.Domain.Members()
+
+			if idx.MemberDict != nil {
+				ms = idx.MemberLabels(idx.Domain.Members())
+			}
+
+			if err := json.NewEncoder(w).Encode(ms); err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprint(w, err)
 				return
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeQuery(t *testing.T) {
+	tests := map[string]int{
+		`{"any": [1, 2]}`:                                        http.StatusOK,
+		`{"expr": "any(1)"}`:                                     http.StatusOK,
+		`{"expr": "any(1)", "any": null}`:                        http.StatusOK,
+		`{"expr": "any(1)", "all": [2]}`:                         http.StatusBadRequest,
+		`{"expr": "any(1)", "atmost": {"n": 1, "members": [2]}}`: http.StatusBadRequest,
+		`{"any": [1`:                                             StatusUnprocessableEntity,
+	}
+
+	for body, code := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/query", strings.NewReader(body))
+
+		var q query
+
+		if ok := decodeQuery(w, r, &q); ok != (code == http.StatusOK) {
+			t.Errorf("%s: expected %v, got %v", body, code == http.StatusOK, ok)
+		}
+
+		if w.Code != code {
+			t.Errorf("%s: expected status %d, got %d", body, code, w.Code)
+		}
+
+		if code == http.StatusBadRequest && w.Body.String() != errExprOperations {
+			t.Errorf("%s: expected %q, got %q", body, errExprOperations, w.Body.String())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/chop-dbhi/bitindex"
@@ -9,6 +10,57 @@ import (
 	"github.com/spf13/viper"
 )
 
+// loadKeys loads the stats and, if all is true, the keys, key dictionary
+// and key map of the index file without reading the rest of it.
+func loadKeys(path string, all bool) (*bitindex.Stats, []uint32, *bitindex.Dictionary, *bitindex.KeyMap, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	defer f.Close()
+
+	stats, err := bitindex.LoadStats(f)
+
+	if err != nil || !all {
+		return stats, nil, nil, nil, err
+	}
+
+	var (
+		keys []uint32
+		dict *bitindex.Dictionary
+		wide *bitindex.KeyMap
+	)
+
+	loaders := []func(io.Reader) error{
+		func(r io.Reader) (err error) {
+			keys, err = bitindex.LoadKeys(r)
+			return err
+		},
+		func(r io.Reader) (err error) {
+			dict, err = bitindex.LoadKeyDict(r)
+			return err
+		},
+		func(r io.Reader) (err error) {
+			wide, err = bitindex.LoadWideKeys(r)
+			return err
+		},
+	}
+
+	for _, load := range loaders {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		if err = load(f); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	return stats, keys, dict, wide, nil
+}
+
 var keysCmd = &cobra.Command{
 	Use: "keys <index>",
 
@@ -20,27 +72,49 @@ var keysCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		f, err := os.Open(args[0])
+		path := args[0]
 
-		if err != nil {
-			cmd.Println("Error opening file:", err)
-			os.Exit(1)
-		}
+		var (
+			stats *bitindex.Stats
+			keys  []uint32
+			dict  *bitindex.Dictionary
+			wide  *bitindex.KeyMap
+			err   error
+		)
 
-		var idx *bitindex.Index
+		// The delta can only be applied to the whole index.
+		if hasDelta(path) {
+			var idx *bitindex.Index
+
+			if idx, err = bitindex.LoadIndexFile(path); err != nil {
+				cmd.Println("Error loading index file:", err)
+				os.Exit(1)
+			}
 
-		if idx, err = bitindex.LoadIndex(f); err != nil {
+			stats = idx.Stats()
+			keys = idx.Keys().Bits()
+			dict = idx.KeyDict
+			wide = idx.WideKeys
+		} else if stats, keys, dict, wide, err = loadKeys(path, viper.GetBool("keys.keys")); err != nil {
 			cmd.Println("Error loading index file:", err)
 			os.Exit(1)
 		}
 
 		cmd.Println("Statistics")
-		cmd.Println("* Length:", idx.Table.Size())
-		cmd.Println("* Bytes:", idx.Table.Bytes())
+		cmd.Println("* Length:", stats.TableSize)
+		cmd.Println("* Bytes:", stats.Bytes)
 
 		if viper.GetBool("keys.keys") {
-			for _, k := range idx.Table.Keys() {
-				fmt.Fprintln(os.Stdout, k)
+			for _, k := range keys {
+				if dict != nil {
+					l, _ := dict.Label(k)
+					fmt.Fprintln(os.Stdout, l)
+				} else if wide != nil {
+					k64, _ := wide.Key(k)
+					fmt.Fprintln(os.Stdout, k64)
+				} else {
+					fmt.Fprintln(os.Stdout, k)
+				}
 			}
 		}
 	},
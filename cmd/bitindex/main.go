@@ -32,6 +32,12 @@ func main() {
 	mainCmd.AddCommand(statsCmd)
 	mainCmd.AddCommand(queryCmd)
 	mainCmd.AddCommand(httpCmd)
+	mainCmd.AddCommand(editCmd)
+	mainCmd.AddCommand(compactCmd)
+	mainCmd.AddCommand(mergeCmd)
+	mainCmd.AddCommand(exportCmd)
+	mainCmd.AddCommand(diffCmd)
+	mainCmd.AddCommand(migrateCmd)
 
 	// Parse flags early so we can start the profiler.
 	mainCmd.ParseFlags(os.Args)
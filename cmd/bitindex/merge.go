@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+
+	"github.com/chop-dbhi/bitindex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var mergeCmd = &cobra.Command{
+	Use: "merge <index>...",
+
+	Short: "Merges indexes into one.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Println("At least two index files are required.")
+			os.Exit(1)
+		}
+
+		output := viper.GetString("merge.output")
+
+		if output == "" {
+			cmd.Println("--output flag is required")
+			os.Exit(1)
+		}
+
+		codec, err := bitindex.ParseCodec(viper.GetString("merge.compress"))
+
+		if err != nil {
+			cmd.Println(err)
+			os.Exit(1)
+		}
+
+		idxs := make([]*bitindex.Index, len(args))
+
+		for i, path := range args {
+			idx, err := openIndex(path)
+
+			if err != nil {
+				cmd.Printf("Error opening index file %s: %s\n", path, err)
+				os.Exit(1)
+			}
+
+			defer idx.Close()
+
+			idxs[i] = idx
+		}
+
+		mode := bitindex.MergeUnion
+
+		if viper.GetBool("merge.override") {
+			mode = bitindex.MergeOverride
+		}
+
+		idx, err := bitindex.MergeIndexes(mode, idxs...)
+
+		if err != nil {
+			cmd.Println("Error merging indexes:", err)
+			os.Exit(1)
+		}
+
+		if sorted := viper.GetBool("merge.sort-domain"); sorted || viper.GetBool("merge.canonical") {
+			if err = idx.Canonicalize(sorted); err != nil {
+				cmd.Println("Error canonicalizing index:", err)
+				os.Exit(1)
+			}
+		}
+
+		if err = writeIndex(output, idx, codec); err != nil {
+			cmd.Println("Error dumping index:", err)
+			os.Exit(1)
+		}
+
+		stats := idx.Stats()
+
+		cmd.Println("Domain size:", stats.DomainSize)
+		cmd.Println("Table size:", stats.TableSize)
+		cmd.Println("Sparsity:", stats.Sparsity()*100)
+	},
+}
+
+func init() {
+	flags := mergeCmd.Flags()
+
+	flags.StringP("output", "o", "", "File to write the merged index to.")
+	flags.Bool("override", false, "Replace the members of a key with those of the last index that has it instead of combining them.")
+	flags.Bool("canonical", false, "Renumber labels and keys in sorted order so identical pairs yield identical files.")
+	flags.Bool("sort-domain", false, "Also assign the bits of the domain in sorted member order. Implies --canonical.")
+	flags.String("compress", "none", "Codec of the index file: none, gzip, zstd or xz.")
+
+	viper.BindPFlag("merge.output", flags.Lookup("output"))
+	viper.BindPFlag("merge.override", flags.Lookup("override"))
+	viper.BindPFlag("merge.canonical", flags.Lookup("canonical"))
+	viper.BindPFlag("merge.sort-domain", flags.Lookup("sort-domain"))
+	viper.BindPFlag("merge.compress", flags.Lookup("compress"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/chop-dbhi/bitindex"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// loadLegacyIndex loads an index file of the original format. Files
+// that are indexes of the current format are rejected.
+func loadLegacyIndex(path string) (*bitindex.Index, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	if _, err = bitindex.LoadStats(f); err == nil {
+		return nil, errors.New("The index file is already in the current format")
+	} else if err != bitindex.ErrNotIndex {
+		return nil, err
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return bitindex.LoadLegacyIndex(f)
+}
+
+var migrateCmd = &cobra.Command{
+	Use: "migrate <index>",
+
+	Short: "Rewrites an index file of the original format in the current one.",
+
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Println("An index file is required.")
+			os.Exit(1)
+		}
+
+		codec, err := bitindex.ParseCodec(viper.GetString("migrate.compress"))
+
+		if err != nil {
+			cmd.Println(err)
+			os.Exit(1)
+		}
+
+		idx, err := loadLegacyIndex(args[0])
+
+		if err != nil {
+			cmd.Println("Error loading index file:", err)
+			os.Exit(1)
+		}
+
+		if viper.GetBool("migrate.postings") {
+			idx.Postings = bitindex.BuildPostings(idx.Table)
+		}
+
+		output := viper.GetString("migrate.output")
+
+		if output == "" {
+			output = args[0]
+		}
+
+		if err = writeIndex(output, idx, codec); err != nil {
+			cmd.Println("Error dumping index:", err)
+			os.Exit(1)
+		}
+
+		stats := idx.Stats()
+
+		cmd.Println("Domain size:", stats.DomainSize)
+		cmd.Println("Table size:", stats.TableSize)
+	},
+}
+
+func init() {
+	flags := migrateCmd.Flags()
+
+	flags.String("output", "", "Write the migrated index to this file instead of in place.")
+	flags.Bool("postings", false, "Build the inverted postings for faster queries.")
+	flags.String("compress", "none", "Codec of the index file: none, gzip, zstd or xz.")
+
+	viper.BindPFlag("migrate.output", flags.Lookup("output"))
+	viper.BindPFlag("migrate.postings", flags.Lookup("postings"))
+	viper.BindPFlag("migrate.compress", flags.Lookup("compress"))
+}
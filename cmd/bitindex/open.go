@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/chop-dbhi/bitindex"
+)
+
+// hasDelta returns true if the index file has a delta file.
+func hasDelta(path string) bool {
+	_, err := os.Stat(bitindex.DeltaPath(path))
+	return err == nil
+}
+
+// openIndex maps the index file into memory. If the index has a delta
+// file, it is loaded instead so the delta can be applied.
+func openIndex(path string) (*bitindex.Index, error) {
+	if hasDelta(path) {
+		return bitindex.LoadIndexFile(path)
+	}
+
+	return bitindex.OpenIndex(path)
+}
+
+// outputCodec returns the codec of the name, or of the index file being
+// rewritten if the name is empty so its compression is kept.
+func outputCodec(name, path string) (bitindex.Codec, error) {
+	if name != "" {
+		return bitindex.ParseCodec(name)
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return bitindex.CodecNone, err
+	}
+
+	defer f.Close()
+
+	stats, err := bitindex.LoadStats(f)
+
+	if err != nil {
+		return bitindex.CodecNone, err
+	}
+
+	return stats.Codec, nil
+}
+
+// writeIndex writes the index to a temporary file next to the output
+// and renames it, so the output is replaced only if writing succeeds.
+func writeIndex(output string, idx *bitindex.Index, codec bitindex.Codec) error {
+	return replaceIndex(output, func(w io.Writer) error {
+		return bitindex.DumpCompressed(w, idx, codec)
+	})
+}
+
+// replaceIndex writes the index file like writeFile and then removes
+// the delta file of the output, which belongs to the index it replaced.
+func replaceIndex(output string, write func(w io.Writer) error) error {
+	if err := writeFile(output, write); err != nil {
+		return err
+	}
+
+	if err := os.Remove(bitindex.DeltaPath(output)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error removing delta file: %s", err)
+	}
+
+	return nil
+}
+
+// writeFile calls write with a temporary file next to the output and
+// renames it to the output if write succeeds. The file keeps the mode of
+// the output it replaces.
+func writeFile(output string, write func(w io.Writer) error) error {
+	mode := os.FileMode(0644)
+
+	if fi, err := os.Stat(output); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(output), ".bitindex")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	// Temporary files are only readable by the owner.
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), output)
+}
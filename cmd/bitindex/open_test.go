@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chop-dbhi/bitindex"
+)
+
+func TestReplaceIndexRemovesDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bitindex")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.bitx")
+
+	a := bitindex.NewIndex(nil)
+	a.Add(1, 10)
+
+	if err = writeIndex(path, a, bitindex.CodecNone); err != nil {
+		t.Fatal(err)
+	}
+
+	// A delta appended to the first index.
+	dw, err := bitindex.OpenDelta(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Add(2, 20); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed rebuild keeps the index and its delta.
+	fail := func(w io.Writer) error {
+		return errors.New("failed")
+	}
+
+	if err = replaceIndex(path, fail); err == nil {
+		t.Fatal("expected the write error")
+	}
+
+	if !hasDelta(path) {
+		t.Fatal("expected the delta to be kept")
+	}
+
+	// Rebuilding the index from other data drops the delta.
+	b := bitindex.NewIndex(nil)
+	b.Add(3, 30)
+
+	if err = writeIndex(path, b, bitindex.CodecNone); err != nil {
+		t.Fatal(err)
+	}
+
+	if hasDelta(path) {
+		t.Fatal("expected the delta to be removed")
+	}
+
+	idx, err := openIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer idx.Close()
+
+	if keys := idx.Keys().Bits(); len(keys) != 1 || keys[0] != 3 {
+		t.Errorf("expected key 3, got %v", keys)
+	}
+}
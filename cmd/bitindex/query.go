@@ -12,28 +12,144 @@ import (
 	"github.com/spf13/viper"
 )
 
-func parseOpFlag(s string) ([]uint32, error) {
+// parseOpFlag parses a comma-separated list of members. Members are
+// looked up in the index since they may be labels. Commas inside double
+// quotes or parentheses do not separate members, so labels may be quoted
+// and descendants(a,b) is read as descendants(a),descendants(b).
+func parseOpFlag(s string) ([]string, error) {
 	if s == "" {
 		return nil, nil
 	}
 
-	var (
-		n   uint64
-		err error
-	)
+	toks, err := splitMembers(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+
+	for _, t := range toks {
+		if !strings.HasPrefix(t, "descendants(") || !strings.HasSuffix(t, ")") {
+			l, err := unquoteMember(t)
+
+			if err != nil {
+				return nil, err
+			}
+
+			labels = append(labels, l)
+			continue
+		}
 
-	toks := strings.Split(s, ",")
-	ints := make([]uint32, len(toks))
+		inner, err := splitMembers(t[len("descendants(") : len(t)-1])
 
-	for i, t := range toks {
-		if n, err = strconv.ParseUint(t, 10, 32); err != nil {
+		if err != nil {
 			return nil, err
 		}
 
-		ints[i] = uint32(n)
+		for _, t := range inner {
+			l, err := unquoteMember(t)
+
+			if err != nil {
+				return nil, err
+			}
+
+			labels = append(labels, "descendants("+l+")")
+		}
+	}
+
+	return labels, nil
+}
+
+// splitMembers splits the list on the commas that are outside of double
+// quotes and parentheses.
+func splitMembers(s string) ([]string, error) {
+	var (
+		toks   []string
+		depth  int
+		quoted bool
+		start  int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced ) in %q", s)
+			}
+		case c == ',' && depth == 0:
+			toks = append(toks, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated string in %q", s)
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced ( in %q", s)
+	}
+
+	return append(toks, strings.TrimSpace(s[start:])), nil
+}
+
+// unquoteMember returns the label of a member, which may be quoted.
+func unquoteMember(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return strconv.Unquote(s)
 	}
 
-	return ints, nil
+	return s, nil
+}
+
+// errExprOperations is the error for a query with both an expression
+// and other operations, of which only one could be applied.
+const errExprOperations = "The --expr flag cannot be combined with other operations."
+
+// thresholdFlag is a parsed --atleast or --atmost flag.
+type thresholdFlag struct {
+	n      int
+	labels []string
+	most   bool
+}
+
+// parseThresholdFlag parses a threshold of the form <n>:<members>.
+func parseThresholdFlag(s string, most bool) ([]thresholdFlag, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	toks := strings.SplitN(s, ":", 2)
+
+	if len(toks) != 2 {
+		return nil, fmt.Errorf("expected <n>:<members>")
+	}
+
+	n, err := strconv.Atoi(toks[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := parseOpFlag(toks[1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []thresholdFlag{{
+		n:      n,
+		labels: labels,
+		most:   most,
+	}}, nil
 }
 
 var queryCmd = &cobra.Command{
@@ -48,54 +164,128 @@ var queryCmd = &cobra.Command{
 		}
 
 		var (
-			any, all, nany, nall []uint32
+			ts, t                []thresholdFlag
+			any, all, nany, nall []string
 			err                  error
 		)
 
 		// Parse operation flags.
-		if any, err = parseOpFlag(viper.GetString("query.any")); err != nil {
-			cmd.Println("Error parsing --any flag.")
-			os.Exit(1)
+		for _, f := range []struct {
+			name   string
+			labels *[]string
+		}{
+			{"any", &any},
+			{"all", &all},
+			{"nany", &nany},
+			{"nall", &nall},
+		} {
+			if *f.labels, err = parseOpFlag(viper.GetString("query." + f.name)); err != nil {
+				cmd.Printf("Error parsing --%s flag: %s\n", f.name, err)
+				os.Exit(1)
+			}
 		}
 
-		if all, err = parseOpFlag(viper.GetString("query.all")); err != nil {
-			cmd.Println("Error parsing --all flag.")
+		if ts, err = parseThresholdFlag(viper.GetString("query.atleast"), false); err != nil {
+			cmd.Println("Error parsing --atleast flag:", err)
 			os.Exit(1)
 		}
 
-		if nany, err = parseOpFlag(viper.GetString("query.nany")); err != nil {
-			cmd.Println("Error parsing --nany flag.")
+		if t, err = parseThresholdFlag(viper.GetString("query.atmost"), true); err != nil {
+			cmd.Println("Error parsing --atmost flag:", err)
 			os.Exit(1)
 		}
 
-		if nall, err = parseOpFlag(viper.GetString("query.nall")); err != nil {
-			cmd.Println("Error parsing --nall flag.")
-			os.Exit(1)
-		}
+		ts = append(ts, t...)
+
+		var expr bitindex.Expr
 
-		if len(any) == 0 && len(all) == 0 && len(nany) == 0 && len(nall) == 0 {
+		if s := viper.GetString("query.expr"); s != "" {
+			if len(any) > 0 || len(all) > 0 || len(nany) > 0 || len(nall) > 0 || len(ts) > 0 {
+				cmd.Println(errExprOperations)
+				os.Exit(1)
+			}
+
+			if expr, err = bitindex.ParseExpr(s); err != nil {
+				cmd.Println("Error parsing --expr flag:", err)
+				os.Exit(1)
+			}
+		} else if len(any) == 0 && len(all) == 0 && len(nany) == 0 && len(nall) == 0 && len(ts) == 0 {
 			cmd.Println("At least one operation must be specified.")
 			os.Exit(1)
 		}
 
-		f, err := os.Open(args[0])
+		idx, err := openIndex(args[0])
 
 		if err != nil {
-			cmd.Println("Error opening file:", err)
+			cmd.Println("Error opening index file:", err)
 			os.Exit(1)
 		}
 
-		var idx *bitindex.Index
+		defer idx.Close()
 
-		if idx, err = bitindex.LoadIndex(f); err != nil {
-			cmd.Println("Error loading index file:", err)
-			os.Exit(1)
+		// Members may be labels which are looked up in the index.
+		lookup := func(flag string, labels []string) []uint32 {
+			ms, err := idx.LookupMembers(labels...)
+
+			if err != nil {
+				cmd.Printf("Error with --%s flag: %s\n", flag, err)
+				os.Exit(1)
+			}
+
+			return ms
+		}
+
+		anyMs := lookup("any", any)
+		allMs := lookup("all", all)
+		nanyMs := lookup("nany", nany)
+		nallMs := lookup("nall", nall)
+
+		var thresholds []bitindex.Threshold
+
+		for _, t := range ts {
+			flag := "atleast"
+
+			if t.most {
+				flag = "atmost"
+			}
+
+			thresholds = append(thresholds, bitindex.Threshold{
+				N:       t.n,
+				Members: lookup(flag, t.labels),
+				AtMost:  t.most,
+			})
 		}
 
 		// Query time.
 		t0 := time.Now()
 
-		res, err := idx.Query(any, all, nany, nall)
+		// Only compute the number of matching keys.
+		if viper.GetBool("query.count") {
+			var n int
+
+			if expr != nil {
+				n, err = idx.CountExpr(expr)
+			} else {
+				n, err = idx.Count(anyMs, allMs, nanyMs, nallMs, thresholds...)
+			}
+
+			if err != nil {
+				cmd.Println("Error with query:", err)
+				os.Exit(1)
+			}
+
+			cmd.Printf("Time: %s\n", time.Now().Sub(t0))
+			cmd.Printf("Count: %d\n", n)
+			return
+		}
+
+		var res *bitindex.Result
+
+		if expr != nil {
+			res, err = idx.QueryExpr(expr)
+		} else {
+			res, err = idx.Query(anyMs, allMs, nanyMs, nallMs, thresholds...)
+		}
 
 		if err != nil {
 			cmd.Println("Error with query:", err)
@@ -121,7 +311,7 @@ var queryCmd = &cobra.Command{
 		cmd.Printf("Complement: %v\n", comp)
 
 		if !viper.GetBool("query.quiet") {
-			for _, k := range items {
+			for _, k := range idx.KeyLabels(items) {
 				fmt.Println(k)
 			}
 		}
@@ -132,18 +322,26 @@ func init() {
 	flags := queryCmd.Flags()
 
 	flags.Bool("quiet", false, "Do not print keys to stdout.")
+	flags.Bool("count", false, "Only count the matching keys.")
 	flags.String("any", "", "Applies the any operation.")
 	flags.String("all", "", "Applies the all operation.")
 	flags.String("nany", "", "Applies the not any operation.")
 	flags.String("nall", "", "Applies the not all operation.")
+	flags.String("atleast", "", "Applies the at least operation, e.g. 2:1,2,3 for at least 2 of 1, 2 and 3.")
+	flags.String("atmost", "", "Applies the at most operation, e.g. 1:1,2,3 for at most 1 of 1, 2 and 3.")
+	flags.String("expr", "", "Applies a boolean expression of operations, e.g. \"any(1,2) AND NOT all(3,4)\".")
 	flags.Bool("smallest", false, "Returns the complement of the set if smaller.")
 	flags.Bool("complement", false, "Returns the complement of the set.")
 
 	viper.BindPFlag("query.quiet", flags.Lookup("quiet"))
+	viper.BindPFlag("query.count", flags.Lookup("count"))
 	viper.BindPFlag("query.any", flags.Lookup("any"))
 	viper.BindPFlag("query.all", flags.Lookup("all"))
 	viper.BindPFlag("query.nany", flags.Lookup("nany"))
 	viper.BindPFlag("query.nall", flags.Lookup("nall"))
+	viper.BindPFlag("query.atleast", flags.Lookup("atleast"))
+	viper.BindPFlag("query.atmost", flags.Lookup("atmost"))
+	viper.BindPFlag("query.expr", flags.Lookup("expr"))
 	viper.BindPFlag("query.smallest", flags.Lookup("smallest"))
 	viper.BindPFlag("query.complement", flags.Lookup("complement"))
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOpFlag(t *testing.T) {
+	tests := map[string][]string{
+		"":                          nil,
+		"1,2":                       {"1", "2"},
+		"Apples, Blood Oranges":     {"Apples", "Blood Oranges"},
+		`"Oranges, Blood",Apples`:   {"Oranges, Blood", "Apples"},
+		"descendants(250),401":      {"descendants(250)", "401"},
+		"descendants(250, 401),E11": {"descendants(250)", "descendants(401)", "E11"},
+		`descendants("a,b"),c`:      {"descendants(a,b)", "c"},
+		`"descendants(250)"`:        {"descendants(250)"},
+	}
+
+	for in, exp := range tests {
+		labels, err := parseOpFlag(in)
+
+		if err != nil {
+			t.Errorf("%q: %s", in, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(labels, exp) {
+			t.Errorf("%q: expected %q, got %q", in, exp, labels)
+		}
+	}
+
+	for _, in := range []string{`"Apples`, "descendants(250", "250)"} {
+		if _, err := parseOpFlag(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
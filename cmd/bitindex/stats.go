@@ -7,6 +7,35 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// loadStats loads the stats of the index file. If the index has a delta
+// file, the index is loaded to apply it.
+func loadStats(path string) (*bitindex.Stats, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	stats, err := bitindex.LoadStats(f)
+
+	if err != nil || !hasDelta(path) {
+		return stats, err
+	}
+
+	idx, err := bitindex.LoadIndexFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := idx.Stats()
+	s.Codec = stats.Codec
+
+	return s, nil
+}
+
 var statsCmd = &cobra.Command{
 	Use: "stats <index>",
 
@@ -18,23 +47,19 @@ var statsCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		f, err := os.Open(args[0])
+		stats, err := loadStats(args[0])
 
 		if err != nil {
-			cmd.Println("Error opening file:", err)
-			os.Exit(1)
-		}
-
-		var idx *bitindex.Index
-
-		if idx, err = bitindex.LoadIndex(f); err != nil {
 			cmd.Println("Error loading index file:", err)
 			os.Exit(1)
 		}
 
 		cmd.Println("Statistics")
-		cmd.Println("* Domain size:", idx.Domain.Size())
-		cmd.Println("* Table size:", idx.Table.Size())
-		cmd.Println("* Sparsity:", idx.Sparsity()*100)
+		cmd.Println("* Domain size:", stats.DomainSize)
+		cmd.Println("* Table size:", stats.TableSize)
+		cmd.Println("* Sparsity:", stats.Sparsity()*100)
+		cmd.Println("* Postings:", stats.Postings)
+		cmd.Println("* Codec:", stats.Codec)
+		cmd.Println("* Delta:", hasDelta(args[0]))
 	},
 }
@@ -0,0 +1,222 @@
+package bitindex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Codec is the compression of the sections of an index file. Sections are
+// compressed independently, so the table of contents can still be read
+// without decompressing the whole file.
+type Codec uint8
+
+const (
+	// CodecNone leaves the sections uncompressed so the index can be
+	// mapped and queried in place.
+	CodecNone Codec = iota
+
+	CodecGzip
+	CodecZstd
+	CodecXZ
+)
+
+var codecNames = []string{"none", "gzip", "zstd", "xz"}
+
+func (c Codec) String() string {
+	if !c.known() {
+		return fmt.Sprintf("codec(%d)", c)
+	}
+
+	return codecNames[c]
+}
+
+// known returns true if the codec is supported by this version.
+func (c Codec) known() bool {
+	return int(c) < len(codecNames)
+}
+
+// ParseCodec returns the codec of the name: none, gzip, zstd or xz.
+func ParseCodec(name string) (Codec, error) {
+	for i, n := range codecNames {
+		if n == name {
+			return Codec(i), nil
+		}
+	}
+
+	return CodecNone, fmt.Errorf("Unknown codec %s", name)
+}
+
+// newWriter returns a writer compressing to w. It must be closed to
+// flush the compressed data.
+func (c Codec) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+
+	case CodecZstd:
+		// A single encoder keeps the output reproducible.
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+
+	case CodecXZ:
+		return xz.NewWriter(w)
+	}
+
+	return nil, fmt.Errorf("Unsupported codec %s", c)
+}
+
+// newReader returns a reader decompressing r.
+func (c Codec) newReader(r io.Reader) (io.Reader, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewReader(r)
+
+	case CodecZstd:
+		// A single decoder reads synchronously, so there are no
+		// goroutines to release when the reader is dropped.
+		return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+
+	case CodecXZ:
+		return xz.NewReader(r)
+	}
+
+	return nil, fmt.Errorf("Unsupported codec %s", c)
+}
+
+// Magic bytes of the compressed streams recognized by Decompress.
+var streamMagic = []struct {
+	magic []byte
+	open  func(io.Reader) (io.Reader, error)
+}{
+	{[]byte{0x1f, 0x8b}, CodecGzip.newReader},
+	{[]byte("BZh"), func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	}},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, CodecZstd.newReader},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0}, CodecXZ.newReader},
+}
+
+// Decompress returns a reader of the decompressed stream if r begins with
+// the magic bytes of gzip, bzip2, zstd or xz, and of r itself otherwise.
+// The compression is detected from the content, so it works for streams
+// without a file name such as stdin.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	// Shorter streams cannot be compressed, so the error is ignored.
+	b, _ := br.Peek(6)
+
+	for _, s := range streamMagic {
+		if bytes.HasPrefix(b, s.magic) {
+			return s.open(br)
+		}
+	}
+
+	return br, nil
+}
+
+// compressSection returns the section compressed by the codec. It is
+// compressed before it is written since its length precedes it in the
+// table of contents. The compressed data is preceded by the uncompressed
+// length. It is held in memory unless newSpool is set, in which case it
+// is written to a spool.
+func compressSection(c Codec, sec sectionWriter, newSpool func() (*spool, error)) (sectionWriter, error) {
+	var (
+		buf bytes.Buffer
+		s   *spool
+		w   io.Writer = &buf
+	)
+
+	if newSpool != nil {
+		var err error
+
+		if s, err = newSpool(); err != nil {
+			return sec, err
+		}
+
+		w = s
+	}
+
+	b := make([]byte, 8)
+
+	if err := writeUint64(w, b, sec.size); err != nil {
+		return sec, err
+	}
+
+	zw, err := c.newWriter(w)
+
+	if err != nil {
+		return sec, err
+	}
+
+	// Sections are dumped a few bytes at a time.
+	bw := bufio.NewWriter(zw)
+
+	if err = sec.dump(bw, b); err != nil {
+		return sec, err
+	}
+
+	if err = bw.Flush(); err != nil {
+		return sec, err
+	}
+
+	if err = zw.Close(); err != nil {
+		return sec, err
+	}
+
+	if s != nil {
+		return sectionWriter{sec.id, s.n, func(w io.Writer, _ []byte) error {
+			return s.copyTo(w)
+		}}, nil
+	}
+
+	data := buf.Bytes()
+
+	return sectionWriter{sec.id, uint64(len(data)), func(w io.Writer, _ []byte) error {
+		_, err := w.Write(data)
+		return err
+	}}, nil
+}
+
+// compressSections compresses each of the sections.
+func compressSections(c Codec, secs []sectionWriter, newSpool func() (*spool, error)) ([]sectionWriter, error) {
+	out := make([]sectionWriter, len(secs))
+
+	for i, sec := range secs {
+		var err error
+
+		if out[i], err = compressSection(c, sec, newSpool); err != nil {
+			return nil, fmt.Errorf("Error compressing section %d: %s", sec.id, err)
+		}
+	}
+
+	return out, nil
+}
+
+// inflateSection decompresses a section written by compressSection.
+func inflateSection(c Codec, b []byte) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, ErrCorrupt
+	}
+
+	r, err := c.newReader(bytes.NewReader(b[8:]))
+
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	data, err := readAll(r, binary.LittleEndian.Uint64(b))
+
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	return data, nil
+}
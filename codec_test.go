@@ -0,0 +1,219 @@
+package bitindex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestDumpCompressed(t *testing.T) {
+	ix := newLabeledIndex(t)
+	ix.Postings = BuildPostings(ix.Table)
+
+	plain := new(bytes.Buffer)
+
+	if err := DumpIndex(plain, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "bitindex")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, c := range []Codec{CodecGzip, CodecZstd, CodecXZ} {
+		buf := new(bytes.Buffer)
+
+		if err := DumpCompressed(buf, ix, c); err != nil {
+			t.Fatalf("%s: %s", c, err)
+		}
+
+		data := buf.Bytes()
+
+		stats, err := LoadStats(bytes.NewReader(data))
+
+		if err != nil {
+			t.Fatalf("%s: %s", c, err)
+		}
+
+		if stats.Codec != c || !stats.Postings || stats.TableSize != 3 {
+			t.Errorf("%s: unexpected stats %+v", c, stats)
+		}
+
+		d, err := LoadMemberDict(bytes.NewReader(data))
+
+		if err != nil {
+			t.Fatalf("%s: %s", c, err)
+		}
+
+		exp, _ := ix.MemberDict.ID("I10 X")
+
+		if id, ok := d.ID("I10 X"); !ok || id != exp {
+			t.Errorf("%s: expected id %d, got %d", c, exp, id)
+		}
+
+		// Loaded and mapped indexes dump to the uncompressed file.
+		loaded, err := LoadIndex(bytes.NewReader(data))
+
+		if err != nil {
+			t.Fatalf("%s: %s", c, err)
+		}
+
+		path := filepath.Join(dir, c.String()+".bitx")
+
+		if err = ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		mapped, err := OpenIndex(path)
+
+		if err != nil {
+			t.Fatalf("%s: %s", c, err)
+		}
+
+		if err = mapped.Verify(); err != nil {
+			t.Errorf("%s: %s", c, err)
+		}
+
+		for _, x := range []*Index{loaded, mapped} {
+			out := new(bytes.Buffer)
+
+			if err := DumpIndex(out, x); err != nil {
+				t.Fatalf("%s: %s", c, err)
+			}
+
+			if !bytes.Equal(out.Bytes(), plain.Bytes()) {
+				t.Errorf("%s: round trip differs from the uncompressed index", c)
+			}
+		}
+
+		mapped.Close()
+
+		// Corrupt compressed data is caught by the checksum.
+		bad := append([]byte(nil), data...)
+		bad[len(bad)-footerSize-4] ^= 0xff
+
+		if _, err = LoadIndex(bytes.NewReader(bad)); err != ErrChecksum {
+			t.Errorf("%s: expected ErrChecksum, got %v", c, err)
+		}
+	}
+
+	// Unknown codec.
+	data := plain.Bytes()
+	data[7] = 0x0f
+
+	if _, err := LoadIndex(bytes.NewReader(data)); err == nil {
+		t.Errorf("expected codec error")
+	} else if _, ok := err.(*VersionError); !ok {
+		t.Errorf("expected VersionError, got %v", err)
+	}
+}
+
+func TestBuilderCodec(t *testing.T) {
+	b := NewBuilder()
+	b.Codec = CodecZstd
+	b.Postings = true
+
+	defer b.Close()
+
+	if err := b.Build(newFruitCSV()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if _, err := b.Dump(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := LoadIndex(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, ms := range pairs {
+		for _, m := range ms {
+			if !ix.Has(k, m) {
+				t.Errorf("key %d should have member %d", k, m)
+			}
+		}
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	text := "person,fruit\n100,1\n100,3\n"
+
+	writers := map[string]func(io.Writer) (io.WriteCloser, error){
+		"gzip": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		"zstd": func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		},
+		"xz": func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		},
+		"none": func(w io.Writer) (io.WriteCloser, error) {
+			return nopCloser{w}, nil
+		},
+	}
+
+	for name, open := range writers {
+		buf := new(bytes.Buffer)
+
+		w, err := open(buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		io.WriteString(w, text)
+		w.Close()
+
+		r, err := Decompress(buf)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		b, err := ioutil.ReadAll(r)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if string(b) != text {
+			t.Errorf("%s: expected %q, got %q", name, text, b)
+		}
+	}
+
+	// Shorter than the magic bytes.
+	r, err := Decompress(bytes.NewReader([]byte("1,")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := ioutil.ReadAll(r); string(b) != "1," {
+		t.Errorf("expected %q, got %q", "1,", b)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
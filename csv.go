@@ -2,19 +2,51 @@ package bitindex
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
+	"strings"
 )
 
 // CSVIndexer is an indexer for CSV structured data.
 type CSVIndexer struct {
 	*csv.Reader
 
-	// If true, the first line will be skipped.
+	// If true, the first line will be skipped. It is kept in Columns.
 	Header bool
 
+	// The header row if Header is true.
+	Columns []string
+
+	// If true, the postings are built along with the table.
+	Postings bool
+
 	// A function that takes a CSV row and returns the key and member
 	// to be index.
 	Parse func([]string) (uint32, uint32, error)
+
+	// A function that takes a CSV row and returns the labels of the key
+	// and member to be indexed. If set, it is used instead of Parse and
+	// the labels are encoded with the dictionaries.
+	ParseLabels func([]string) (string, string, error)
+
+	// A function that takes a CSV row and returns a 64-bit key and the
+	// member to be indexed. If set, it is used instead of Parse and the
+	// keys are mapped to ids with WideKeys.
+	Parse64 func([]string) (uint64, uint32, error)
+
+	// A function that takes a CSV row holding many members, such as a
+	// key followed by a delimited list of members or a row of a 0/1
+	// matrix, and returns a row for each key/member pair. The returned
+	// rows are passed to the parse functions in place of the row.
+	Expand func([]string) ([][]string, error)
+
+	// Dictionaries of the key and member labels. They are initialized
+	// when ParseLabels is set.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+
+	// Map of the 64-bit keys. It is initialized when Parse64 is set.
+	WideKeys *KeyMap
 }
 
 // NewCSVIndexer initializes a new CSV parser for building an index.
@@ -24,21 +56,35 @@ func NewCSVIndexer(r io.Reader) *CSVIndexer {
 	}
 }
 
-// Build implements the Indexer interface and builds an index from a CSV file.
-func (p *CSVIndexer) Index() (*Index, error) {
-	ix := NewIndex(nil)
-
+// Stream implements the Streamer interface and calls f for each
+// key/member pair in the CSV file.
+func (p *CSVIndexer) Stream(f func(k uint32, m uint32) error) error {
 	// Skip the header.
 	if p.Header {
-		_, err := p.Read()
+		row, err := p.Read()
 
 		// Includes EOF.
 		if err != nil {
-			return nil, err
+			return err
 		}
+
+		p.Columns = row
 	}
 
-	var k, m uint32
+	if p.ParseLabels != nil && p.KeyDict == nil {
+		p.KeyDict = NewDictionary()
+		p.MemberDict = NewDictionary()
+	}
+
+	if p.Parse64 != nil && p.WideKeys == nil {
+		p.WideKeys = NewKeyMap()
+	}
+
+	var (
+		k, m   uint32
+		k64    uint64
+		kl, ml string
+	)
 
 	for {
 		row, err := p.Read()
@@ -48,15 +94,127 @@ func (p *CSVIndexer) Index() (*Index, error) {
 		}
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if k, m, err = p.Parse(row); err != nil {
-			return nil, err
+		rows := [][]string{row}
+
+		if p.Expand != nil {
+			if rows, err = p.Expand(row); err != nil {
+				return err
+			}
+		}
+
+		for _, row := range rows {
+			if p.ParseLabels != nil {
+				if kl, ml, err = p.ParseLabels(row); err != nil {
+					return err
+				}
+
+				k, m = p.KeyDict.Add(kl), p.MemberDict.Add(ml)
+			} else if p.Parse64 != nil {
+				if k64, m, err = p.Parse64(row); err != nil {
+					return err
+				}
+
+				k = p.WideKeys.Add(k64)
+			} else if k, m, err = p.Parse(row); err != nil {
+				return err
+			}
+
+			if err = f(k, m); err != nil {
+				return err
+			}
 		}
+	}
+
+	return nil
+}
+
+// Build implements the Indexer interface and builds an index from a CSV file.
+func (p *CSVIndexer) Index() (*Index, error) {
+	ix := NewIndex(nil)
+
+	if p.Postings {
+		ix.Postings = NewPostings()
+	}
+
+	err := p.Stream(ix.Add)
 
-		ix.Add(k, m)
+	if err != nil {
+		return nil, err
 	}
 
+	ix.KeyDict = p.KeyDict
+	ix.MemberDict = p.MemberDict
+	ix.WideKeys = p.WideKeys
+
+	ix.Pack()
+
 	return ix, nil
 }
+
+// ListExpander returns an Expand function for rows with the key in
+// column k and its members in column d separated by sep, such as
+// "100,1;3". The expanded rows hold the key and member in columns 0
+// and 1. Empty members are skipped.
+func ListExpander(k, d int, sep string) func([]string) ([][]string, error) {
+	return func(row []string) ([][]string, error) {
+		if k < 0 || d < 0 || k >= len(row) || d >= len(row) {
+			return nil, fmt.Errorf("Expected key and member columns %d and %d, got %d columns", k, d, len(row))
+		}
+
+		ms := strings.Split(row[d], sep)
+		rows := make([][]string, 0, len(ms))
+
+		for _, m := range ms {
+			if m = strings.TrimSpace(m); m != "" {
+				rows = append(rows, []string{row[k], m})
+			}
+		}
+
+		return rows, nil
+	}
+}
+
+// MatrixExpander returns an Expand function for rows of a 0/1 matrix
+// with the key in column k and a column for each member, named by the
+// header. Header must be set and each row must have a cell for each
+// column. A cell is 1 if the key has the member and 0 or empty otherwise.
+// The expanded rows hold the key and member in columns 0 and 1. A key
+// without a 1 in its row has no members and is not in the index.
+func (p *CSVIndexer) MatrixExpander(k int) func([]string) ([][]string, error) {
+	return func(row []string) ([][]string, error) {
+		if p.Columns == nil {
+			return nil, fmt.Errorf("A header is required to name the members of the matrix")
+		}
+
+		if k < 0 || k >= len(row) {
+			return nil, fmt.Errorf("Expected key column %d, got %d columns", k, len(row))
+		}
+
+		if len(row) != len(p.Columns) {
+			return nil, fmt.Errorf("Expected %d columns named by the header, got %d", len(p.Columns), len(row))
+		}
+
+		var rows [][]string
+
+		for i, v := range row {
+			if i == k {
+				continue
+			}
+
+			switch strings.TrimSpace(v) {
+			case "1":
+				rows = append(rows, []string{row[k], p.Columns[i]})
+
+			case "0", "":
+
+			default:
+				return nil, fmt.Errorf("Column %s: Expected 0 or 1, got %q", p.Columns[i], v)
+			}
+		}
+
+		return rows, nil
+	}
+}
@@ -0,0 +1,115 @@
+package bitindex
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsePair parses the key and member in columns 0 and 1.
+func parsePair(row []string) (uint32, uint32, error) {
+	k, err := strconv.ParseUint(row[0], 10, 32)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	m, err := strconv.ParseUint(row[1], 10, 32)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint32(k), uint32(m), nil
+}
+
+func TestCSVExpand(t *testing.T) {
+	tests := map[string]func(p *CSVIndexer){
+		"person,fruit\n100,1;3\n101,4;9\n102,4; 2;3;\n103,\n": func(p *CSVIndexer) {
+			p.Expand = ListExpander(0, 1, ";")
+		},
+
+		"1,person,2,3,4,9\n1,100,0,1,0,0\n0,101,0,0,1,1\n0,102,1,1,1,0\n0,103,,,0,\n": func(p *CSVIndexer) {
+			p.Expand = p.MatrixExpander(1)
+		},
+	}
+
+	for in, setup := range tests {
+		p := NewCSVIndexer(strings.NewReader(in))
+		p.Header = true
+		p.Parse = parsePair
+		setup(p)
+
+		ix, err := p.Index()
+
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+
+		if ix.Size() != 3 {
+			t.Errorf("%s: expected 3 keys, got %d", in, ix.Size())
+		}
+
+		for k, exp := range pairs {
+			var got []uint32
+
+			for _, b := range ix.Get(k).Bits() {
+				got = append(got, ix.Domain.Member(b))
+			}
+
+			if !sameKeys(got, exp) {
+				t.Errorf("%s: key %d: expected %v, got %v", in, k, exp, got)
+			}
+		}
+	}
+}
+
+func TestCSVMatrixErrors(t *testing.T) {
+	p := NewCSVIndexer(strings.NewReader("person,1,2\n100,1,x\n"))
+	p.Header = true
+	p.Parse = parsePair
+	p.Expand = p.MatrixExpander(0)
+
+	if _, err := p.Index(); err == nil || !strings.Contains(err.Error(), "Column 2") {
+		t.Errorf("expected error for column 2, got %v", err)
+	}
+
+	p = NewCSVIndexer(strings.NewReader("100,1,0\n"))
+	p.Parse = parsePair
+	p.Expand = p.MatrixExpander(0)
+
+	if _, err := p.Index(); err == nil {
+		t.Error("expected error without a header")
+	}
+
+	// Columns past the end of the row.
+	tests := map[string]func(p *CSVIndexer){
+		"100\n": func(p *CSVIndexer) {
+			p.Expand = ListExpander(0, 1, ";")
+		},
+		"person,1\n100,1\n": func(p *CSVIndexer) {
+			p.Header = true
+			p.Expand = p.MatrixExpander(2)
+		},
+		"person,1\n100,1,0\n": func(p *CSVIndexer) {
+			p.Header = true
+			p.FieldsPerRecord = -1
+			p.Expand = p.MatrixExpander(0)
+		},
+		"person,1,2\n100,1\n": func(p *CSVIndexer) {
+			p.Header = true
+			p.FieldsPerRecord = -1
+			p.Expand = p.MatrixExpander(0)
+		},
+	}
+
+	for in, setup := range tests {
+		p := NewCSVIndexer(strings.NewReader(in))
+		p.Parse = parsePair
+		setup(p)
+
+		if _, err := p.Index(); err == nil || !strings.Contains(err.Error(), "columns") {
+			t.Errorf("%q: expected columns error, got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package bitindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// A delta file is an append-only log of operations applied on top of a
+// base index file, so new pairs can be added without rebuilding the
+// index. It sits next to the base file and is folded into a new base
+// when the index is compacted. The checksum of the base is recorded so
+// a delta is never applied to a different base.
+//
+//	header   magic "BITD", uint16 version, uint16 zero, uint32 base CRC-32C
+//	records  op byte followed by its operands
+//
+// The operands of add and remove are the uvarint key and member. Label
+// records are the uvarint length and bytes of a label that is added to
+// the key or member dictionary, and a wide key record is the uvarint
+// 64-bit key added to the key map. Labels and keys must be recorded
+// before the operations that use their ids.
+
+// DeltaVersion is the version of the delta format written by DeltaWriter.
+const DeltaVersion uint16 = 1
+
+// Length of the magic bytes, version and base checksum.
+const deltaHeaderSize = 12
+
+// Delta operations.
+const (
+	opAdd byte = iota + 1
+	opRemove
+	opKeyLabel
+	opMemberLabel
+	opWideKey
+)
+
+var deltaMagic = []byte("BITD")
+
+var (
+	// ErrNotDelta is returned when the input does not begin with the
+	// magic bytes of a delta file.
+	ErrNotDelta = errors.New("Not a bitindex delta file")
+
+	// ErrDeltaBase is returned when the delta was written for a
+	// different base index file.
+	ErrDeltaBase = errors.New("Delta does not match the base index file")
+
+	// ErrDeltaCorrupt is returned when a record of the delta cannot be
+	// decoded.
+	ErrDeltaCorrupt = errors.New("Delta file is corrupt")
+)
+
+// DeltaPath returns the path of the delta file of the index file.
+func DeltaPath(path string) string {
+	return path + ".delta"
+}
+
+// deltaOp is a decoded delta record.
+type deltaOp struct {
+	op    byte
+	k     uint64
+	m     uint32
+	label string
+}
+
+// deltaReader counts the bytes read from a delta.
+type deltaReader struct {
+	*bufio.Reader
+	n int64
+}
+
+func (r *deltaReader) ReadByte() (byte, error) {
+	c, err := r.Reader.ReadByte()
+
+	if err == nil {
+		r.n++
+	}
+
+	return c, err
+}
+
+func (r *deltaReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// readDelta validates the header of the delta against the checksum of
+// the base and calls f for each record. It returns the length of the
+// complete part of the delta. A write may be cut short by a crash, so an
+// incomplete header or final record is ignored rather than an error;
+// a length of zero means the header is incomplete.
+func readDelta(r io.Reader, sum uint32, f func(o *deltaOp) error) (int64, error) {
+	br := &deltaReader{Reader: bufio.NewReader(r)}
+	b := make([]byte, deltaHeaderSize)
+
+	n, err := io.ReadFull(br, b)
+
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+
+	m := n
+
+	if m > len(deltaMagic) {
+		m = len(deltaMagic)
+	}
+
+	if !bytes.Equal(b[:m], deltaMagic[:m]) {
+		return 0, ErrNotDelta
+	}
+
+	if n < deltaHeaderSize {
+		return 0, nil
+	}
+
+	if v := binary.LittleEndian.Uint16(b[4:]); v != DeltaVersion {
+		return 0, fmt.Errorf("Unsupported delta format version %d, expected %d", v, DeltaVersion)
+	}
+
+	if binary.LittleEndian.Uint32(b[8:]) != sum {
+		return 0, ErrDeltaBase
+	}
+
+	var o deltaOp
+
+	for {
+		// End of the last complete record.
+		end := br.n
+
+		if o.op, err = br.ReadByte(); err == io.EOF {
+			return end, nil
+		} else if err != nil {
+			return end, err
+		}
+
+		switch o.op {
+		case opAdd, opRemove:
+			var m uint64
+
+			if o.k, err = readDeltaUvarint(br); err == nil {
+				m, err = readDeltaUvarint(br)
+			}
+
+			if err == nil && (o.k > math.MaxUint32 || m > math.MaxUint32) {
+				err = ErrDeltaCorrupt
+			}
+
+			o.m = uint32(m)
+
+		case opKeyLabel, opMemberLabel:
+			var l uint64
+
+			if l, err = readDeltaUvarint(br); err == nil {
+				var s []byte
+
+				if s, err = readAll(br, l); err == ErrTruncated {
+					err = errDeltaTorn
+				} else if err == nil {
+					o.label = string(s)
+				}
+			}
+
+		case opWideKey:
+			o.k, err = readDeltaUvarint(br)
+
+		default:
+			err = ErrDeltaCorrupt
+		}
+
+		if err == errDeltaTorn {
+			return end, nil
+		}
+
+		if err != nil {
+			return end, err
+		}
+
+		if err = f(&o); err != nil {
+			return end, err
+		}
+	}
+}
+
+// errDeltaTorn is returned when the delta ends within a record.
+var errDeltaTorn = errors.New("Delta record is incomplete")
+
+// readDeltaUvarint reads an operand of a record.
+func readDeltaUvarint(r io.ByteReader) (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, errDeltaTorn
+	}
+
+	if err != nil {
+		return 0, ErrDeltaCorrupt
+	}
+
+	return v, nil
+}
+
+// applyDelta applies the operations of the delta to the index.
+func applyDelta(ix *Index, r io.Reader, sum uint32) error {
+	_, err := readDelta(r, sum, func(o *deltaOp) error {
+		switch o.op {
+		case opAdd:
+			return ix.Add(uint32(o.k), o.m)
+
+		case opRemove:
+			_, err := ix.Remove(uint32(o.k), o.m)
+			return err
+
+		default:
+			return applyDeltaLabel(ix.KeyDict, ix.MemberDict, ix.WideKeys, o)
+		}
+	})
+
+	return err
+}
+
+// applyDeltaLabel adds the label or key of the record to the dictionaries
+// or key map.
+func applyDeltaLabel(keys, members *Dictionary, wide *KeyMap, o *deltaOp) error {
+	switch o.op {
+	case opKeyLabel:
+		if keys == nil {
+			return fmt.Errorf("Delta has key labels but the index has no key dictionary")
+		}
+
+		keys.Add(o.label)
+
+	case opMemberLabel:
+		if members == nil {
+			return fmt.Errorf("Delta has member labels but the index has no member dictionary")
+		}
+
+		members.Add(o.label)
+
+	case opWideKey:
+		if wide == nil {
+			return fmt.Errorf("Delta has 64-bit keys but the index has 32-bit keys")
+		}
+
+		wide.Add(o.k)
+	}
+
+	return nil
+}
+
+// LoadIndexFile loads the index file into memory and applies its delta
+// file if it exists.
+func LoadIndexFile(path string) (*Index, error) {
+	ab, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ix, err := LoadIndex(bytes.NewReader(ab))
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(DeltaPath(path))
+
+	if os.IsNotExist(err) {
+		return ix, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	// The checksum is the last field of the footer.
+	sum := binary.LittleEndian.Uint32(ab[len(ab)-4:])
+
+	if err = applyDelta(ix, f, sum); err != nil {
+		return nil, fmt.Errorf("Error applying delta: %s", err)
+	}
+
+	return ix, nil
+}
+
+// fileChecksum returns the checksum recorded in the footer of the index
+// file without reading the rest of it.
+func fileChecksum(f *os.File) (uint32, error) {
+	fi, err := f.Stat()
+
+	if err != nil {
+		return 0, err
+	}
+
+	n := fi.Size() - footerSize
+
+	if n < headerSize {
+		return 0, ErrTruncated
+	}
+
+	b := make([]byte, footerSize)
+
+	if _, err = f.ReadAt(b, n); err != nil {
+		return 0, err
+	}
+
+	if binary.LittleEndian.Uint64(b) != uint64(n) {
+		return 0, ErrTruncated
+	}
+
+	return binary.LittleEndian.Uint32(b[8:]), nil
+}
+
+// DeltaWriter appends operations to the delta file of an index file.
+type DeltaWriter struct {
+	// Dictionaries and key map of the base index including the labels
+	// and keys in the delta. Labels and keys added to them are written
+	// to the delta before the next operation.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+	WideKeys   *KeyMap
+
+	f *os.File
+	w *bufio.Writer
+	b []byte
+
+	// Number of labels and keys already in the delta or the base.
+	nkeys, nmembers, nwide int
+}
+
+// OpenDelta opens the delta file of the index file for appending. It is
+// created if it does not exist. An incomplete final record left by an
+// interrupted write is truncated.
+func OpenDelta(path string) (*DeltaWriter, error) {
+	base, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer base.Close()
+
+	if _, err = readHeader(base); err != nil {
+		return nil, err
+	}
+
+	sum, err := fileChecksum(base)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dw := &DeltaWriter{
+		b: make([]byte, binary.MaxVarintLen64),
+	}
+
+	loaders := []func(io.Reader) error{
+		func(r io.Reader) (err error) {
+			dw.KeyDict, err = LoadKeyDict(r)
+			return err
+		},
+		func(r io.Reader) (err error) {
+			dw.MemberDict, err = LoadMemberDict(r)
+			return err
+		},
+		func(r io.Reader) (err error) {
+			dw.WideKeys, err = LoadWideKeys(r)
+			return err
+		},
+	}
+
+	for _, load := range loaders {
+		if _, err = base.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		if err = load(base); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(DeltaPath(path), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Labels and keys already in the delta.
+	end, err := readDelta(f, sum, func(o *deltaOp) error {
+		if o.op == opAdd || o.op == opRemove {
+			return nil
+		}
+
+		return applyDeltaLabel(dw.KeyDict, dw.MemberDict, dw.WideKeys, o)
+	})
+
+	// An incomplete final record is dropped so the next one is not
+	// appended to it.
+	if err == nil && end < fi.Size() {
+		err = f.Truncate(end)
+	}
+
+	if err == nil && end == 0 {
+		// New delta.
+		h := make([]byte, deltaHeaderSize)
+
+		copy(h, deltaMagic)
+		binary.LittleEndian.PutUint16(h[4:], DeltaVersion)
+		binary.LittleEndian.PutUint32(h[8:], sum)
+
+		_, err = f.Write(h)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	dw.f = f
+	dw.w = bufio.NewWriter(f)
+	dw.nkeys, dw.nmembers, dw.nwide = dw.sizes()
+
+	return dw, nil
+}
+
+// sizes returns the number of labels and keys in the dictionaries and
+// key map.
+func (dw *DeltaWriter) sizes() (int, int, int) {
+	var k, m, w int
+
+	if dw.KeyDict != nil {
+		k = dw.KeyDict.Size()
+	}
+
+	if dw.MemberDict != nil {
+		m = dw.MemberDict.Size()
+	}
+
+	if dw.WideKeys != nil {
+		w = dw.WideKeys.Size()
+	}
+
+	return k, m, w
+}
+
+func (dw *DeltaWriter) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(dw.b, v)
+	_, err := dw.w.Write(dw.b[:n])
+	return err
+}
+
+func (dw *DeltaWriter) writeLabel(op byte, s string) error {
+	if err := dw.w.WriteByte(op); err != nil {
+		return err
+	}
+
+	if err := dw.writeUvarint(uint64(len(s))); err != nil {
+		return err
+	}
+
+	_, err := dw.w.WriteString(s)
+	return err
+}
+
+// flushLabels writes the labels and keys added since the last operation.
+func (dw *DeltaWriter) flushLabels() error {
+	nk, nm, nw := dw.sizes()
+
+	for ; dw.nkeys < nk; dw.nkeys++ {
+		s, _ := dw.KeyDict.Label(uint32(dw.nkeys))
+
+		if err := dw.writeLabel(opKeyLabel, s); err != nil {
+			return fmt.Errorf("Error writing key label: %s", err)
+		}
+	}
+
+	for ; dw.nmembers < nm; dw.nmembers++ {
+		s, _ := dw.MemberDict.Label(uint32(dw.nmembers))
+
+		if err := dw.writeLabel(opMemberLabel, s); err != nil {
+			return fmt.Errorf("Error writing member label: %s", err)
+		}
+	}
+
+	for ; dw.nwide < nw; dw.nwide++ {
+		k, _ := dw.WideKeys.Key(uint32(dw.nwide))
+
+		if err := dw.w.WriteByte(opWideKey); err != nil {
+			return fmt.Errorf("Error writing key: %s", err)
+		}
+
+		if err := dw.writeUvarint(k); err != nil {
+			return fmt.Errorf("Error writing key: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (dw *DeltaWriter) write(op byte, k, m uint32) error {
+	if err := dw.flushLabels(); err != nil {
+		return err
+	}
+
+	if err := dw.w.WriteByte(op); err != nil {
+		return fmt.Errorf("Error writing delta: %s", err)
+	}
+
+	if err := dw.writeUvarint(uint64(k)); err != nil {
+		return fmt.Errorf("Error writing delta: %s", err)
+	}
+
+	if err := dw.writeUvarint(uint64(m)); err != nil {
+		return fmt.Errorf("Error writing delta: %s", err)
+	}
+
+	return nil
+}
+
+// Add appends an operation that sets the bit for key `k` for member `m`.
+func (dw *DeltaWriter) Add(k uint32, m uint32) error {
+	return dw.write(opAdd, k, m)
+}
+
+// Remove appends an operation that clears the bit for key `k` for
+// member `m`.
+func (dw *DeltaWriter) Remove(k uint32, m uint32) error {
+	return dw.write(opRemove, k, m)
+}
+
+// Close flushes the operations, syncs and closes the delta file.
+func (dw *DeltaWriter) Close() error {
+	err := dw.flushLabels()
+
+	if err == nil {
+		err = dw.w.Flush()
+	}
+
+	if err == nil {
+		err = dw.f.Sync()
+	}
+
+	if cerr := dw.f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
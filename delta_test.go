@@ -0,0 +1,200 @@
+package bitindex
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDelta(t *testing.T) {
+	ix1 := newLabeledIndex(t)
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	dw, err := OpenDelta(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// D400 is a new key and K21 is a new member.
+	if err = dw.Add(dw.KeyDict.Add("D400"), dw.MemberDict.Add("K21")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Add(dw.KeyDict.Add("A100"), dw.MemberDict.Add("J45")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Remove(dw.KeyDict.Add("C300"), dw.MemberDict.Add("E11.9")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Labels already in the delta are not written again.
+	if dw, err = OpenDelta(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Add(dw.KeyDict.Add("D400"), dw.MemberDict.Add("J45")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ix2, err := LoadIndexFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"any(K21)":   "D400",
+		"any(J45)":   "A100,B200,C300,D400",
+		"any(E11.9)": "A100",
+	}
+
+	for in, exp := range tests {
+		e, err := ParseExpr(in)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := ix2.QueryExpr(e)
+
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+
+		got := ix2.KeyLabels(r.Items())
+		sameLabels(t, in, got, strings.Split(exp, ","))
+	}
+
+	if ix2.KeyDict.Size() != 4 || ix2.MemberDict.Size() != 5 {
+		t.Errorf("expected 4 keys and 5 members, got %d and %d", ix2.KeyDict.Size(), ix2.MemberDict.Size())
+	}
+}
+
+func sameLabels(t *testing.T, name string, got, exp []string) {
+	set := make(map[string]bool, len(got))
+
+	for _, s := range got {
+		set[s] = true
+	}
+
+	if len(got) != len(exp) {
+		t.Errorf("%s: expected %v, got %v", name, exp, got)
+		return
+	}
+
+	for _, s := range exp {
+		if !set[s] {
+			t.Errorf("%s: expected %v, got %v", name, exp, got)
+			return
+		}
+	}
+}
+
+func TestDeltaErrors(t *testing.T) {
+	path, cleanup := writeIndex(t, newPostingsIndex())
+	defer cleanup()
+
+	dw, err := OpenDelta(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Add(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Add(2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	delta := DeltaPath(path)
+
+	fi, err := os.Stat(delta)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn final record is dropped.
+	if err = os.Truncate(delta, fi.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := LoadIndexFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ix.Has(1, 2) || ix.Has(2, 3) {
+		t.Errorf("expected only the complete record to be applied")
+	}
+
+	// And truncated when the delta is opened.
+	if dw, err = OpenDelta(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Add(2, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ix, err = LoadIndexFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ix.Has(1, 2) || ix.Has(2, 3) || !ix.Has(2, 4) {
+		t.Errorf("expected the records after the torn one to be applied")
+	}
+
+	// An unknown record is a delta error.
+	f, err := os.OpenFile(delta, os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte{0xff})
+	f.Close()
+
+	if _, err = LoadIndexFile(path); err == nil || !strings.Contains(err.Error(), ErrDeltaCorrupt.Error()) {
+		t.Errorf("expected corrupt delta error, got %v", err)
+	}
+
+	// A delta of another index.
+	other, cleanup2 := writeIndex(t, newLabeledIndex(t))
+	defer cleanup2()
+
+	if err = os.Rename(delta, DeltaPath(other)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadIndexFile(other); err == nil || !strings.Contains(err.Error(), ErrDeltaBase.Error()) {
+		t.Errorf("expected base error, got %v", err)
+	}
+
+	if _, err = OpenDelta(other); err != ErrDeltaBase {
+		t.Errorf("expected base error, got %v", err)
+	}
+}
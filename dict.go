@@ -0,0 +1,483 @@
+package bitindex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Dictionary assigns integer ids to string labels so that keys and
+// members such as medical record numbers or diagnosis codes can be
+// indexed. Ids are assigned in the order labels are added, starting
+// at zero.
+type Dictionary struct {
+	// Label -> Id
+	ids map[string]uint32
+
+	// Id -> Label
+	labels []string
+
+	// Set if the index was opened with OpenIndex. Labels are read
+	// from the mapping on demand.
+	m *dictLayout
+}
+
+// Add adds a label to the dictionary and returns its id.
+func (d *Dictionary) Add(s string) uint32 {
+	if d.m != nil {
+		panic("dictionary is read-only")
+	}
+
+	// Do not add duplicates.
+	if id, ok := d.ids[s]; ok {
+		return id
+	}
+
+	id := uint32(len(d.labels))
+
+	d.ids[s] = id
+	d.labels = append(d.labels, s)
+
+	return id
+}
+
+// ID returns the id of the label.
+func (d *Dictionary) ID(s string) (uint32, bool) {
+	if d.m != nil {
+		return d.m.id(s)
+	}
+
+	id, ok := d.ids[s]
+	return id, ok
+}
+
+// Label returns the label of the id.
+func (d *Dictionary) Label(id uint32) (string, bool) {
+	if d.m != nil {
+		return d.m.label(id)
+	}
+
+	if int(id) >= len(d.labels) {
+		return "", false
+	}
+
+	return d.labels[id], true
+}
+
+// Size returns the number of labels in the dictionary.
+func (d *Dictionary) Size() int {
+	if d.m != nil {
+		return d.m.size()
+	}
+
+	return len(d.labels)
+}
+
+// NewDictionary initializes an empty dictionary.
+func NewDictionary() *Dictionary {
+	return &Dictionary{
+		ids: make(map[string]uint32),
+	}
+}
+
+// dictLayout locates a dictionary in an index held in memory.
+type dictLayout struct {
+	// Offsets of the labels in id order.
+	offs []byte
+
+	// Ids in label order.
+	sorted []byte
+
+	// Labels in id order.
+	data []byte
+}
+
+func (l *dictLayout) size() int {
+	return len(l.sorted) / 4
+}
+
+func (l *dictLayout) label(id uint32) (string, bool) {
+	if int(id) >= l.size() {
+		return "", false
+	}
+
+	i := uint64(id) * 8
+
+	// The offsets are checked by decodeDictionary.
+	lo := binary.LittleEndian.Uint64(l.offs[i:])
+	hi := binary.LittleEndian.Uint64(l.offs[i+8:])
+
+	return string(l.data[lo:hi]), true
+}
+
+func (l *dictLayout) id(s string) (uint32, bool) {
+	n := l.size()
+
+	at := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(l.sorted[i*4:])
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		x, _ := l.label(at(i))
+		return x >= s
+	})
+
+	if i < n {
+		if x, _ := l.label(at(i)); x == s {
+			return at(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// decodeDictionary locates the parts of a dictionary section.
+func decodeDictionary(b []byte) (*dictLayout, error) {
+	if len(b) < 4 {
+		return nil, ErrCorrupt
+	}
+
+	n := uint64(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+
+	if uint64(len(b)) < (n+1)*8+n*4 {
+		return nil, ErrCorrupt
+	}
+
+	l := &dictLayout{
+		offs:   b[:(n+1)*8],
+		sorted: b[(n+1)*8 : (n+1)*8+n*4],
+		data:   b[(n+1)*8+n*4:],
+	}
+
+	// Offsets must ascend to the end of the labels so that a label
+	// can be sliced without checking its bounds.
+	var off uint64
+
+	for i := uint64(0); i <= n; i++ {
+		x := binary.LittleEndian.Uint64(l.offs[i*8:])
+
+		if x < off {
+			return nil, ErrCorrupt
+		}
+
+		off = x
+	}
+
+	if off != uint64(len(l.data)) {
+		return nil, ErrCorrupt
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if binary.LittleEndian.Uint32(l.sorted[i*4:]) >= uint32(n) {
+			return nil, ErrCorrupt
+		}
+	}
+
+	return l, nil
+}
+
+// load decodes the labels into a dictionary held in memory.
+func (l *dictLayout) load() *Dictionary {
+	d := NewDictionary()
+
+	for i, n := 0, l.size(); i < n; i++ {
+		s, _ := l.label(uint32(i))
+		d.Add(s)
+	}
+
+	return d
+}
+
+// dictionarySize returns the number of bytes of the encoded dictionary.
+func dictionarySize(d *Dictionary) uint64 {
+	n := uint64(d.Size())
+	size := 4 + (n+1)*8 + n*4
+
+	for i := uint32(0); i < uint32(n); i++ {
+		s, _ := d.Label(i)
+		size += uint64(len(s))
+	}
+
+	return size
+}
+
+func dumpDictionary(w io.Writer, d *Dictionary, b []byte) error {
+	n := d.Size()
+
+	if err := writeUint32(w, b, uint32(n)); err != nil {
+		return fmt.Errorf("Error writing dictionary length: %s", err)
+	}
+
+	var off uint64
+
+	if err := writeUint64(w, b, off); err != nil {
+		return fmt.Errorf("Error writing dictionary offset: %s", err)
+	}
+
+	for i := 0; i < n; i++ {
+		s, _ := d.Label(uint32(i))
+		off += uint64(len(s))
+
+		if err := writeUint64(w, b, off); err != nil {
+			return fmt.Errorf("Error writing dictionary offset: %s", err)
+		}
+	}
+
+	// Ids in label order for lookups by label.
+	ids := make([]uint32, n)
+
+	for i := range ids {
+		ids[i] = uint32(i)
+	}
+
+	sort.Sort(&labelOrder{d, ids})
+
+	for _, id := range ids {
+		if err := writeUint32(w, b, id); err != nil {
+			return fmt.Errorf("Error writing dictionary id: %s", err)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		s, _ := d.Label(uint32(i))
+
+		if _, err := io.WriteString(w, s); err != nil {
+			return fmt.Errorf("Error writing dictionary label: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// labelOrder sorts ids by their label.
+type labelOrder struct {
+	d   *Dictionary
+	ids []uint32
+}
+
+func (o *labelOrder) Len() int {
+	return len(o.ids)
+}
+
+func (o *labelOrder) Less(i, j int) bool {
+	x, _ := o.d.Label(o.ids[i])
+	y, _ := o.d.Label(o.ids[j])
+	return x < y
+}
+
+func (o *labelOrder) Swap(i, j int) {
+	o.ids[i], o.ids[j] = o.ids[j], o.ids[i]
+}
+
+// LookupMembers returns the members of the labels. If the index has no
+// member dictionary, the labels must be integers. A label of the form
+// descendants(<label>) is expanded to the member and the members under
+// it in the hierarchy, and it is an error if none of them are in the
+// domain. Labels are the only input that is expanded; the members passed
+// to Any, Query, Count and the other operations are used as given, so
+// expand them with Descendants first or set the Descendants of an OpExpr.
+func (ix *Index) LookupMembers(labels ...string) ([]uint32, error) {
+	var (
+		roots []string
+		plain []string
+	)
+
+	for _, s := range labels {
+		if l, ok := descendantsLabel(s); ok {
+			roots = append(roots, l)
+		} else {
+			plain = append(plain, s)
+		}
+	}
+
+	if roots == nil {
+		return lookupLabels(ix.MemberDict, labels)
+	}
+
+	ms, err := lookupLabels(ix.MemberDict, plain)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := lookupLabels(ix.MemberDict, roots)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ds, err := ix.Descendants(rs...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Expanded members may overlap with the others.
+	seen := make(map[uint32]struct{}, len(ms)+len(ds))
+	out := []uint32{}
+
+	for _, m := range append(ms, ds...) {
+		if _, ok := seen[m]; !ok {
+			seen[m] = struct{}{}
+			out = append(out, m)
+		}
+	}
+
+	return out, nil
+}
+
+// LookupKeys returns the keys of the labels. If the index has no key
+// dictionary, the labels must be integers. For an index with 64-bit keys,
+// the ids of the keys are returned.
+func (ix *Index) LookupKeys(labels ...string) ([]uint32, error) {
+	if ix.WideKeys != nil && ix.KeyDict == nil {
+		return lookupWideKeys(ix.WideKeys, labels)
+	}
+
+	return lookupLabels(ix.KeyDict, labels)
+}
+
+// MemberLabels returns the labels of the members. If the index has no
+// member dictionary, the members are formatted as integers.
+func (ix *Index) MemberLabels(ms []uint32) []string {
+	return formatLabels(ix.MemberDict, ms)
+}
+
+// KeyLabels returns the labels of the keys. If the index has no key
+// dictionary, the keys are formatted as integers. For an index with
+// 64-bit keys, the 64-bit keys of the ids are formatted.
+func (ix *Index) KeyLabels(keys []uint32) []string {
+	if ix.WideKeys != nil && ix.KeyDict == nil {
+		labels := make([]string, len(keys))
+
+		for i, k := range ix.Keys64(keys) {
+			labels[i] = strconv.FormatUint(k, 10)
+		}
+
+		return labels
+	}
+
+	return formatLabels(ix.KeyDict, keys)
+}
+
+func lookupLabels(d *Dictionary, labels []string) ([]uint32, error) {
+	if labels == nil {
+		return nil, nil
+	}
+
+	ids := make([]uint32, len(labels))
+
+	for i, s := range labels {
+		if d == nil {
+			n, err := strconv.ParseUint(s, 10, 32)
+
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an integer", s)
+			}
+
+			ids[i] = uint32(n)
+			continue
+		}
+
+		id, ok := d.ID(s)
+
+		if !ok {
+			return nil, fmt.Errorf("%q is not in the dictionary", s)
+		}
+
+		ids[i] = id
+	}
+
+	return ids, nil
+}
+
+func lookupWideKeys(km *KeyMap, labels []string) ([]uint32, error) {
+	if labels == nil {
+		return nil, nil
+	}
+
+	ids := make([]uint32, len(labels))
+
+	for i, s := range labels {
+		k, err := strconv.ParseUint(s, 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", s)
+		}
+
+		id, ok := km.ID(k)
+
+		if !ok {
+			return nil, fmt.Errorf("%d is not a key", k)
+		}
+
+		ids[i] = id
+	}
+
+	return ids, nil
+}
+
+func formatLabels(d *Dictionary, ids []uint32) []string {
+	labels := make([]string, len(ids))
+
+	for i, id := range ids {
+		if d == nil {
+			labels[i] = strconv.FormatUint(uint64(id), 10)
+		} else {
+			labels[i], _ = d.Label(id)
+		}
+	}
+
+	return labels
+}
+
+// loadDictionary loads only a dictionary from an io.Reader. It returns
+// nil if the index does not have the dictionary.
+func loadDictionary(r io.Reader, id uint32, flag uint16) (*Dictionary, error) {
+	h, err := readHeader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Flags&flag == 0 {
+		return nil, nil
+	}
+
+	toc, err := readTOC(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readSection(r, h, toc, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := decodeDictionary(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.load(), nil
+}
+
+// LoadKeyDict loads only the dictionary of the key labels from an
+// io.Reader. It returns nil if the keys are not labeled. The checksum
+// is not validated.
+func LoadKeyDict(r io.Reader) (*Dictionary, error) {
+	return loadDictionary(r, sectionKeyDict, flagKeyDict)
+}
+
+// LoadMemberDict loads only the dictionary of the member labels from an
+// io.Reader. It returns nil if the members are not labeled. The checksum
+// is not validated.
+func LoadMemberDict(r io.Reader) (*Dictionary, error) {
+	return loadDictionary(r, sectionMemberDict, flagMemberDict)
+}
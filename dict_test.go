@@ -0,0 +1,152 @@
+package bitindex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDictionary(t *testing.T) {
+	d := NewDictionary()
+
+	for i, s := range []string{"E11.9", "I10", "E11.9", "J45"} {
+		id := d.Add(s)
+
+		if l, _ := d.Label(id); l != s {
+			t.Errorf("%d: expected label %s, got %s", i, s, l)
+		}
+	}
+
+	if d.Size() != 3 {
+		t.Errorf("expected size 3, got %d", d.Size())
+	}
+
+	if id, ok := d.ID("J45"); !ok || id != 2 {
+		t.Errorf("expected id 2, got %d", id)
+	}
+
+	if _, ok := d.ID("Z00"); ok {
+		t.Errorf("expected Z00 to be absent")
+	}
+
+	if _, ok := d.Label(3); ok {
+		t.Errorf("expected id 3 to be absent")
+	}
+}
+
+func newLabeledIndex(t *testing.T) *Index {
+	in := "mrn,code\nA100,E11.9\nA100,I10\nB200,J45\nC300,J45\nC300,E11.9\nC300,I10 X\n"
+
+	p := NewCSVIndexer(strings.NewReader(in))
+	p.Header = true
+	p.ParseLabels = func(row []string) (string, string, error) {
+		return row[0], row[1], nil
+	}
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ix
+}
+
+func TestLabeledIndex(t *testing.T) {
+	ix1 := newLabeledIndex(t)
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix1); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// Readers that do not know the dictionaries reject the file.
+	if h, err := parseHeader(data); err != nil || h.Flags != flagKeyDict|flagMemberDict {
+		t.Errorf("expected the dictionary flags, got %#x (%v)", h.Flags, err)
+	}
+
+	ix2, err := LoadIndex(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	ix3, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ix3.Close()
+
+	tests := map[string][]string{
+		"any(J45)":                    {"B200", "C300"},
+		`all(E11.9, "I10 X")`:         {"C300"},
+		"any(E11.9) AND NOT any(I10)": {"C300"},
+		"atleast(2, E11.9, I10, J45)": {"A100", "C300"},
+	}
+
+	for name, ix := range map[string]*Index{"memory": ix1, "loaded": ix2, "mapped": ix3} {
+		if ix.KeyDict == nil || ix.MemberDict == nil {
+			t.Fatalf("%s: expected dictionaries", name)
+		}
+
+		for in, exp := range tests {
+			e, err := ParseExpr(in)
+
+			if err != nil {
+				t.Fatalf("%s: %s", in, err)
+			}
+
+			r, err := ix.QueryExpr(e)
+
+			if err != nil {
+				t.Fatalf("%s: %s: %s", name, in, err)
+			}
+
+			if got := ix.KeyLabels(r.Items()); strings.Join(got, ",") != strings.Join(exp, ",") {
+				t.Errorf("%s: %s: expected %v, got %v", name, in, exp, got)
+			}
+		}
+
+		if _, err := ix.LookupMembers("Z00"); err == nil {
+			t.Errorf("%s: expected lookup error", name)
+		}
+
+		ks, err := ix.LookupKeys("C300")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ix.Has(ks[0], ix.Domain.Member(0)) {
+			t.Errorf("%s: expected C300 to have E11.9", name)
+		}
+	}
+
+	d, err := LoadMemberDict(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d == nil || d.Size() != 4 {
+		t.Fatalf("expected 4 member labels, got %v", d)
+	}
+
+	buf.Reset()
+
+	if err = DumpIndex(buf, newPostingsIndex()); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err = LoadKeyDict(buf); err != nil || d != nil {
+		t.Errorf("expected no key dictionary, got %v (%v)", d, err)
+	}
+}
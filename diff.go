@@ -0,0 +1,281 @@
+package bitindex
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// KeyChange is a change of the members of a key. A key that was added has
+// only added members and a key that was removed has only removed ones.
+type KeyChange struct {
+	Key     string
+	Added   []string
+	Removed []string
+}
+
+// IndexDiff is the difference from an old index to a new one. Keys and
+// members are compared by their labels, or by their integers if they
+// are not labeled, so the bits of the domains and the ids of the labels
+// may differ between the two.
+type IndexDiff struct {
+	// Members only in the domain of the new or the old index.
+	MembersAdded   []string
+	MembersRemoved []string
+
+	// Keys only in the new or the old index with their members.
+	KeysAdded   []KeyChange
+	KeysRemoved []KeyChange
+
+	// Keys in both indexes whose members differ.
+	KeysChanged []KeyChange
+
+	// Set if either index has labels, which are written as strings
+	// rather than numbers.
+	keyLabels, memberLabels bool
+}
+
+// Empty returns true if the indexes have the same domain and pairs.
+func (d *IndexDiff) Empty() bool {
+	return len(d.MembersAdded) == 0 && len(d.MembersRemoved) == 0 &&
+		len(d.KeysAdded) == 0 && len(d.KeysRemoved) == 0 && len(d.KeysChanged) == 0
+}
+
+// Pairs returns the number of key/member pairs added and removed.
+func (d *IndexDiff) Pairs() (int, int) {
+	var added, removed int
+
+	for _, cs := range [][]KeyChange{d.KeysAdded, d.KeysRemoved, d.KeysChanged} {
+		for _, c := range cs {
+			added += len(c.Added)
+			removed += len(c.Removed)
+		}
+	}
+
+	return added, removed
+}
+
+// diffDomain is the domain of an index by label.
+type diffDomain struct {
+	// Label of each bit.
+	labels []string
+
+	// Bit of each label. Retired members are left out.
+	bits map[string]uint32
+}
+
+func newDiffDomain(ix *Index) *diffDomain {
+	d := ix.Domain
+	labels := ix.MemberLabels(d.Members()[:d.Size()])
+	bits := make(map[string]uint32, len(labels))
+
+	for b, s := range labels {
+		if _, ok := d.dead[uint32(b)]; !ok {
+			bits[s] = uint32(b)
+		}
+	}
+
+	return &diffDomain{
+		labels: labels,
+		bits:   bits,
+	}
+}
+
+// live returns true if the bit is not retired.
+func (d *diffDomain) live(b int) bool {
+	x, ok := d.bits[d.labels[b]]
+	return ok && int(x) == b
+}
+
+// translate returns the bit in the other domain of each bit of the
+// domain, or -1 if the other domain does not have the member.
+func (d *diffDomain) translate(o *diffDomain) []int {
+	bits := make([]int, len(d.labels))
+
+	for b, s := range d.labels {
+		bits[b] = -1
+
+		if x, ok := o.bits[s]; ok && d.live(b) {
+			bits[b] = int(x)
+		}
+	}
+
+	return bits
+}
+
+// rowLabels returns the labels of the members of the array.
+func (d *diffDomain) rowLabels(a Array) []string {
+	bits := a.Bits()
+	labels := make([]string, len(bits))
+
+	for i, b := range bits {
+		labels[i] = d.labels[b]
+	}
+
+	return labels
+}
+
+// changed returns the labels of the members of the array that the other
+// array does not have.
+func (d *diffDomain) changed(a, o Array, obits []int) []string {
+	var labels []string
+
+	for _, b := range a.Bits() {
+		if x := obits[b]; x < 0 || !o.Has(uint32(x)) {
+			labels = append(labels, d.labels[b])
+		}
+	}
+
+	return labels
+}
+
+// Diff compares the old index a to the new index b. Keys are listed in
+// ascending order of their ids and members in bit order. An error is
+// returned if an array of a mapped index cannot be decoded.
+func Diff(a, b *Index) (*IndexDiff, error) {
+	diff := &IndexDiff{
+		keyLabels:    a.KeyDict != nil || b.KeyDict != nil,
+		memberLabels: a.MemberDict != nil || b.MemberDict != nil,
+	}
+
+	ad := newDiffDomain(a)
+	bd := newDiffDomain(b)
+
+	// Bits of each domain in the other.
+	abits := bd.translate(ad)
+	bbits := ad.translate(bd)
+
+	for i, x := range abits {
+		if x < 0 && bd.live(i) {
+			diff.MembersAdded = append(diff.MembersAdded, bd.labels[i])
+		}
+	}
+
+	for i, x := range bbits {
+		if x < 0 && ad.live(i) {
+			diff.MembersRemoved = append(diff.MembersRemoved, ad.labels[i])
+		}
+	}
+
+	akeys := a.Keys().Bits()
+	alabels := a.KeyLabels(akeys)
+	aids := make(map[string]uint32, len(akeys))
+
+	for i, s := range alabels {
+		aids[s] = akeys[i]
+	}
+
+	bkeys := b.Keys().Bits()
+	bids := make(map[string]struct{}, len(bkeys))
+
+	for i, s := range b.KeyLabels(bkeys) {
+		bids[s] = struct{}{}
+
+		brow := b.Get(bkeys[i])
+		ak, ok := aids[s]
+
+		if !ok {
+			diff.KeysAdded = append(diff.KeysAdded, KeyChange{
+				Key:   s,
+				Added: bd.rowLabels(brow),
+			})
+
+			continue
+		}
+
+		arow := a.Get(ak)
+
+		c := KeyChange{
+			Key:     s,
+			Added:   bd.changed(brow, arow, abits),
+			Removed: ad.changed(arow, brow, bbits),
+		}
+
+		if c.Added != nil || c.Removed != nil {
+			diff.KeysChanged = append(diff.KeysChanged, c)
+		}
+	}
+
+	for i, s := range alabels {
+		if _, ok := bids[s]; !ok {
+			diff.KeysRemoved = append(diff.KeysRemoved, KeyChange{
+				Key:     s,
+				Removed: ad.rowLabels(a.Get(akeys[i])),
+			})
+		}
+	}
+
+	for _, ix := range []*Index{a, b} {
+		if err := ix.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+// diffLine is a change written by WriteJSON.
+type diffLine struct {
+	Change  string            `json:"change"`
+	Key     json.RawMessage   `json:"key,omitempty"`
+	Member  json.RawMessage   `json:"member,omitempty"`
+	Added   []json.RawMessage `json:"added,omitempty"`
+	Removed []json.RawMessage `json:"removed,omitempty"`
+}
+
+func (d *IndexDiff) jsonMembers(ms []string) []json.RawMessage {
+	vs := make([]json.RawMessage, len(ms))
+
+	for i, m := range ms {
+		vs[i] = jsonValue(m, d.memberLabels)
+	}
+
+	return vs
+}
+
+// WriteJSON writes the changes as JSON Lines, one change per line with a
+// change field of member_added, member_removed, key_added, key_removed
+// or key_changed. Labels are written as strings and integers as numbers
+// as in the JSON Lines export.
+func (d *IndexDiff) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, x := range []struct {
+		change  string
+		members []string
+	}{
+		{"member_added", d.MembersAdded},
+		{"member_removed", d.MembersRemoved},
+	} {
+		for _, m := range x.members {
+			if err := enc.Encode(diffLine{Change: x.change, Member: jsonValue(m, d.memberLabels)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, x := range []struct {
+		change string
+		keys   []KeyChange
+	}{
+		{"key_added", d.KeysAdded},
+		{"key_removed", d.KeysRemoved},
+		{"key_changed", d.KeysChanged},
+	} {
+		for _, c := range x.keys {
+			line := diffLine{
+				Change:  x.change,
+				Key:     jsonValue(c.Key, d.keyLabels),
+				Added:   d.jsonMembers(c.Added),
+				Removed: d.jsonMembers(c.Removed),
+			}
+
+			if err := enc.Encode(line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
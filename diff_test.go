@@ -0,0 +1,138 @@
+package bitindex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := NewIndex(fruit)
+
+	for k, ms := range pairs {
+		for _, m := range ms {
+			a.Add(k, m)
+		}
+	}
+
+	// The members are added in reverse so every bit differs.
+	var rev []uint32
+
+	for i := len(fruit) - 1; i >= 0; i-- {
+		rev = append(rev, fruit[i])
+	}
+
+	b := NewIndex(rev[1:])
+	b.Domain.Add(10)
+
+	b.Add(100, 1)
+	b.Add(100, 3)
+	b.Add(101, 4)
+	b.Add(101, 10)
+	b.Add(103, 2)
+
+	d := mustDiff(t, a, b)
+
+	checks := []struct {
+		name string
+		got  interface{}
+		exp  string
+	}{
+		{"members added", d.MembersAdded, "[10]"},
+		{"members removed", d.MembersRemoved, "[9]"},
+		{"keys added", d.KeysAdded, "[{103 [2] []}]"},
+		{"keys removed", d.KeysRemoved, "[{102 [] [2 3 4]}]"},
+		{"keys changed", d.KeysChanged, "[{101 [10] [9]}]"},
+	}
+
+	for _, c := range checks {
+		if got := fmt.Sprint(c.got); got != c.exp {
+			t.Errorf("%s: expected %s, got %s", c.name, c.exp, got)
+		}
+	}
+
+	if added, removed := d.Pairs(); added != 2 || removed != 4 {
+		t.Errorf("expected 2 pairs added and 4 removed, got %d and %d", added, removed)
+	}
+
+	buf := new(bytes.Buffer)
+
+	if err := d.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"change":"member_added","member":10}
+{"change":"member_removed","member":9}
+{"change":"key_added","key":103,"added":[2]}
+{"change":"key_removed","key":102,"removed":[2,3,4]}
+{"change":"key_changed","key":101,"added":[10],"removed":[9]}
+`
+
+	if buf.String() != exp {
+		t.Errorf("expected\n%s\ngot\n%s", exp, buf.String())
+	}
+
+	if !mustDiff(t, a, a).Empty() {
+		t.Errorf("expected no changes to the same index")
+	}
+}
+
+func TestDiffLabels(t *testing.T) {
+	a := newLabeledIndex(t)
+
+	// Renumbering the labels and bits is not a change.
+	b := newLabeledIndex(t)
+	b.Canonicalize(true)
+
+	if d := mustDiff(t, a, b); !d.Empty() {
+		t.Fatalf("expected no changes, got %+v", d)
+	}
+
+	b.Add(b.KeyDict.Add("D400"), b.MemberDict.Add("I10"))
+	b.Remove(mustID(t, b.KeyDict, "A100"), mustID(t, b.MemberDict, "E11.9"))
+
+	buf := new(bytes.Buffer)
+
+	if err := mustDiff(t, a, b).WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		`{"change":"key_added","key":"D400","added":["I10"]}`,
+		`{"change":"key_changed","key":"A100","removed":["E11.9"]}`,
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	if strings.Join(lines, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(exp, "\n"), buf.String())
+	}
+
+	// Retired members are not in the domain.
+	b.Retire(mustID(t, b.MemberDict, "J45"))
+
+	if d := mustDiff(t, a, b); fmt.Sprint(d.MembersRemoved) != "[J45]" {
+		t.Errorf("expected J45 to be removed, got %v", d.MembersRemoved)
+	}
+}
+
+func mustDiff(t *testing.T, a, b *Index) *IndexDiff {
+	d, err := Diff(a, b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func mustID(t *testing.T, d *Dictionary, s string) uint32 {
+	id, ok := d.ID(s)
+
+	if !ok {
+		t.Fatalf("no id for %s", s)
+	}
+
+	return id
+}
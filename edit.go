@@ -0,0 +1,252 @@
+package bitindex
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrRetired is returned when dumping an index whose domain has retired
+// members that have not been compacted.
+var ErrRetired = errors.New("Index has retired members, compact it first")
+
+// Retire marks the member as retired and returns its bit. The member is
+// no longer part of the domain, but its bit is not reclaimed until the
+// domain is compacted.
+func (d *Domain) Retire(m uint32) (uint32, bool) {
+	b, ok := d.f[m]
+
+	if !ok {
+		return 0, false
+	}
+
+	if d.dead == nil {
+		d.dead = make(map[uint32]struct{})
+	}
+
+	delete(d.f, m)
+	d.dead[b] = struct{}{}
+
+	return b, true
+}
+
+// Retired returns the number of retired members that have not been
+// compacted.
+func (d *Domain) Retired() int {
+	return len(d.dead)
+}
+
+// Compact reclaims the bits of the retired members. The remaining members
+// keep their order and are renumbered. The new bit of each old bit is
+// returned, or -1 if the member was retired.
+func (d *Domain) Compact() []int {
+	bits := make([]int, d.b)
+	r := make([]uint32, 0, int(d.b)-len(d.dead))
+
+	for b, m := range d.r[:d.b] {
+		if _, ok := d.dead[uint32(b)]; ok {
+			bits[b] = -1
+			continue
+		}
+
+		bits[b] = len(r)
+		d.f[m] = uint32(len(r))
+		r = append(r, m)
+	}
+
+	d.b = uint32(len(r))
+	d.r = r
+	d.dead = nil
+
+	return bits
+}
+
+// Delete removes the key from the table.
+func (t Table) Delete(k uint32) {
+	delete(t, k)
+}
+
+// Clear removes key `k` from the posting list of the bit.
+func (p *Postings) Clear(b uint32, k uint32) {
+	if p.m != nil {
+		panic("postings are read-only")
+	}
+
+	if int(b) < len(p.bits) && p.bits[b] != nil {
+		p.bits[b].Clear(k)
+	}
+}
+
+// Delete removes the key from all of the posting lists.
+func (p *Postings) Delete(k uint32) {
+	if p.m != nil {
+		panic("postings are read-only")
+	}
+
+	for _, bm := range p.bits {
+		if bm != nil {
+			bm.Clear(k)
+		}
+	}
+
+	p.keys.Clear(k)
+}
+
+// Remove clears the bit of member `m` for key `k`. It returns false if
+// the key does not have the member. The key remains in the index even
+// if it has no members left. It returns ErrReadOnly if the index was
+// opened with OpenIndex.
+func (ix *Index) Remove(k uint32, m uint32) (bool, error) {
+	if err := ix.writable(); err != nil {
+		return false, err
+	}
+
+	b, ok := ix.Domain.f[m]
+
+	if !ok {
+		return false, nil
+	}
+
+	a := ix.Table.Get(k)
+
+	if a == nil || !a.Has(b) {
+		return false, nil
+	}
+
+	a.Clear(b)
+
+	if ix.Postings != nil {
+		ix.Postings.Clear(b, k)
+	}
+
+	return true, nil
+}
+
+// Delete removes the key and all of its members from the index. It
+// returns false if the key is not in the index. The label of the key is
+// dropped from the dictionary when the index is compacted. It returns
+// ErrReadOnly if the index was opened with OpenIndex.
+func (ix *Index) Delete(k uint32) (bool, error) {
+	if err := ix.writable(); err != nil {
+		return false, err
+	}
+
+	if ix.Table.Get(k) == nil {
+		return false, nil
+	}
+
+	ix.Table.Delete(k)
+
+	if ix.Postings != nil {
+		ix.Postings.Delete(k)
+	}
+
+	return true, nil
+}
+
+// Retire removes the member from the domain and clears its bit for every
+// key. It returns false if the member is not in the domain. The bit is
+// reclaimed when the index is compacted. It returns ErrReadOnly if the
+// index was opened with OpenIndex.
+func (ix *Index) Retire(m uint32) (bool, error) {
+	if err := ix.writable(); err != nil {
+		return false, err
+	}
+
+	b, ok := ix.Domain.Retire(m)
+
+	if !ok {
+		return false, nil
+	}
+
+	for _, a := range ix.Table {
+		a.Clear(b)
+	}
+
+	if ix.Postings != nil && int(b) < len(ix.Postings.bits) {
+		ix.Postings.bits[b] = nil
+	}
+
+	return true, nil
+}
+
+// Compact reclaims the bits of retired members, renumbering the bits of
+// the remaining members and rewriting the table and postings. If the keys
+// are labeled or 64-bit, the keys that were deleted are dropped from the
+// dictionary or key map and the remaining keys are renumbered. It
+// returns ErrReadOnly if the index was opened with OpenIndex.
+func (ix *Index) Compact() error {
+	if err := ix.writable(); err != nil {
+		return err
+	}
+
+	if ix.Domain.Retired() > 0 {
+		bits := ix.Domain.Compact()
+
+		for k, a := range ix.Table {
+			c := NewArray()
+
+			for _, b := range a.Bits() {
+				if n := bits[b]; n >= 0 {
+					c.Set(uint32(n))
+				}
+			}
+
+			ix.Table[k] = Pack(c)
+		}
+
+		if ix.Postings != nil {
+			ps := make([]*Bitmap, ix.Domain.Size())
+
+			for b, bm := range ix.Postings.bits {
+				if b < len(bits) && bits[b] >= 0 {
+					ps[bits[b]] = bm
+				}
+			}
+
+			ix.Postings.bits = ps
+		}
+	}
+
+	if ix.KeyDict != nil && ix.KeyDict.Size() != ix.Table.Size() {
+		d := NewDictionary()
+
+		ix.renumberKeys(func(k uint32) uint32 {
+			s, _ := ix.KeyDict.Label(k)
+			return d.Add(s)
+		})
+
+		ix.KeyDict = d
+	}
+
+	if ix.WideKeys != nil && ix.WideKeys.Size() != ix.Table.Size() {
+		km := NewKeyMap()
+
+		ix.renumberKeys(func(k uint32) uint32 {
+			k64, _ := ix.WideKeys.Key(k)
+			return km.Add(k64)
+		})
+
+		ix.WideKeys = km
+	}
+
+	return nil
+}
+
+// renumberKeys replaces each key in ascending order with the key
+// returned by f.
+func (ix *Index) renumberKeys(f func(k uint32) uint32) {
+	keys := ix.Table.Keys()
+	sort.Sort(Uint32Array(keys))
+
+	t := make(Table, len(keys))
+
+	for _, k := range keys {
+		t[f(k)] = ix.Table[k]
+	}
+
+	ix.Table = t
+
+	if ix.Postings != nil {
+		ix.Postings = BuildPostings(t)
+	}
+}
@@ -0,0 +1,184 @@
+package bitindex
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDomainCompact(t *testing.T) {
+	d := NewDomain([]uint32{10, 20, 30, 40})
+
+	if _, ok := d.Retire(20); !ok {
+		t.Fatal("expected 20 to be retired")
+	}
+
+	if _, ok := d.Retire(20); ok {
+		t.Error("expected 20 to be retired once")
+	}
+
+	if _, err := d.Mask(20); err == nil {
+		t.Error("expected mask error for a retired member")
+	}
+
+	bits := d.Compact()
+
+	if !sameInts(bits, []int{0, -1, 1, 2}) {
+		t.Errorf("expected [0 -1 1 2], got %v", bits)
+	}
+
+	if d.Size() != 3 || d.Retired() != 0 {
+		t.Errorf("expected 3 members and none retired, got %d and %d", d.Size(), d.Retired())
+	}
+
+	if b := d.Bit(40); b != 2 {
+		t.Errorf("expected bit 2, got %d", b)
+	}
+
+	if b := d.Add(50); b != 3 {
+		t.Errorf("expected bit 3, got %d", b)
+	}
+}
+
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestIndexEdit(t *testing.T) {
+	for _, postings := range []bool{false, true} {
+		ix := NewIndex(fruit)
+
+		for k, s := range pairs {
+			for _, b := range s {
+				ix.Add(k, b)
+			}
+		}
+
+		if postings {
+			ix.Postings = BuildPostings(ix.Table)
+		}
+
+		// Bob opts out, Sue no longer enjoys grapes and cherries are
+		// dropped altogether.
+		if ok, err := ix.Delete(people[0]); !ok || err != nil {
+			t.Fatalf("expected Bob to be deleted (%v)", err)
+		}
+
+		if ok, err := ix.Remove(people[1], fruit[3]); !ok || err != nil {
+			t.Fatalf("expected Sue's grapes to be removed (%v)", err)
+		}
+
+		if ok, _ := ix.Remove(people[1], fruit[3]); ok {
+			t.Error("expected Sue's grapes to be removed once")
+		}
+
+		if ok, err := ix.Retire(fruit[1]); !ok || err != nil {
+			t.Fatalf("expected cherries to be retired (%v)", err)
+		}
+
+		if err := DumpIndex(bytes.NewBuffer(nil), ix); err != ErrRetired {
+			t.Errorf("expected ErrRetired, got %v", err)
+		}
+
+		ix.Compact()
+
+		if ix.Domain.Size() != len(fruit)-1 {
+			t.Errorf("expected %d members, got %d", len(fruit)-1, ix.Domain.Size())
+		}
+
+		if ix.Get(people[0]) != nil {
+			t.Error("expected Bob to be absent")
+		}
+
+		if ix.Has(people[1], fruit[3]) {
+			t.Error("expected Sue not to have grapes")
+		}
+
+		if _, err := ix.Any(fruit[1]); err == nil {
+			t.Error("expected error for retired cherries")
+		}
+
+		buf := bytes.NewBuffer(nil)
+
+		if err := DumpIndex(buf, ix); err != nil {
+			t.Fatal(err)
+		}
+
+		ix2, err := LoadIndex(buf)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, m := range ix.Domain.Members() {
+			exp, err := ix.Any(m)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ix2.Any(m)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !sameKeys(exp, got) {
+				t.Errorf("postings %v: %d: expected %v, got %v", postings, m, exp, got)
+			}
+
+			for _, k := range got {
+				if k == people[0] {
+					t.Errorf("postings %v: expected Bob to be absent", postings)
+				}
+			}
+		}
+	}
+}
+
+func TestCompactKeyDict(t *testing.T) {
+	ix := newLabeledIndex(t)
+
+	ks, err := ix.LookupKeys("A100")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix.Delete(ks[0])
+	ix.Compact()
+
+	// The label of the deleted key is dropped.
+	if _, err := ix.LookupKeys("A100"); err == nil {
+		t.Error("expected A100 to be absent")
+	}
+
+	if ix.KeyDict.Size() != ix.Size() {
+		t.Errorf("expected %d labels, got %d", ix.Size(), ix.KeyDict.Size())
+	}
+
+	e, err := ParseExpr("any(E11.9)")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ix.QueryExpr(e)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ix.KeyLabels(r.Items()); len(got) != 1 || got[0] != "C300" {
+		t.Errorf("expected [C300], got %v", got)
+	}
+}
@@ -0,0 +1,248 @@
+package bitindex
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ExportLayout is the layout of the rows written by an Exporter.
+type ExportLayout int
+
+const (
+	// ExportPairs writes a row for each key and member.
+	ExportPairs ExportLayout = iota
+
+	// ExportList writes a row for each key with the list of its members.
+	ExportList
+
+	// ExportMatrix writes a row for each key with a 0/1 column for each
+	// member of the domain.
+	ExportMatrix
+)
+
+// Exporter writes the pairs of an index as tabular data that can be
+// built into an index again. Keys are written in ascending order and
+// members in bit order. Labels and 64-bit keys are written in place of
+// their ids.
+type Exporter struct {
+	Layout ExportLayout
+
+	// Names of the key and member columns or fields.
+	KeyName    string
+	MemberName string
+
+	// Separator of the members of a list in CSV. A member label must not
+	// contain it.
+	Separator string
+
+	// Delimiter of the CSV columns.
+	Comma rune
+
+	// If true, a header row is written to CSV. It is always written for
+	// a matrix since it names the members.
+	Header bool
+}
+
+// NewExporter initializes an exporter of pairs with a CSV header.
+func NewExporter() *Exporter {
+	return &Exporter{
+		KeyName:    "key",
+		MemberName: "member",
+		Separator:  ";",
+		Comma:      ',',
+		Header:     true,
+	}
+}
+
+// eachRow calls f with the key and the members of each row of the index.
+func eachRow(ix *Index, f func(k string, ms []string) error) error {
+	for _, k := range ix.Keys().Bits() {
+		var ms []uint32
+
+		a := ix.Get(k)
+
+		if err := ix.Err(); err != nil {
+			return err
+		}
+
+		for _, b := range a.Bits() {
+			ms = append(ms, ix.Domain.Member(b))
+		}
+
+		if err := f(ix.KeyLabels([]uint32{k})[0], ix.MemberLabels(ms)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matrixRow returns the 0/1 cells of the members in domain order.
+func matrixRow(ix *Index, ms []string, bit map[string]int) []string {
+	row := make([]string, ix.Domain.Size())
+
+	for i := range row {
+		row[i] = "0"
+	}
+
+	for _, m := range ms {
+		row[bit[m]] = "1"
+	}
+
+	return row
+}
+
+// domainLabels returns the labels of the members of the domain in bit
+// order and the bit of each label.
+func domainLabels(ix *Index) ([]string, map[string]int) {
+	labels := ix.MemberLabels(ix.Domain.Members())
+	bits := make(map[string]int, len(labels))
+
+	for b, s := range labels {
+		bits[s] = b
+	}
+
+	return labels, bits
+}
+
+// CSV writes the rows of the index as CSV.
+func (e *Exporter) CSV(w io.Writer, ix *Index) error {
+	if e.Layout == ExportList && e.Separator == "" {
+		return fmt.Errorf("A separator is required for a list")
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Comma = e.Comma
+
+	var (
+		labels []string
+		bits   map[string]int
+	)
+
+	if e.Layout == ExportMatrix {
+		labels, bits = domainLabels(ix)
+
+		if err := cw.Write(append([]string{e.KeyName}, labels...)); err != nil {
+			return err
+		}
+	} else if e.Header {
+		if err := cw.Write([]string{e.KeyName, e.MemberName}); err != nil {
+			return err
+		}
+	}
+
+	err := eachRow(ix, func(k string, ms []string) error {
+		switch e.Layout {
+		case ExportList:
+			// The list could not be split into the same members.
+			for _, m := range ms {
+				if strings.Contains(m, e.Separator) {
+					return fmt.Errorf("Member %q contains the separator %q", m, e.Separator)
+				}
+			}
+
+			return cw.Write([]string{k, strings.Join(ms, e.Separator)})
+
+		case ExportMatrix:
+			return cw.Write(append([]string{k}, matrixRow(ix, ms, bits)...))
+		}
+
+		for _, m := range ms {
+			if err := cw.Write([]string{k, m}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// jsonValue returns the JSON of the label, which is a number if there is
+// no dictionary.
+func jsonValue(s string, labeled bool) json.RawMessage {
+	if !labeled {
+		return json.RawMessage(s)
+	}
+
+	b, _ := json.Marshal(s)
+	return json.RawMessage(b)
+}
+
+// JSON writes the rows of the index as JSON Lines. The members of a list
+// are written as an array and the members of a matrix as fields.
+func (e *Exporter) JSON(w io.Writer, ix *Index) error {
+	bw := bufio.NewWriter(w)
+
+	keyed := ix.KeyDict != nil
+	labeled := ix.MemberDict != nil
+
+	kn := jsonValue(e.KeyName, true)
+	mn := jsonValue(e.MemberName, true)
+
+	var (
+		labels []string
+		bits   map[string]int
+	)
+
+	if e.Layout == ExportMatrix {
+		labels, bits = domainLabels(ix)
+	}
+
+	err := eachRow(ix, func(k string, ms []string) error {
+		kv := jsonValue(k, keyed)
+
+		switch e.Layout {
+		case ExportList:
+			vs := make([]json.RawMessage, len(ms))
+
+			for i, m := range ms {
+				vs[i] = jsonValue(m, labeled)
+			}
+
+			b, _ := json.Marshal(vs)
+
+			_, err := fmt.Fprintf(bw, "{%s:%s,%s:%s}\n", kn, kv, mn, b)
+			return err
+
+		case ExportMatrix:
+			if _, err := fmt.Fprintf(bw, "{%s:%s", kn, kv); err != nil {
+				return err
+			}
+
+			for i, v := range matrixRow(ix, ms, bits) {
+				if _, err := fmt.Fprintf(bw, ",%s:%s", jsonValue(labels[i], true), v); err != nil {
+					return err
+				}
+			}
+
+			_, err := bw.WriteString("}\n")
+			return err
+		}
+
+		for _, m := range ms {
+			if _, err := fmt.Fprintf(bw, "{%s:%s,%s:%s}\n", kn, kv, mn, jsonValue(m, labeled)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,137 @@
+package bitindex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// canonicalBytes returns the encoding of the canonical index.
+func canonicalBytes(t *testing.T, ix *Index) []byte {
+	ix.Canonicalize(true)
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestExportCSV(t *testing.T) {
+	exp := canonicalBytes(t, newLabeledIndex(t))
+
+	for _, layout := range []ExportLayout{ExportPairs, ExportList, ExportMatrix} {
+		e := NewExporter()
+		e.Layout = layout
+		e.Comma = '\t'
+
+		buf := bytes.NewBuffer(nil)
+
+		if err := e.CSV(buf, newLabeledIndex(t)); err != nil {
+			t.Fatal(err)
+		}
+
+		p := NewCSVIndexer(bytes.NewReader(buf.Bytes()))
+		p.Comma = '\t'
+		p.Header = true
+		p.ParseLabels = func(row []string) (string, string, error) {
+			return row[0], row[1], nil
+		}
+
+		switch layout {
+		case ExportList:
+			p.Expand = ListExpander(0, 1, ";")
+
+		case ExportMatrix:
+			p.Expand = p.MatrixExpander(0)
+		}
+
+		ix, err := p.Index()
+
+		if err != nil {
+			t.Fatalf("layout %d: %s\n%s", layout, err, buf)
+		}
+
+		if !bytes.Equal(canonicalBytes(t, ix), exp) {
+			t.Errorf("layout %d: expected the same index\n%s", layout, buf)
+		}
+	}
+
+	// A member containing the separator could not be read back.
+	e := NewExporter()
+	e.Layout = ExportList
+	e.Separator = " "
+
+	if err := e.CSV(ioutil.Discard, newLabeledIndex(t)); err == nil || !strings.Contains(err.Error(), "I10 X") {
+		t.Errorf("expected separator error, got %v", err)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	newIndex := func() *Index {
+		ix := NewIndex(nil)
+
+		for k, ms := range pairs {
+			for _, m := range ms {
+				ix.Add(k, m)
+			}
+		}
+
+		return ix
+	}
+
+	exp := canonicalBytes(t, newIndex())
+
+	for _, layout := range []ExportLayout{ExportPairs, ExportList} {
+		e := NewExporter()
+		e.Layout = layout
+		e.KeyName = "person"
+		e.MemberName = "fruit"
+
+		buf := bytes.NewBuffer(nil)
+
+		if err := e.JSON(buf, newIndex()); err != nil {
+			t.Fatal(err)
+		}
+
+		p := NewJSONIndexer(bytes.NewReader(buf.Bytes()))
+		p.KeyField = "person"
+		p.MemberField = "fruit"
+
+		ix, err := p.Index()
+
+		if err != nil {
+			t.Fatalf("layout %d: %s\n%s", layout, err, buf)
+		}
+
+		if !bytes.Equal(canonicalBytes(t, ix), exp) {
+			t.Errorf("layout %d: expected the same index\n%s", layout, buf)
+		}
+	}
+
+	e := NewExporter()
+	e.Layout = ExportMatrix
+
+	// Members are written in bit order.
+	ix := newIndex()
+	ix.Canonicalize(true)
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := e.JSON(buf, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	exps := []string{
+		`{"key":100,"1":1,"2":0,"3":1,"4":0,"9":0}`,
+		`{"key":101,"1":0,"2":0,"3":0,"4":1,"9":1}`,
+		`{"key":102,"1":0,"2":1,"3":1,"4":1,"9":0}`,
+	}
+
+	if got := strings.TrimSpace(buf.String()); got != strings.Join(exps, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(exps, "\n"), got)
+	}
+}
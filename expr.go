@@ -0,0 +1,796 @@
+package bitindex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a node of a boolean query expression that evaluates to
+// a set of keys.
+type Expr interface {
+	// Eval evaluates the expression against the index.
+	Eval(ix *Index) (*Bitmap, error)
+
+	String() string
+}
+
+// OpExpr applies one of the operations (any, all, nany, nall, atleast,
+// atmost) to a set of members.
+type OpExpr struct {
+	Op      string
+	Members []uint32
+
+	// Labels of the members as written in the expression. If set, they
+	// are looked up in the index instead of using Members.
+	Labels []string
+
+	// Members that are expanded to the members under them in the
+	// hierarchy of the index, in addition to Members or Labels.
+	Descendants []uint32
+
+	// Threshold of the atleast and atmost operations.
+	N int
+}
+
+// members returns the members of the operation, looking up the labels
+// if they are set.
+func (e *OpExpr) members(ix *Index) ([]uint32, error) {
+	if e.Labels == nil {
+		return e.Members, nil
+	}
+
+	ms, err := ix.LookupMembers(e.Labels...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Operation failed (%s): %s", e.Op, err)
+	}
+
+	return ms, nil
+}
+
+// mask builds the bitmask of the members and of the Descendants, which
+// are expanded by the domain before it is built.
+func (e *OpExpr) mask(ix *Index) ([]uint32, error) {
+	ms, err := e.members(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bs, err := ix.Domain.Mask(ms...)
+
+	if err == nil && e.Descendants != nil {
+		var ds []uint32
+
+		if ds, err = ix.Domain.MaskDescendants(ix.Hierarchy, e.Descendants...); err == nil {
+			bs = unionBits(bs, ds)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Operation failed (%s): %s", e.Op, err)
+	}
+
+	return bs, nil
+}
+
+// unionBits appends the bits of b that are not in a.
+func unionBits(a, b []uint32) []uint32 {
+	seen := make(map[uint32]struct{}, len(a))
+
+	for _, x := range a {
+		seen[x] = struct{}{}
+	}
+
+	for _, x := range b {
+		if _, ok := seen[x]; !ok {
+			seen[x] = struct{}{}
+			a = append(a, x)
+		}
+	}
+
+	return a
+}
+
+// Eval implements Expr.
+func (e *OpExpr) Eval(ix *Index) (*Bitmap, error) {
+	// Without postings, the table is scanned with the test of the
+	// operation.
+	if ix.Postings == nil {
+		test, err := e.test(ix)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return evalTest(ix, test)
+	}
+
+	bs, err := e.mask(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys *Bitmap
+
+	switch e.Op {
+	case "any":
+		keys = ix.Postings.Any(bs...)
+	case "all":
+		keys = ix.Postings.All(bs...)
+	case "nany":
+		keys = ix.Postings.NotAny(bs...)
+	case "nall":
+		keys = ix.Postings.NotAll(bs...)
+	case "atleast":
+		keys = ix.Postings.AtLeast(e.N, bs...)
+	case "atmost":
+		keys = ix.Postings.AtMost(e.N, bs...)
+	default:
+		return nil, fmt.Errorf("Unknown operation: %s", e.Op)
+	}
+
+	if err := ix.Err(); err != nil {
+		return nil, fmt.Errorf("Operation failed (%s): %s", e.Op, err)
+	}
+
+	return keys, nil
+}
+
+// test returns whether an array matches the operation.
+func (e *OpExpr) test(ix *Index) (func(Array) bool, error) {
+	bs, err := e.mask(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m, n := NewMask(bs...), e.N
+
+	switch e.Op {
+	case "any":
+		return func(a Array) bool { return a.AnyMask(m) }, nil
+	case "all":
+		return func(a Array) bool { return a.AllMask(m) }, nil
+	case "nany":
+		return func(a Array) bool { return !a.AnyMask(m) }, nil
+	case "nall":
+		return func(a Array) bool { return !a.AllMask(m) }, nil
+	case "atleast":
+		return func(a Array) bool { return a.CountMask(m) >= n }, nil
+	case "atmost":
+		return func(a Array) bool { return a.CountMask(m) <= n }, nil
+	}
+
+	return nil, fmt.Errorf("Unknown operation: %s", e.Op)
+}
+
+// exprTest returns whether an array matches the expression so that it
+// can be evaluated in one pass over the table rather than an operation
+// at a time. It returns nil if the expression has a node of another
+// type, which must be evaluated with Eval.
+func exprTest(ix *Index, e Expr) (func(Array) bool, error) {
+	switch e := e.(type) {
+	case *OpExpr:
+		return e.test(ix)
+
+	case *NotExpr:
+		x, err := exprTest(ix, e.X)
+
+		if x == nil || err != nil {
+			return nil, err
+		}
+
+		return func(a Array) bool { return !x(a) }, nil
+
+	case *AndExpr:
+		x, y, err := exprTests(ix, e.X, e.Y)
+
+		if x == nil || err != nil {
+			return nil, err
+		}
+
+		return func(a Array) bool { return x(a) && y(a) }, nil
+
+	case *OrExpr:
+		x, y, err := exprTests(ix, e.X, e.Y)
+
+		if x == nil || err != nil {
+			return nil, err
+		}
+
+		return func(a Array) bool { return x(a) || y(a) }, nil
+	}
+
+	return nil, nil
+}
+
+// exprTests returns the tests of both expressions, or nil if either
+// has no test.
+func exprTests(ix *Index, x, y Expr) (func(Array) bool, func(Array) bool, error) {
+	tx, err := exprTest(ix, x)
+
+	if tx == nil || err != nil {
+		return nil, nil, err
+	}
+
+	ty, err := exprTest(ix, y)
+
+	if ty == nil || err != nil {
+		return nil, nil, err
+	}
+
+	return tx, ty, nil
+}
+
+// evalTest returns the keys whose arrays pass the test.
+func evalTest(ix *Index, test func(Array) bool) (*Bitmap, error) {
+	keys := NewBitmap()
+
+	ix.Each(func(k uint32, a Array) {
+		if test(a) {
+			keys.Set(k)
+		}
+	})
+
+	if err := ix.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (e *OpExpr) String() string {
+	var toks []string
+
+	if e.Op == "atleast" || e.Op == "atmost" {
+		toks = append(toks, strconv.Itoa(e.N))
+	}
+
+	if e.Labels != nil {
+		for _, l := range e.Labels {
+			if d, ok := descendantsLabel(l); ok {
+				toks = append(toks, "descendants("+quoteLabel(d)+")")
+			} else {
+				toks = append(toks, quoteLabel(l))
+			}
+		}
+	} else {
+		for _, m := range e.Members {
+			toks = append(toks, strconv.FormatUint(uint64(m), 10))
+		}
+	}
+
+	for _, m := range e.Descendants {
+		toks = append(toks, "descendants("+strconv.FormatUint(uint64(m), 10)+")")
+	}
+
+	return fmt.Sprintf("%s(%s)", e.Op, strings.Join(toks, ","))
+}
+
+// quoteLabel quotes the label unless it can be written as a word.
+func quoteLabel(s string) string {
+	if isWord(s) {
+		return s
+	}
+
+	return strconv.Quote(s)
+}
+
+// NotExpr is the complement of an expression.
+type NotExpr struct {
+	X Expr
+}
+
+// Eval implements Expr.
+func (e *NotExpr) Eval(ix *Index) (*Bitmap, error) {
+	x, err := e.X.Eval(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ix.Keys().AndNot(x), nil
+}
+
+func (e *NotExpr) String() string {
+	return fmt.Sprintf("NOT %s", e.X)
+}
+
+// AndExpr is the intersection of two expressions.
+type AndExpr struct {
+	X, Y Expr
+}
+
+// Eval implements Expr.
+func (e *AndExpr) Eval(ix *Index) (*Bitmap, error) {
+	x, err := e.X.Eval(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := e.Y.Eval(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return x.And(y), nil
+}
+
+func (e *AndExpr) String() string {
+	return fmt.Sprintf("(%s AND %s)", e.X, e.Y)
+}
+
+// OrExpr is the union of two expressions.
+type OrExpr struct {
+	X, Y Expr
+}
+
+// Eval implements Expr.
+func (e *OrExpr) Eval(ix *Index) (*Bitmap, error) {
+	x, err := e.X.Eval(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := e.Y.Eval(ix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return x.Or(y), nil
+}
+
+func (e *OrExpr) String() string {
+	return fmt.Sprintf("(%s OR %s)", e.X, e.Y)
+}
+
+// Token kinds.
+const (
+	tokEOF = iota
+	tokIdent
+	tokNumber
+	tokLParen
+	tokRParen
+	tokComma
+	tokString
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func lexExpr(s string) ([]token, error) {
+	var toks []token
+
+	rs := []rune(s)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+
+		case r == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+
+		case r == ',':
+			toks = append(toks, token{tokComma, ",", i})
+			i++
+
+		case r == '"':
+			j := i + 1
+
+			for j < len(rs) && rs[j] != '"' {
+				if rs[j] == '\\' {
+					j++
+				}
+
+				j++
+			}
+
+			if j >= len(rs) {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+
+			s, err := strconv.Unquote(string(rs[i : j+1]))
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid string at %d: %s", i, err)
+			}
+
+			toks = append(toks, token{tokString, s, i})
+			i = j + 1
+
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i
+			digits := true
+
+			// Words may contain punctuation common in codes, e.g. E11.9.
+			for j < len(rs) && isWordRune(rs[j]) {
+				digits = digits && unicode.IsDigit(rs[j])
+				j++
+			}
+
+			if digits {
+				toks = append(toks, token{tokNumber, string(rs[i:j]), i})
+			} else {
+				toks = append(toks, token{tokIdent, string(rs[i:j]), i})
+			}
+
+			i = j
+
+		default:
+			return nil, fmt.Errorf("Unexpected character %q at %d", r, i)
+		}
+	}
+
+	toks = append(toks, token{tokEOF, "", len(rs)})
+
+	return toks, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_'
+}
+
+// isWord returns true if the label can be written without quotes.
+func isWord(s string) bool {
+	digits := true
+
+	for i, r := range s {
+		if !isWordRune(r) || i == 0 && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+
+		digits = digits && unicode.IsDigit(r)
+	}
+
+	// Numbers must be valid members.
+	if digits {
+		_, err := strconv.ParseUint(s, 10, 32)
+		return err == nil
+	}
+
+	return s != ""
+}
+
+// exprParser is a recursive descent parser for the grammar:
+//
+//	expr      = term { "OR" term }
+//	term      = factor { "AND" factor }
+//	factor    = "NOT" factor | "(" expr ")" | op "(" members ")"
+//	          | threshold "(" n "," members ")"
+//	members   = member { "," member }
+//	member    = label | "descendants" "(" label ")"
+//	label     = number | word | string
+//	op        = "any" | "all" | "nany" | "nall"
+//	threshold = "atleast" | "atmost"
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *exprParser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+
+	if t.kind != tokEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *exprParser) expect(kind int, text string) error {
+	if t := p.next(); t.kind != kind {
+		return fmt.Errorf("Expected %s at %d", text, t.pos)
+	}
+
+	return nil
+}
+
+// keyword returns true if the next token is the keyword.
+func (p *exprParser) keyword(k string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, k)
+}
+
+func (p *exprParser) expr() (Expr, error) {
+	x, err := p.term()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		p.next()
+
+		y, err := p.term()
+
+		if err != nil {
+			return nil, err
+		}
+
+		x = &OrExpr{x, y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) term() (Expr, error) {
+	x, err := p.factor()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		p.next()
+
+		y, err := p.factor()
+
+		if err != nil {
+			return nil, err
+		}
+
+		x = &AndExpr{x, y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) factor() (Expr, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokLParen:
+		x, err := p.expr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+
+		return x, nil
+
+	case tokIdent:
+		op := strings.ToLower(t.text)
+
+		switch op {
+		case "not":
+			x, err := p.factor()
+
+			if err != nil {
+				return nil, err
+			}
+
+			return &NotExpr{x}, nil
+
+		case "any", "all", "nany", "nall":
+			ms, ls, err := p.members()
+
+			if err != nil {
+				return nil, err
+			}
+
+			return &OpExpr{Op: op, Members: ms, Labels: ls}, nil
+
+		case "atleast", "atmost":
+			pos := p.peek().pos
+
+			ms, ls, err := p.members()
+
+			if err != nil {
+				return nil, err
+			}
+
+			// The first number is the threshold.
+			if len(ls) < 2 {
+				return nil, fmt.Errorf("Expected threshold and members for %s at %d", op, t.pos)
+			}
+
+			n, err := strconv.Atoi(ls[0])
+
+			if err != nil {
+				return nil, fmt.Errorf("Expected threshold for %s at %d", op, pos)
+			}
+
+			if ms != nil {
+				ms = ms[1:]
+			}
+
+			return &OpExpr{Op: op, Members: ms, Labels: ls[1:], N: n}, nil
+		}
+
+		return nil, fmt.Errorf("Unknown operation %q at %d", t.text, t.pos)
+
+	case tokEOF:
+		return nil, fmt.Errorf("Unexpected end of expression")
+	}
+
+	return nil, fmt.Errorf("Unexpected %q at %d", t.text, t.pos)
+}
+
+// members parses a list of members. The labels are always returned. The
+// members are only returned if every one is a number, in which case the
+// labels are also valid members of an index without dictionaries.
+func (p *exprParser) members() ([]uint32, []string, error) {
+	if err := p.expect(tokLParen, "("); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		ms      []uint32
+		ls      []string
+		numbers = true
+	)
+
+	for {
+		t := p.next()
+
+		switch t.kind {
+		case tokNumber:
+			n, err := strconv.ParseUint(t.text, 10, 32)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid member at %d: %s", t.pos, err)
+			}
+
+			ms = append(ms, uint32(n))
+
+		case tokIdent, tokString:
+			numbers = false
+
+			// The member and the members under it in the hierarchy.
+			if t.kind == tokIdent && strings.EqualFold(t.text, "descendants") && p.peek().kind == tokLParen {
+				p.next()
+
+				if t = p.next(); t.kind != tokNumber && t.kind != tokIdent && t.kind != tokString {
+					return nil, nil, fmt.Errorf("Expected member at %d", t.pos)
+				}
+
+				if err := p.expect(tokRParen, ")"); err != nil {
+					return nil, nil, err
+				}
+
+				t.text = "descendants(" + t.text + ")"
+			}
+
+		default:
+			return nil, nil, fmt.Errorf("Expected member at %d", t.pos)
+		}
+
+		ls = append(ls, t.text)
+
+		if t = p.next(); t.kind == tokRParen {
+			break
+		} else if t.kind != tokComma {
+			return nil, nil, fmt.Errorf("Expected , or ) at %d", t.pos)
+		}
+	}
+
+	if !numbers {
+		ms = nil
+	}
+
+	return ms, ls, nil
+}
+
+// ParseExpr parses a boolean query expression such as:
+//
+//	(any(1,2) AND NOT all(3,4)) OR any(9)
+//
+// Keywords are case-insensitive and AND binds tighter than OR.
+func ParseExpr(s string) (Expr, error) {
+	toks, err := lexExpr(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{toks: toks}
+
+	x, err := p.expr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("Unexpected %q at %d", t.text, t.pos)
+	}
+
+	return x, nil
+}
+
+// QueryExpr evaluates an expression against the index. Without
+// postings, the table is scanned once for the whole expression.
+func (ix *Index) QueryExpr(e Expr) (*Result, error) {
+	keys, err := ix.evalExpr(e)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		keys: keys,
+		idx:  ix,
+	}, nil
+}
+
+// CountExpr returns the number of keys matching the expression. Without
+// postings, the table is scanned once for the whole expression and the
+// matching keys are never collected.
+func (ix *Index) CountExpr(e Expr) (int, error) {
+	if ix.Postings == nil {
+		test, err := exprTest(ix, e)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if test != nil {
+			var c int
+
+			ix.Each(func(_ uint32, a Array) {
+				if test(a) {
+					c++
+				}
+			})
+
+			if err = ix.Err(); err != nil {
+				return 0, err
+			}
+
+			return c, nil
+		}
+	}
+
+	keys, err := e.Eval(ix)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return keys.Cardinality(), nil
+}
+
+// evalExpr evaluates the expression in one pass over the table if the
+// index has no postings.
+func (ix *Index) evalExpr(e Expr) (*Bitmap, error) {
+	if ix.Postings == nil {
+		test, err := exprTest(ix, e)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if test != nil {
+			return evalTest(ix, test)
+		}
+	}
+
+	return e.Eval(ix)
+}
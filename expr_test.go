@@ -0,0 +1,117 @@
+package bitindex
+
+import "testing"
+
+func TestParseExpr(t *testing.T) {
+	tests := map[string]string{
+		"any(1,2)":                                     "any(1,2)",
+		"any(1, 2) and all(3)":                         "(any(1,2) AND all(3))",
+		"any(1) OR any(2) AND any(3)":                  "(any(1) OR (any(2) AND any(3)))",
+		"(any(1,2) AND NOT all(3,4)) OR any(9)":        "((any(1,2) AND NOT all(3,4)) OR any(9))",
+		"not not nany(1)":                              "NOT NOT nany(1)",
+		"nall(4,2) or (any(1) and (any(2) or any(3)))": "(nall(4,2) OR (any(1) AND (any(2) OR any(3))))",
+		`any(E11.9, "I10 X", "and")`:                   `any(E11.9,"I10 X",and)`,
+		"atleast(2, J45, E11.9)":                       "atleast(2,J45,E11.9)",
+		`any(Descendants(250), descendants("I10 X"))`:  `any(descendants(250),descendants("I10 X"))`,
+	}
+
+	for in, out := range tests {
+		e, err := ParseExpr(in)
+
+		if err != nil {
+			t.Errorf("%s: %s", in, err)
+			continue
+		}
+
+		if e.String() != out {
+			t.Errorf("%s: expected %s, got %s", in, out, e)
+		}
+	}
+
+	bad := []string{
+		"",
+		"any",
+		"any()",
+		"any(1,)",
+		"any(1",
+		"some(1)",
+		"any(1) and",
+		"any(1) any(2)",
+		"(any(1)",
+		"any(-1)",
+		"any(99999999999)",
+		"atleast(2)",
+		"atleast(x, 1)",
+		`any("E11`,
+		"any(.5)",
+	}
+
+	for _, in := range bad {
+		if _, err := ParseExpr(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestQueryExpr(t *testing.T) {
+	ix := NewIndex(fruit)
+
+	for k, s := range pairs {
+		for _, b := range s {
+			ix.Add(k, b)
+		}
+	}
+
+	px := newPostingsIndex()
+
+	tests := map[string][]uint32{
+		"any(1,2)":                            {100, 102},
+		"all(1,3)":                            {100},
+		"NOT any(3,1)":                        {101},
+		"nall(4,2)":                           {100, 101},
+		"any(1) OR all(4,9)":                  {100, 101},
+		"(any(3) AND NOT all(2,4)) OR any(9)": {100, 101},
+		"any(4) AND (any(9) OR any(2)) AND nany(1)": {101, 102},
+		"atleast(2, 1,2,3,4)":                       {100, 102},
+		"atmost(1, 3,4) AND NOT any(1)":             {101},
+	}
+
+	for in, keys := range tests {
+		e, err := ParseExpr(in)
+
+		if err != nil {
+			t.Fatalf("%s: %s", in, err)
+		}
+
+		for _, x := range []*Index{ix, px} {
+			r, err := x.QueryExpr(e)
+
+			if err != nil {
+				t.Fatalf("%s: %s", in, err)
+			}
+
+			if !sameKeys(r.Items(), keys) {
+				t.Errorf("%s: expected %v, got %v", in, keys, r.Items())
+			}
+
+			if r.Len()+len(r.Complement()) != 3 {
+				t.Errorf("%s: expected complement of %v", in, r.Items())
+			}
+
+			if n, err := x.CountExpr(e); err != nil || n != len(keys) {
+				t.Errorf("%s: expected count %d, got %d (%v)", in, len(keys), n, err)
+			}
+
+			// Node at a time.
+			if b, err := e.Eval(x); err != nil || !sameKeys(b.Bits(), keys) {
+				t.Errorf("%s: expected %v from Eval, got %v (%v)", in, keys, b, err)
+			}
+		}
+	}
+
+	e, _ := ParseExpr("any(1) OR any(42)")
+
+	if _, err := ix.QueryExpr(e); err == nil {
+		t.Errorf("expected error for unknown member")
+	}
+}
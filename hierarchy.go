@@ -0,0 +1,313 @@
+package bitindex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Hierarchy is a parent/child hierarchy over the members of the domain,
+// such as the codes of a clinical code system. It is used to expand a
+// member to all of the members under it.
+type Hierarchy struct {
+	// Parent -> Children
+	children map[uint32][]uint32
+
+	// Number of edges.
+	n int
+
+	// Set if the index was opened with OpenIndex. Children are read
+	// from the mapping on demand.
+	m *hierarchyLayout
+}
+
+// AddEdge adds an edge from the parent to the child.
+func (h *Hierarchy) AddEdge(parent, child uint32) {
+	if h.m != nil {
+		panic("hierarchy is read-only")
+	}
+
+	// Do not add duplicates.
+	for _, c := range h.children[parent] {
+		if c == child {
+			return
+		}
+	}
+
+	h.children[parent] = append(h.children[parent], child)
+	h.n++
+}
+
+// Children returns the direct children of the member.
+func (h *Hierarchy) Children(m uint32) []uint32 {
+	if h.m != nil {
+		return h.m.children(m)
+	}
+
+	return h.children[m]
+}
+
+// Size returns the number of edges in the hierarchy.
+func (h *Hierarchy) Size() int {
+	if h.m != nil {
+		return h.m.size()
+	}
+
+	return h.n
+}
+
+// Descendants returns the members and all of the members under them.
+// Each member is returned once, even if the hierarchy has cycles.
+func (h *Hierarchy) Descendants(ms ...uint32) []uint32 {
+	seen := make(map[uint32]struct{}, len(ms))
+
+	var out []uint32
+
+	for len(ms) > 0 {
+		m := ms[len(ms)-1]
+		ms = ms[:len(ms)-1]
+
+		if _, ok := seen[m]; ok {
+			continue
+		}
+
+		seen[m] = struct{}{}
+		out = append(out, m)
+
+		ms = append(ms, h.Children(m)...)
+	}
+
+	return out
+}
+
+// each calls f for each edge.
+func (h *Hierarchy) each(f func(parent, child uint32)) {
+	if h.m != nil {
+		for i, n := 0, h.m.size(); i < n; i++ {
+			f(h.m.edge(i))
+		}
+
+		return
+	}
+
+	for p, cs := range h.children {
+		for _, c := range cs {
+			f(p, c)
+		}
+	}
+}
+
+// edges returns the edges ordered by parent and child.
+func (h *Hierarchy) edges() [][2]uint32 {
+	es := make([][2]uint32, 0, h.Size())
+
+	h.each(func(p, c uint32) {
+		es = append(es, [2]uint32{p, c})
+	})
+
+	sort.Slice(es, func(i, j int) bool {
+		if es[i][0] != es[j][0] {
+			return es[i][0] < es[j][0]
+		}
+
+		return es[i][1] < es[j][1]
+	})
+
+	return es
+}
+
+// NewHierarchy initializes an empty hierarchy.
+func NewHierarchy() *Hierarchy {
+	return &Hierarchy{
+		children: make(map[uint32][]uint32),
+	}
+}
+
+// hierarchyLayout locates a hierarchy in an index held in memory.
+type hierarchyLayout struct {
+	// Edges ordered by parent and child.
+	edges []byte
+}
+
+func (l *hierarchyLayout) size() int {
+	return len(l.edges) / 8
+}
+
+func (l *hierarchyLayout) edge(i int) (uint32, uint32) {
+	b := l.edges[i*8:]
+	return binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+}
+
+func (l *hierarchyLayout) children(m uint32) []uint32 {
+	n := l.size()
+
+	i := sort.Search(n, func(i int) bool {
+		p, _ := l.edge(i)
+		return p >= m
+	})
+
+	var cs []uint32
+
+	for ; i < n; i++ {
+		p, c := l.edge(i)
+
+		if p != m {
+			break
+		}
+
+		cs = append(cs, c)
+	}
+
+	return cs
+}
+
+// load decodes the edges into a hierarchy held in memory.
+func (l *hierarchyLayout) load() *Hierarchy {
+	h := NewHierarchy()
+
+	for i, n := 0, l.size(); i < n; i++ {
+		h.AddEdge(l.edge(i))
+	}
+
+	return h
+}
+
+// decodeHierarchy locates the edges of a hierarchy section.
+func decodeHierarchy(b []byte) (*hierarchyLayout, error) {
+	if len(b) < 4 {
+		return nil, ErrCorrupt
+	}
+
+	n := uint64(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+
+	if uint64(len(b)) != n*8 {
+		return nil, ErrCorrupt
+	}
+
+	l := &hierarchyLayout{edges: b}
+
+	// Edges must be ordered by parent for lookups.
+	for i := 1; i < int(n); i++ {
+		p0, _ := l.edge(i - 1)
+		p1, _ := l.edge(i)
+
+		if p0 > p1 {
+			return nil, ErrCorrupt
+		}
+	}
+
+	return l, nil
+}
+
+// hierarchySize returns the number of bytes of the encoded hierarchy.
+func hierarchySize(h *Hierarchy) uint64 {
+	return 4 + uint64(h.Size())*8
+}
+
+func dumpHierarchy(w io.Writer, h *Hierarchy, b []byte) error {
+	if err := writeUint32(w, b, uint32(h.Size())); err != nil {
+		return fmt.Errorf("Error writing hierarchy length: %s", err)
+	}
+
+	// Edges of a mapped hierarchy are already ordered.
+	if h.m != nil {
+		if _, err := w.Write(h.m.edges); err != nil {
+			return fmt.Errorf("Error writing hierarchy: %s", err)
+		}
+
+		return nil
+	}
+
+	for _, e := range h.edges() {
+		if err := writeUint32(w, b, e[0]); err != nil {
+			return fmt.Errorf("Error writing hierarchy parent: %s", err)
+		}
+
+		if err := writeUint32(w, b, e[1]); err != nil {
+			return fmt.Errorf("Error writing hierarchy child: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// MaskDescendants builds a bitmask for the members and the members under
+// them in the hierarchy, so the expansion happens before the mask is
+// built. Members under them that are not in the domain are skipped, since
+// no key has them, but a member that is neither in the domain nor has a
+// member of the domain under it is an error, like an unknown member is
+// for Mask.
+func (d *Domain) MaskDescendants(h *Hierarchy, ms ...uint32) ([]uint32, error) {
+	if h == nil {
+		return nil, fmt.Errorf("Index has no hierarchy")
+	}
+
+	seen := make(map[uint32]struct{})
+
+	var a []uint32
+
+	for _, m := range ms {
+		found := false
+
+		for _, c := range h.Descendants(m) {
+			b, ok := d.f[c]
+
+			if !ok {
+				continue
+			}
+
+			found = true
+
+			if _, ok = seen[b]; !ok {
+				seen[b] = struct{}{}
+				a = append(a, b)
+			}
+		}
+
+		// Otherwise all=descendants(m) and nany=descendants(m) would
+		// match every key.
+		if !found {
+			if len(h.Children(m)) == 0 {
+				return nil, fmt.Errorf("%d is not a member", m)
+			}
+
+			return nil, fmt.Errorf("No member under %d is in the domain", m)
+		}
+	}
+
+	return a, nil
+}
+
+// Descendants returns the members of the domain that are the members or
+// under them in the hierarchy, as expanded by MaskDescendants. The
+// operations do not expand members, so pass the result to them to query
+// a member and everything under it, e.g. ix.Any(ds...), or set the
+// Descendants of an OpExpr.
+func (ix *Index) Descendants(ms ...uint32) ([]uint32, error) {
+	bs, err := ix.Domain.MaskDescendants(ix.Hierarchy, ms...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]uint32, len(bs))
+
+	for i, b := range bs {
+		out[i] = ix.Domain.Member(b)
+	}
+
+	return out, nil
+}
+
+// descendantsLabel returns the label of the member in a label of the
+// form descendants(<label>).
+func descendantsLabel(s string) (string, bool) {
+	if !strings.HasPrefix(s, "descendants(") || !strings.HasSuffix(s, ")") {
+		return "", false
+	}
+
+	return s[len("descendants(") : len(s)-1], true
+}
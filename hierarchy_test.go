@@ -0,0 +1,247 @@
+package bitindex
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHierarchy(t *testing.T) {
+	h := NewHierarchy()
+
+	h.AddEdge(1, 2)
+	h.AddEdge(1, 3)
+	h.AddEdge(1, 3)
+	h.AddEdge(3, 4)
+
+	// Cycle.
+	h.AddEdge(4, 1)
+
+	if h.Size() != 4 {
+		t.Errorf("expected 4 edges, got %d", h.Size())
+	}
+
+	ms := h.Descendants(3)
+
+	if !sameKeys(ms, []uint32{1, 2, 3, 4}) {
+		t.Errorf("expected [1 2 3 4], got %v", ms)
+	}
+
+	if ms = h.Descendants(2); !sameKeys(ms, []uint32{2}) {
+		t.Errorf("expected [2], got %v", ms)
+	}
+}
+
+// newHierarchyIndex returns an index of diagnosis codes where 250 is
+// diabetes, 25001 and 25002 are under it and 2500101 is under 25001.
+// 250 itself is not a member of the domain.
+func newHierarchyIndex() *Index {
+	ix := NewIndex(nil)
+
+	ix.Add(1, 25001)
+	ix.Add(2, 2500101)
+	ix.Add(3, 401)
+	ix.Add(4, 25002)
+	ix.Add(4, 401)
+
+	ix.Hierarchy = NewHierarchy()
+	ix.Hierarchy.AddEdge(250, 25001)
+	ix.Hierarchy.AddEdge(250, 25002)
+	ix.Hierarchy.AddEdge(25001, 2500101)
+
+	// Not in the domain.
+	ix.Hierarchy.AddEdge(250, 25003)
+
+	return ix
+}
+
+func TestHierarchyIndex(t *testing.T) {
+	ix1 := newHierarchyIndex()
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers that do not know the hierarchy reject the file.
+	if h, err := parseHeader(buf.Bytes()); err != nil || h.Flags != flagHierarchy {
+		t.Errorf("expected the hierarchy flag, got %#x (%v)", h.Flags, err)
+	}
+
+	ix2, err := LoadIndex(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	ix3, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ix3.Close()
+
+	tests := map[string][]uint32{
+		"any(descendants(250))":                  {1, 2, 4},
+		"any(descendants(25001))":                {1, 2},
+		"any(descendants(250), 401)":             {1, 2, 3, 4},
+		"all(descendants(25002), 401)":           {4},
+		"atleast(2, descendants(250), 401)":      {4},
+		"any(401) AND NOT any(descendants(250))": {3},
+	}
+
+	for name, ix := range map[string]*Index{"memory": ix1, "loaded": ix2, "mapped": ix3} {
+		if ix.Hierarchy == nil || ix.Hierarchy.Size() != 4 {
+			t.Fatalf("%s: expected 4 edges, got %v", name, ix.Hierarchy)
+		}
+
+		for in, exp := range tests {
+			e, err := ParseExpr(in)
+
+			if err != nil {
+				t.Fatalf("%s: %s", in, err)
+			}
+
+			r, err := ix.QueryExpr(e)
+
+			if err != nil {
+				t.Fatalf("%s: %s: %s", name, in, err)
+			}
+
+			if keys := r.Items(); !sameKeys(keys, exp) {
+				t.Errorf("%s: %s: expected %v, got %v", name, in, exp, keys)
+			}
+		}
+
+		ms, err := ix.LookupMembers("descendants(250)", "25001")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ms) != 3 {
+			t.Errorf("%s: expected 3 members, got %v", name, ms)
+		}
+
+		if _, err := ix.LookupMembers("descendants(999)"); err == nil {
+			t.Errorf("%s: expected lookup error", name)
+		}
+	}
+
+	ix := NewIndex(fruit)
+
+	if _, err := ix.LookupMembers("descendants(1)"); err == nil {
+		t.Errorf("expected error without a hierarchy")
+	}
+}
+
+func TestHierarchyExactMember(t *testing.T) {
+	ix1 := newHierarchyIndex()
+	ix1.Add(5, 250)
+
+	ix2 := newHierarchyIndex()
+	ix2.Add(5, 250)
+	ix2.Postings = BuildPostings(ix2.Table)
+
+	for name, ix := range map[string]*Index{"memory": ix1, "postings": ix2} {
+		// A member without descendants() is used as given.
+		keys, err := ix.Any(250)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if !sameKeys(keys, []uint32{5}) {
+			t.Errorf("%s: any: expected [5], got %v", name, keys)
+		}
+
+		if keys, err = ix.NotAny(250); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		} else if !sameKeys(keys, []uint32{1, 2, 3, 4}) {
+			t.Errorf("%s: nany: expected [1 2 3 4], got %v", name, keys)
+		}
+
+		e, err := ParseExpr("any(250)")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := ix.QueryExpr(e)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if keys = r.Items(); !sameKeys(keys, []uint32{5}) {
+			t.Errorf("%s: expr: expected [5], got %v", name, keys)
+		}
+
+		ms, err := ix.LookupMembers("250")
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if !sameKeys(ms, []uint32{250}) {
+			t.Errorf("%s: lookup: expected [250], got %v", name, ms)
+		}
+	}
+}
+
+func TestHierarchyDescendantsExpr(t *testing.T) {
+	ix1 := newHierarchyIndex()
+	ix1.Hierarchy.AddEdge(300, 30001)
+
+	ix2 := newHierarchyIndex()
+	ix2.Hierarchy.AddEdge(300, 30001)
+	ix2.Postings = BuildPostings(ix2.Table)
+
+	tests := map[string]struct {
+		e   *OpExpr
+		exp []uint32
+	}{
+		"any":     {&OpExpr{Op: "any", Descendants: []uint32{250}}, []uint32{1, 2, 4}},
+		"all":     {&OpExpr{Op: "all", Members: []uint32{401}, Descendants: []uint32{25002}}, []uint32{4}},
+		"nany":    {&OpExpr{Op: "nany", Descendants: []uint32{25001}}, []uint32{3, 4}},
+		"atleast": {&OpExpr{Op: "atleast", N: 2, Members: []uint32{25001}, Descendants: []uint32{25001}}, nil},
+	}
+
+	for name, ix := range map[string]*Index{"memory": ix1, "postings": ix2} {
+		for op, test := range tests {
+			r, err := ix.QueryExpr(test.e)
+
+			if err != nil {
+				t.Fatalf("%s: %s: %s", name, op, err)
+			}
+
+			if keys := r.Items(); !sameKeys(keys, test.exp) {
+				t.Errorf("%s: %s: expected %v, got %v", name, op, test.exp, keys)
+			}
+		}
+
+		// 300 has children, but none of them are in the domain, so
+		// all and nany would otherwise match every key.
+		for _, op := range []string{"all", "nany"} {
+			if _, err := ix.QueryExpr(&OpExpr{Op: op, Descendants: []uint32{300}}); err == nil {
+				t.Errorf("%s: %s: expected error for an empty expansion", name, op)
+			}
+		}
+
+		if _, err := ix.LookupMembers("descendants(300)"); err == nil {
+			t.Errorf("%s: expected lookup error for an empty expansion", name)
+		}
+
+		if _, err := ix.Descendants(250, 300); err == nil {
+			t.Errorf("%s: expected error for an empty expansion", name)
+		}
+	}
+
+	if s := tests["all"].e.String(); s != "all(401,descendants(25002))" {
+		t.Errorf("unexpected string %s", s)
+	}
+}
@@ -1,10 +1,14 @@
 package bitindex
 
 import (
+	"errors"
 	"fmt"
 	"math"
 )
 
+// ErrReadOnly is returned when modifying an index opened with OpenIndex.
+var ErrReadOnly = errors.New("Index is read-only")
+
 // Domain maps a member to a position in the bit array.
 type Domain struct {
 	// Bit array index.
@@ -16,9 +20,13 @@ type Domain struct {
 
 	// Bit -> Member
 	r []uint32
+
+	// Bits of the retired members until the domain is compacted.
+	dead map[uint32]struct{}
 }
 
-// Members returns the members in the domain.
+// Members returns the members in the domain in bit order. Retired
+// members are included until the domain is compacted.
 func (d *Domain) Members() []uint32 {
 	return d.r
 }
@@ -104,121 +112,10 @@ func NewDomain(ms []uint32) *Domain {
 	}
 }
 
-// Loc is the location of the bit in array consisting of the byte
-// position and the relative bit.
-type Loc [2]uint32
-
-// Array represents an array of bytes.
-type Array map[uint32]byte
-
-// Size returns the number of bytes used by the array.
-func (a Array) Bytes() int {
-	return len(a)
-}
-
-// Set sets the bit to 1.
-func (a Array) Set(bit uint32) {
-	off := bit / 8
-	bit = bit % 8
-
-	if _, ok := a[off]; !ok {
-		a[off] = 1 << bit
-	} else {
-		a[off] |= (1 << bit)
-	}
-}
-
-// Clear sets the bit to 0.
-func (a Array) Clear(bit uint32) {
-	off := bit / 8
-	bit = bit % 8
-
-	if _, ok := a[off]; ok {
-		a[off] &= ^(1 << bit)
-	}
-}
-
-// Flip flips the bit.
-func (a Array) Flip(bit uint32) bool {
-	t := a.Has(bit)
-
-	if t {
-		a.Clear(bit)
-	} else {
-		a.Set(bit)
-	}
-
-	return !t
-}
-
-// Has returns true if the bit is set.
-func (a Array) Has(bit uint32) bool {
-	off := bit / 8
-	bit = bit % 8
-
-	if bt, ok := a[off]; ok {
-		v := bt & (1 << bit)
-		return v > 0
-	}
-
-	return false
-}
-
-// Loc returns the location of the bit in this array.
-func (a Array) Loc(bit uint32) Loc {
-	return Loc{bit / 8, bit % 8}
-}
-
-// Any returns true any of the bits set.
-func (a Array) Any(bits ...uint32) bool {
-	for _, bit := range bits {
-		if a.Has(bit) {
-			return true
-		}
-	}
-
-	return false
-}
-
-// All returns true if all of the bits are set.
-func (a Array) All(bits ...uint32) bool {
-	for _, bit := range bits {
-		if !a.Has(bit) {
-			return false
-		}
-	}
-
-	return true
-}
-
-// NotAny returns true if any of the bits are not set.
-func (a Array) NotAny(bits ...uint32) bool {
-	for _, bit := range bits {
-		if a.Has(bit) {
-			return false
-		}
-	}
-
-	return true
-}
-
-// NotAll returns true if all of the bits are not set.
-func (a Array) NotAll(bits ...uint32) bool {
-	for _, bit := range bits {
-		if !a.Has(bit) {
-			return true
-		}
-	}
-
-	return false
-}
-
-// NewArray initializes a new array.
-func NewArray() Array {
-	return make(Array)
-}
-
-// Table is an map of keys to bit arrays.
+// Table is an map of keys to bit arrays. The table of an index opened
+// with OpenIndex is empty since its arrays are decoded from the mapping
+// on demand, so use the Get and Each methods of the index rather than
+// the table to read any index.
 type Table map[uint32]Array
 
 // Keys returns all keys in the table.
@@ -267,23 +164,143 @@ func (t Table) Set(k uint32, b uint32) {
 	a.Set(b)
 }
 
+// Pack selects the best suited representation for each array in the table.
+// It should be called once the table is fully built.
+func (t Table) Pack() {
+	for k, a := range t {
+		t[k] = Pack(a)
+	}
+}
+
 // Index combines and domain
 type Index struct {
 	Domain *Domain
 	Table  Table
+
+	// Optional inverted index of bits to keys. If set, it is maintained
+	// by Add and used to evaluate operations.
+	Postings *Postings
+
+	// Optional dictionaries of the labels of the keys and members. If
+	// set, the keys and members are the ids of the labels.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+
+	// Optional map of 64-bit keys. If set, the keys in the table are
+	// the ids of the 64-bit keys.
+	WideKeys *KeyMap
+
+	// Optional hierarchy over the members used to expand a member to
+	// the members under it.
+	Hierarchy *Hierarchy
+
+	// Set if the index was opened with OpenIndex. The table is empty
+	// and arrays are decoded from the mapping on demand.
+	m *mapping
 }
 
-// Add adds sets the bit for key `k` for member `m` in the domain.
-func (ix *Index) Add(k uint32, m uint32) {
+// Add adds sets the bit for key `k` for member `m` in the domain. It
+// returns ErrReadOnly if the index was opened with OpenIndex.
+func (ix *Index) Add(k uint32, m uint32) error {
+	if err := ix.writable(); err != nil {
+		return err
+	}
+
 	b := ix.Domain.Add(m)
 
 	ix.Table.Set(k, b)
+
+	if ix.Postings != nil {
+		ix.Postings.Set(b, k)
+	}
+
+	return nil
+}
+
+// Pack selects the representation of the table arrays and compresses
+// the postings. It should be called once the index is fully built.
+func (ix *Index) Pack() {
+	ix.Table.Pack()
+
+	if ix.Postings != nil {
+		ix.Postings.RunOptimize()
+	}
+}
+
+// Keys returns the bitmap of all keys in the index.
+func (ix *Index) Keys() *Bitmap {
+	if ix.Postings != nil {
+		return ix.Postings.Keys()
+	}
+
+	if ix.m != nil {
+		return ix.m.Keys()
+	}
+
+	return NewBitmap(ix.Table.Keys()...)
+}
+
+// Get returns the array for the key or nil if the key is not in the index.
+// An array of a mapped index that cannot be decoded is empty; see Err.
+func (ix *Index) Get(k uint32) Array {
+	if ix.m != nil {
+		return ix.m.get(k)
+	}
+
+	return ix.Table.Get(k)
+}
+
+// Size returns the number of keys in the index.
+func (ix *Index) Size() int {
+	if ix.m != nil {
+		return ix.m.l.size()
+	}
+
+	return ix.Table.Size()
+}
+
+// Bytes returns the number of bytes allocated by the arrays. For a mapped
+// index, this is the number recorded when the index was written.
+func (ix *Index) Bytes() int {
+	if ix.m != nil {
+		return ix.m.l.stats.Bytes
+	}
+
+	return ix.Table.Bytes()
+}
+
+// Each calls f for each key and its array. An array of a mapped index
+// that cannot be decoded is empty; see Err.
+func (ix *Index) Each(f func(k uint32, a Array)) {
+	if ix.m != nil {
+		ix.m.each(f)
+		return
+	}
+
+	for k, a := range ix.Table {
+		f(k, a)
+	}
 }
 
 // Has returns true if the key has the member.
 func (ix *Index) Has(k uint32, m uint32) bool {
 	b := ix.Domain.Bit(m)
-	return ix.Table.Get(k).Has(b)
+
+	if a := ix.Get(k); a != nil {
+		return a.Has(b)
+	}
+
+	return false
+}
+
+// result returns the keys unless an array or posting list of a mapped
+// index could not be decoded while computing them.
+func (ix *Index) result(keys []uint32) ([]uint32, error) {
+	if err := ix.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
 }
 
 // Any returns all keys that match any of the passed members.
@@ -295,15 +312,21 @@ func (ix *Index) Any(ms ...uint32) ([]uint32, error) {
 		return nil, err
 	}
 
+	if ix.Postings != nil {
+		return ix.result(ix.Postings.Any(bs...).Bits())
+	}
+
+	m := NewMask(bs...)
+
 	var keys []uint32
 
-	for k, a := range ix.Table {
-		if a.Any(bs...) {
+	ix.Each(func(k uint32, a Array) {
+		if a.AnyMask(m) {
 			keys = append(keys, k)
 		}
-	}
+	})
 
-	return keys, nil
+	return ix.result(keys)
 }
 
 // All returns all keys that match all of the passed members.
@@ -315,15 +338,21 @@ func (ix *Index) All(ms ...uint32) ([]uint32, error) {
 		return nil, err
 	}
 
+	if ix.Postings != nil {
+		return ix.result(ix.Postings.All(bs...).Bits())
+	}
+
+	m := NewMask(bs...)
+
 	var keys []uint32
 
-	for k, a := range ix.Table {
-		if a.All(bs...) {
+	ix.Each(func(k uint32, a Array) {
+		if a.AllMask(m) {
 			keys = append(keys, k)
 		}
-	}
+	})
 
-	return keys, nil
+	return ix.result(keys)
 }
 
 // NotAny returns all keys that do not match any of the passed members.
@@ -335,15 +364,21 @@ func (ix *Index) NotAny(ms ...uint32) ([]uint32, error) {
 		return nil, err
 	}
 
+	if ix.Postings != nil {
+		return ix.result(ix.Postings.NotAny(bs...).Bits())
+	}
+
+	m := NewMask(bs...)
+
 	var keys []uint32
 
-	for k, a := range ix.Table {
-		if a.NotAny(bs...) {
+	ix.Each(func(k uint32, a Array) {
+		if !a.AnyMask(m) {
 			keys = append(keys, k)
 		}
-	}
+	})
 
-	return keys, nil
+	return ix.result(keys)
 }
 
 // NotAll returns all keys that do not match all of the passed members.
@@ -355,18 +390,100 @@ func (ix *Index) NotAll(ms ...uint32) ([]uint32, error) {
 		return nil, err
 	}
 
+	if ix.Postings != nil {
+		return ix.result(ix.Postings.NotAll(bs...).Bits())
+	}
+
+	m := NewMask(bs...)
+
 	var keys []uint32
 
-	for k, a := range ix.Table {
-		if a.NotAll(bs...) {
+	ix.Each(func(k uint32, a Array) {
+		if !a.AllMask(m) {
 			keys = append(keys, k)
 		}
+	})
+
+	return ix.result(keys)
+}
+
+// AtLeast returns all keys that match at least n of the passed members.
+func (ix *Index) AtLeast(n int, ms ...uint32) ([]uint32, error) {
+	// Get the mask.
+	bs, err := ix.Domain.Mask(ms...)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return keys, nil
+	if ix.Postings != nil {
+		return ix.result(ix.Postings.AtLeast(n, bs...).Bits())
+	}
+
+	m := NewMask(bs...)
+
+	var keys []uint32
+
+	ix.Each(func(k uint32, a Array) {
+		if a.CountMask(m) >= n {
+			keys = append(keys, k)
+		}
+	})
+
+	return ix.result(keys)
 }
 
-func (ix *Index) Query(any, all, nany, nall []uint32) (*Result, error) {
+// AtMost returns all keys that match at most n of the passed members.
+func (ix *Index) AtMost(n int, ms ...uint32) ([]uint32, error) {
+	// Get the mask.
+	bs, err := ix.Domain.Mask(ms...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ix.Postings != nil {
+		return ix.result(ix.Postings.AtMost(n, bs...).Bits())
+	}
+
+	m := NewMask(bs...)
+
+	var keys []uint32
+
+	ix.Each(func(k uint32, a Array) {
+		if a.CountMask(m) <= n {
+			keys = append(keys, k)
+		}
+	})
+
+	return ix.result(keys)
+}
+
+// Threshold is a query clause that matches keys having at least, or at
+// most, N of the members.
+type Threshold struct {
+	N       int
+	Members []uint32
+
+	// If true, keys with at most N of the members match.
+	AtMost bool
+}
+
+func (t Threshold) name() string {
+	if t.AtMost {
+		return "atmost"
+	}
+
+	return "atleast"
+}
+
+// Query returns the keys matching all of the passed operations. Any
+// number of thresholds may be applied in addition to the operations.
+func (ix *Index) Query(any, all, nany, nall []uint32, ts ...Threshold) (*Result, error) {
+	if ix.Postings != nil {
+		return ix.queryPostings(any, all, nany, nall, ts)
+	}
+
 	var (
 		err  error
 		set  Uint32Set
@@ -434,18 +551,249 @@ func (ix *Index) Query(any, all, nany, nall []uint32) (*Result, error) {
 		}
 	}
 
+	for _, t := range ts {
+		if t.AtMost {
+			keys, err = ix.AtMost(t.N, t.Members...)
+		} else {
+			keys, err = ix.AtLeast(t.N, t.Members...)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Operation failed (%s): %s\n", t.name(), err)
+		}
+
+		if set == nil {
+			set = make(Uint32Set, len(keys))
+			set.Add(keys...)
+		} else {
+			tmp.Add(keys...)
+			set = set.Intersect(tmp)
+			tmp.Clear()
+		}
+	}
+
 	return &Result{
 		set: set,
 		idx: ix,
 	}, nil
 }
 
+// queryPostings evaluates the query using bitmap operations on the postings.
+func (ix *Index) queryPostings(any, all, nany, nall []uint32, ts []Threshold) (*Result, error) {
+	ops := []struct {
+		name string
+		ms   []uint32
+		eval func(...uint32) *Bitmap
+	}{
+		{"any", any, ix.Postings.Any},
+		{"all", all, ix.Postings.All},
+		{"nany", nany, ix.Postings.NotAny},
+		{"nall", nall, ix.Postings.NotAll},
+	}
+
+	var keys *Bitmap
+
+	for _, op := range ops {
+		if op.ms == nil {
+			continue
+		}
+
+		bs, err := ix.Domain.Mask(op.ms...)
+
+		if err != nil {
+			return nil, fmt.Errorf("Operation failed (%s): %s\n", op.name, err)
+		}
+
+		if b := op.eval(bs...); keys == nil {
+			keys = b
+		} else {
+			keys = keys.And(b)
+		}
+	}
+
+	for _, t := range ts {
+		bs, err := ix.Domain.Mask(t.Members...)
+
+		if err != nil {
+			return nil, fmt.Errorf("Operation failed (%s): %s\n", t.name(), err)
+		}
+
+		var b *Bitmap
+
+		if t.AtMost {
+			b = ix.Postings.AtMost(t.N, bs...)
+		} else {
+			b = ix.Postings.AtLeast(t.N, bs...)
+		}
+
+		if keys == nil {
+			keys = b
+		} else {
+			keys = keys.And(b)
+		}
+	}
+
+	if err := ix.Err(); err != nil {
+		return nil, err
+	}
+
+	if keys == nil {
+		keys = NewBitmap()
+	}
+
+	return &Result{
+		keys: keys,
+		idx:  ix,
+	}, nil
+}
+
+// Count returns the number of keys matching all of the passed operations.
+// Unlike Query, the matching keys are never collected. Without postings,
+// the table is scanned once and each array is tested against every
+// operation.
+func (ix *Index) Count(any, all, nany, nall []uint32, ts ...Threshold) (int, error) {
+	if ix.Postings != nil {
+		r, err := ix.queryPostings(any, all, nany, nall, ts)
+
+		if err != nil {
+			return 0, err
+		}
+
+		return r.Len(), nil
+	}
+
+	var tests []func(Array) bool
+
+	ops := []struct {
+		name string
+		ms   []uint32
+		test func(Array, Mask) bool
+	}{
+		{"any", any, Array.AnyMask},
+		{"all", all, Array.AllMask},
+		{"nany", nany, func(a Array, m Mask) bool { return !a.AnyMask(m) }},
+		{"nall", nall, func(a Array, m Mask) bool { return !a.AllMask(m) }},
+	}
+
+	for _, op := range ops {
+		if op.ms == nil {
+			continue
+		}
+
+		bs, err := ix.Domain.Mask(op.ms...)
+
+		if err != nil {
+			return 0, fmt.Errorf("Operation failed (%s): %s\n", op.name, err)
+		}
+
+		m, test := NewMask(bs...), op.test
+
+		tests = append(tests, func(a Array) bool {
+			return test(a, m)
+		})
+	}
+
+	for _, t := range ts {
+		bs, err := ix.Domain.Mask(t.Members...)
+
+		if err != nil {
+			return 0, fmt.Errorf("Operation failed (%s): %s\n", t.name(), err)
+		}
+
+		m, n, most := NewMask(bs...), t.N, t.AtMost
+
+		tests = append(tests, func(a Array) bool {
+			if most {
+				return a.CountMask(m) <= n
+			}
+
+			return a.CountMask(m) >= n
+		})
+	}
+
+	// Consistent with Query, no operations match nothing.
+	if len(tests) == 0 {
+		return 0, nil
+	}
+
+	var c int
+
+	ix.Each(func(_ uint32, a Array) {
+		for _, test := range tests {
+			if !test(a) {
+				return
+			}
+		}
+
+		c++
+	})
+
+	if err := ix.Err(); err != nil {
+		return 0, err
+	}
+
+	return c, nil
+}
+
 // Sparsity returns the proportion of bits being represented in the domain
 // to the bytes being allocated in the index.
 func (ix *Index) Sparsity() float32 {
-	alloc := float32(ix.Table.Bytes())
-	avg := alloc / float32(ix.Table.Size())
-	return 1 - avg/float32(ix.Domain.Bytes())
+	return ix.Stats().Sparsity()
+}
+
+// Stats are summary statistics of an index. They are stored with the
+// index so they can be loaded without reading the table.
+type Stats struct {
+	// Number of members in the domain.
+	DomainSize int
+
+	// Number of keys in the table.
+	TableSize int
+
+	// Number of bytes allocated by the arrays.
+	Bytes int
+
+	// True if the index has postings.
+	Postings bool
+
+	// Codec of the sections of the index file.
+	Codec Codec
+}
+
+// Sparsity returns the proportion of bits being represented in the domain
+// to the bytes being allocated in the index. The bytes are the encoded
+// size of the arrays, including the headers of their containers, so an
+// index of small arrays can allocate more than a bit per member. Sparsity
+// is clamped to 0 then, and is 0 for an empty index.
+func (s *Stats) Sparsity() float32 {
+	if s.TableSize == 0 || s.DomainSize == 0 {
+		return 0
+	}
+
+	alloc := float32(s.Bytes)
+	avg := alloc / float32(s.TableSize)
+	sp := 1 - avg/float32(math.Ceil(float64(s.DomainSize)/8.0))
+
+	if sp < 0 {
+		return 0
+	}
+
+	return sp
+}
+
+// Stats returns the summary statistics of the index.
+func (ix *Index) Stats() *Stats {
+	if ix.m != nil {
+		s := *ix.m.l.stats
+		return &s
+	}
+
+	return &Stats{
+		DomainSize: ix.Domain.Size(),
+		TableSize:  ix.Size(),
+		Bytes:      ix.Bytes(),
+		Postings:   ix.Postings != nil,
+	}
 }
 
 // NewIndex initializes a new index.
@@ -465,28 +813,41 @@ type Indexer interface {
 type Result struct {
 	set Uint32Set
 	idx *Index
+
+	// Set instead of `set` when the query was evaluated on the postings.
+	keys *Bitmap
 }
 
 func (r *Result) Items() []uint32 {
+	if r.keys != nil {
+		return r.keys.Bits()
+	}
+
 	return r.set.Items()
 }
 
 func (r *Result) Len() int {
+	if r.keys != nil {
+		return r.keys.Cardinality()
+	}
+
 	return r.set.Len()
 }
 
 func (r *Result) Smallest(thres float32) bool {
-	return float32(r.Len())/float32(len(r.idx.Table)) < thres
+	return float32(r.Len())/float32(r.idx.Size()) < thres
 }
 
 func (r *Result) Complement() []uint32 {
-	items := make([]uint32, len(r.idx.Table)-r.set.Len())
-	i := 0
+	if r.keys != nil {
+		return r.idx.Keys().AndNot(r.keys).Bits()
+	}
+
+	items := make([]uint32, 0, r.idx.Size()-r.set.Len())
 
-	for k, _ := range r.idx.Table {
+	for _, k := range r.idx.Keys().Bits() {
 		if !r.set.Contains(k) {
-			items[i] = k
-			i++
+			items = append(items, k)
 		}
 	}
 
@@ -112,6 +112,29 @@ func TestIndex(t *testing.T) {
 	}
 }
 
+func TestStatsSparsity(t *testing.T) {
+	// A member per key of a small domain is encoded in more bytes than
+	// the domain has bits.
+	ix := NewIndex(nil)
+	ix.Add(1, 1)
+	ix.Add(2, 2)
+
+	if sp := ix.Sparsity(); sp < 0 || sp > 1 {
+		t.Errorf("expected sparsity in [0, 1], got %f", sp)
+	}
+
+	if sp := (&Stats{}).Sparsity(); sp != 0 {
+		t.Errorf("expected sparsity 0 for an empty index, got %f", sp)
+	}
+
+	// A key with a few members of a large domain.
+	s := &Stats{DomainSize: 8000, TableSize: 1, Bytes: 10}
+
+	if sp := s.Sparsity(); sp < 0.98 || sp > 1 {
+		t.Errorf("expected sparsity 0.99, got %f", sp)
+	}
+}
+
 func TestIndexOperations(t *testing.T) {
 	ix := NewIndex(fruit)
 
@@ -168,6 +191,124 @@ func TestIndexOperations(t *testing.T) {
 	}
 }
 
+func TestIndexThreshold(t *testing.T) {
+	ix := NewIndex(fruit)
+
+	for k, s := range pairs {
+		for _, b := range s {
+			ix.Add(k, b)
+		}
+	}
+
+	px := newPostingsIndex()
+
+	tests := []struct {
+		n      int
+		most   bool
+		ms     []uint32
+		expect []uint32
+	}{
+		{1, false, []uint32{1, 2}, []uint32{100, 102}},
+		{2, false, []uint32{1, 2, 3, 4}, []uint32{100, 102}},
+		{3, false, []uint32{2, 3, 4, 9}, []uint32{102}},
+		{2, false, []uint32{4, 4}, nil},
+		{0, false, []uint32{1}, []uint32{100, 101, 102}},
+		{5, false, []uint32{1, 2}, nil},
+		{1, true, []uint32{3, 4}, []uint32{100, 101}},
+		{0, true, []uint32{1, 3}, []uint32{101}},
+		{2, true, []uint32{1, 2}, []uint32{100, 101, 102}},
+	}
+
+	for _, x := range []*Index{ix, px} {
+		for _, test := range tests {
+			var (
+				o   []uint32
+				err error
+			)
+
+			if test.most {
+				o, err = x.AtMost(test.n, test.ms...)
+			} else {
+				o, err = x.AtLeast(test.n, test.ms...)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !sameKeys(o, test.expect) {
+				t.Errorf("%d of %v (at most %v): expected %v, got %v", test.n, test.ms, test.most, test.expect, o)
+			}
+		}
+
+		// Combined with other operations.
+		r, err := x.Query([]uint32{4}, nil, nil, nil, Threshold{N: 2, Members: []uint32{2, 3, 9}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sameKeys(r.Items(), []uint32{102}) {
+			t.Errorf("expected [102], got %v", r.Items())
+		}
+	}
+}
+
+func TestIndexCount(t *testing.T) {
+	ix := NewIndex(fruit)
+
+	for k, s := range pairs {
+		for _, b := range s {
+			ix.Add(k, b)
+		}
+	}
+
+	px := newPostingsIndex()
+
+	queries := [][5][]uint32{
+		{{1, 2}, nil, nil, nil},
+		{nil, {1, 3}, nil, nil},
+		{nil, nil, {3, 1}, nil},
+		{nil, nil, nil, {4, 2}},
+		{{3, 4}, nil, nil, {1, 3}},
+		{{4}, nil, {9}, nil},
+		{nil, nil, nil, nil},
+	}
+
+	ts := []Threshold{{N: 2, Members: []uint32{2, 3, 4}}}
+
+	for _, x := range []*Index{ix, px} {
+		for _, q := range queries {
+			r, err := x.Query(q[0], q[1], q[2], q[3])
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := x.Count(q[0], q[1], q[2], q[3])
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != r.Len() {
+				t.Errorf("%v: expected count %d, got %d", q, r.Len(), n)
+			}
+
+			r, _ = x.Query(q[0], q[1], q[2], q[3], ts...)
+			n, _ = x.Count(q[0], q[1], q[2], q[3], ts...)
+
+			if n != r.Len() {
+				t.Errorf("%v with threshold: expected count %d, got %d", q, r.Len(), n)
+			}
+		}
+
+		if _, err := x.Count([]uint32{42}, nil, nil, nil); err == nil {
+			t.Errorf("expected error for unknown member")
+		}
+	}
+}
+
 func BenchmarkDomainAdd(b *testing.B) {
 	d := NewDomain(nil)
 
@@ -0,0 +1,250 @@
+package bitindex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSONIndexer is an indexer for JSON Lines data, i.e. one JSON object
+// per line. The key and member are read from the fields at KeyField and
+// MemberField. If the member field is an array, the key is indexed with
+// each of its elements.
+type JSONIndexer struct {
+	r *bufio.Reader
+
+	// Paths of the key and member fields. Fields of nested objects are
+	// separated by dots, e.g. "patient.id".
+	KeyField    string
+	MemberField string
+
+	// If true, the postings are built along with the table.
+	Postings bool
+
+	// If true, keys and members are labels encoded with the dictionaries.
+	// Strings and numbers are both accepted as labels.
+	Labels bool
+
+	// If true, keys are 64-bit integers mapped to ids with WideKeys.
+	Keys64 bool
+
+	// Dictionaries of the key and member labels. They are initialized
+	// when Labels is set.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+
+	// Map of the 64-bit keys. It is initialized when Keys64 is set.
+	WideKeys *KeyMap
+}
+
+// NewJSONIndexer initializes a new JSON Lines parser for building an index.
+func NewJSONIndexer(r io.Reader) *JSONIndexer {
+	return &JSONIndexer{
+		r:           bufio.NewReader(r),
+		KeyField:    "key",
+		MemberField: "member",
+	}
+}
+
+// field returns the value at the dotted path of the object.
+func field(v interface{}, path string) (interface{}, bool) {
+	for _, name := range strings.Split(path, ".") {
+		o, ok := v.(map[string]interface{})
+
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = o[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// jsonLabel returns the string or number as a label.
+func jsonLabel(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+
+	case json.Number:
+		return x.String(), nil
+	}
+
+	return "", fmt.Errorf("Expected a string or number, got %v", v)
+}
+
+// jsonUint returns the integer of the number or string.
+func jsonUint(v interface{}, bits int) (uint64, error) {
+	s, err := jsonLabel(v)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(s, 10, bits)
+}
+
+// pair returns the key and members of the record.
+func (p *JSONIndexer) pair(rec interface{}) (uint32, []uint32, error) {
+	kv, ok := field(rec, p.KeyField)
+
+	if !ok {
+		return 0, nil, fmt.Errorf("Missing key field %s", p.KeyField)
+	}
+
+	mv, ok := field(rec, p.MemberField)
+
+	if !ok {
+		return 0, nil, fmt.Errorf("Missing member field %s", p.MemberField)
+	}
+
+	vs, ok := mv.([]interface{})
+
+	if !ok {
+		vs = []interface{}{mv}
+	}
+
+	// No pairs, so the key is not added to the dictionary either.
+	if len(vs) == 0 {
+		return 0, nil, nil
+	}
+
+	var k uint32
+
+	switch {
+	case p.Labels:
+		s, err := jsonLabel(kv)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		k = p.KeyDict.Add(s)
+
+	case p.Keys64:
+		k64, err := jsonUint(kv, 64)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		k = p.WideKeys.Add(k64)
+
+	default:
+		k32, err := jsonUint(kv, 32)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		k = uint32(k32)
+	}
+
+	ms := make([]uint32, len(vs))
+
+	for i, v := range vs {
+		if p.Labels {
+			s, err := jsonLabel(v)
+
+			if err != nil {
+				return 0, nil, err
+			}
+
+			ms[i] = p.MemberDict.Add(s)
+			continue
+		}
+
+		m, err := jsonUint(v, 32)
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		ms[i] = uint32(m)
+	}
+
+	return k, ms, nil
+}
+
+// Stream implements the Streamer interface and calls f for each
+// key/member pair in the records. Blank lines are skipped.
+func (p *JSONIndexer) Stream(f func(k uint32, m uint32) error) error {
+	if p.Labels && p.KeyDict == nil {
+		p.KeyDict = NewDictionary()
+		p.MemberDict = NewDictionary()
+	}
+
+	if p.Keys64 && p.WideKeys == nil {
+		p.WideKeys = NewKeyMap()
+	}
+
+	for n := 1; ; n++ {
+		line, err := p.r.ReadBytes('\n')
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var rec interface{}
+
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.UseNumber()
+
+			if derr := dec.Decode(&rec); derr != nil {
+				return fmt.Errorf("Line %d: %s", n, derr)
+			}
+
+			if dec.More() {
+				return fmt.Errorf("Line %d: Expected a single object", n)
+			}
+
+			k, ms, perr := p.pair(rec)
+
+			if perr != nil {
+				return fmt.Errorf("Line %d: %s", n, perr)
+			}
+
+			for _, m := range ms {
+				if ferr := f(k, m); ferr != nil {
+					return ferr
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Index implements the Indexer interface and builds an index from the
+// JSON Lines data.
+func (p *JSONIndexer) Index() (*Index, error) {
+	ix := NewIndex(nil)
+
+	if p.Postings {
+		ix.Postings = NewPostings()
+	}
+
+	err := p.Stream(ix.Add)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ix.KeyDict = p.KeyDict
+	ix.MemberDict = p.MemberDict
+	ix.WideKeys = p.WideKeys
+
+	ix.Pack()
+
+	return ix, nil
+}
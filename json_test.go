@@ -0,0 +1,111 @@
+package bitindex
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONIndexer(t *testing.T) {
+	in := `{"patient": {"id": 12}, "codes": [1, 4, 9]}
+
+{"patient": {"id": "13"}, "codes": 4}
+{"patient": {"id": 14}, "codes": []}
+`
+
+	p := NewJSONIndexer(strings.NewReader(in))
+	p.KeyField = "patient.id"
+	p.MemberField = "codes"
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ix.Domain.Size() != 3 || ix.Size() != 2 {
+		t.Errorf("expected 3 members and 2 keys, got %d and %d", ix.Domain.Size(), ix.Size())
+	}
+
+	keys, err := ix.Any(4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sameKeys(keys, []uint32{12, 13}) {
+		t.Errorf("expected [12 13], got %v", keys)
+	}
+
+	if keys, err = ix.All(1, 9); err != nil {
+		t.Fatal(err)
+	}
+
+	if !sameKeys(keys, []uint32{12}) {
+		t.Errorf("expected [12], got %v", keys)
+	}
+}
+
+func TestJSONIndexerLabels(t *testing.T) {
+	in := `{"mrn": "A100", "dx": ["E11.9", "I10"]}
+{"mrn": "B200", "dx": ["J45"]}
+{"mrn": 300, "dx": "I10"}
+`
+
+	p := NewJSONIndexer(strings.NewReader(in))
+	p.KeyField = "mrn"
+	p.MemberField = "dx"
+	p.Labels = true
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := ix.LookupMembers("I10")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ix.Any(ms...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameLabels(t, "any(I10)", ix.KeyLabels(keys), []string{"A100", "300"})
+}
+
+func TestJSONIndexerErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"key": 1, "member": 2}` + "\n" + `{"key": 1}`: "Line 2: Missing member field",
+		`{"key": 1, "member": 2.5}`:                     "Line 1: strconv.ParseUint",
+		`{"key": 4294967296, "member": 1}`:              "out of range",
+		`{"key": 1, "member": {"a": 1}}`:                "Expected a string or number",
+		`{"key": 1, "member": 2} {"key": 2}`:            "Expected a single object",
+		`{"key": 1,`:                                    "Line 1:",
+	}
+
+	for in, exp := range tests {
+		p := NewJSONIndexer(strings.NewReader(in))
+
+		if _, err := p.Index(); err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("%s: expected error %q, got %v", in, exp, err)
+		}
+	}
+
+	// 64-bit keys are accepted with Keys64.
+	p := NewJSONIndexer(strings.NewReader(`{"key": 4294967296, "member": 1}`))
+	p.Keys64 = true
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k, _ := ix.WideKeys.Key(0); k != 1<<32 {
+		t.Errorf("expected %d, got %d", uint64(1<<32), k)
+	}
+}
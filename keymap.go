@@ -0,0 +1,297 @@
+package bitindex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ErrNarrowKeys is returned when adding a 64-bit key to an index that
+// has 32-bit keys.
+var ErrNarrowKeys = errors.New("Index has 32-bit keys")
+
+// KeyMap maps 64-bit keys to the 32-bit ids used in the table. This
+// allows indexing keys whose values do not fit in 32 bits, such as
+// encounter ids, as long as there are fewer than 2^32 distinct keys.
+// Ids are assigned in the order keys are added, starting at zero.
+type KeyMap struct {
+	// Key -> Id
+	ids map[uint64]uint32
+
+	// Id -> Key
+	keys []uint64
+
+	// Set if the index was opened with OpenIndex. Keys are read
+	// from the mapping on demand.
+	m *keyMapLayout
+}
+
+// Add adds a key to the map and returns its id.
+func (km *KeyMap) Add(k uint64) uint32 {
+	if km.m != nil {
+		panic("key map is read-only")
+	}
+
+	// Do not add duplicates.
+	if id, ok := km.ids[k]; ok {
+		return id
+	}
+
+	id := uint32(len(km.keys))
+
+	km.ids[k] = id
+	km.keys = append(km.keys, k)
+
+	return id
+}
+
+// ID returns the id of the key.
+func (km *KeyMap) ID(k uint64) (uint32, bool) {
+	if km.m != nil {
+		return km.m.id(k)
+	}
+
+	id, ok := km.ids[k]
+	return id, ok
+}
+
+// Key returns the key of the id.
+func (km *KeyMap) Key(id uint32) (uint64, bool) {
+	if km.m != nil {
+		return km.m.key(id)
+	}
+
+	if int(id) >= len(km.keys) {
+		return 0, false
+	}
+
+	return km.keys[id], true
+}
+
+// Size returns the number of keys in the map.
+func (km *KeyMap) Size() int {
+	if km.m != nil {
+		return km.m.size()
+	}
+
+	return len(km.keys)
+}
+
+// NewKeyMap initializes an empty key map.
+func NewKeyMap() *KeyMap {
+	return &KeyMap{
+		ids: make(map[uint64]uint32),
+	}
+}
+
+// keyMapLayout locates a key map in an index held in memory.
+type keyMapLayout struct {
+	// Keys in id order.
+	keys []byte
+
+	// Ids in key order.
+	sorted []byte
+}
+
+func (l *keyMapLayout) size() int {
+	return len(l.sorted) / 4
+}
+
+func (l *keyMapLayout) key(id uint32) (uint64, bool) {
+	if int(id) >= l.size() {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint64(l.keys[uint64(id)*8:]), true
+}
+
+func (l *keyMapLayout) id(k uint64) (uint32, bool) {
+	n := l.size()
+
+	at := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(l.sorted[i*4:])
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		x, _ := l.key(at(i))
+		return x >= k
+	})
+
+	if i < n {
+		if x, _ := l.key(at(i)); x == k {
+			return at(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// load decodes the keys into a key map held in memory.
+func (l *keyMapLayout) load() *KeyMap {
+	km := NewKeyMap()
+
+	for i, n := 0, l.size(); i < n; i++ {
+		k, _ := l.key(uint32(i))
+		km.Add(k)
+	}
+
+	return km
+}
+
+// decodeKeyMap locates the parts of a key map section.
+func decodeKeyMap(b []byte) (*keyMapLayout, error) {
+	if len(b) < 4 {
+		return nil, ErrCorrupt
+	}
+
+	n := uint64(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+
+	if uint64(len(b)) != n*12 {
+		return nil, ErrCorrupt
+	}
+
+	l := &keyMapLayout{
+		keys:   b[:n*8],
+		sorted: b[n*8:],
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if binary.LittleEndian.Uint32(l.sorted[i*4:]) >= uint32(n) {
+			return nil, ErrCorrupt
+		}
+	}
+
+	return l, nil
+}
+
+// keyMapSize returns the number of bytes of the encoded key map.
+func keyMapSize(km *KeyMap) uint64 {
+	return 4 + uint64(km.Size())*12
+}
+
+func dumpKeyMap(w io.Writer, km *KeyMap, b []byte) error {
+	n := km.Size()
+
+	if err := writeUint32(w, b, uint32(n)); err != nil {
+		return fmt.Errorf("Error writing key map length: %s", err)
+	}
+
+	for i := 0; i < n; i++ {
+		k, _ := km.Key(uint32(i))
+
+		if err := writeUint64(w, b, k); err != nil {
+			return fmt.Errorf("Error writing key: %s", err)
+		}
+	}
+
+	// Ids in key order for lookups by key.
+	ids := make([]uint32, n)
+
+	for i := range ids {
+		ids[i] = uint32(i)
+	}
+
+	sort.Sort(&keyOrder{km, ids})
+
+	for _, id := range ids {
+		if err := writeUint32(w, b, id); err != nil {
+			return fmt.Errorf("Error writing key id: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// keyOrder sorts ids by their key.
+type keyOrder struct {
+	km  *KeyMap
+	ids []uint32
+}
+
+func (o *keyOrder) Len() int {
+	return len(o.ids)
+}
+
+func (o *keyOrder) Less(i, j int) bool {
+	x, _ := o.km.Key(o.ids[i])
+	y, _ := o.km.Key(o.ids[j])
+	return x < y
+}
+
+func (o *keyOrder) Swap(i, j int) {
+	o.ids[i], o.ids[j] = o.ids[j], o.ids[i]
+}
+
+// Add64 sets the bit for the 64-bit key `k` for member `m` in the domain.
+// The key is mapped to an id with the WideKeys map, which is created on
+// first use. An index cannot have both 32-bit and 64-bit keys, so it
+// returns ErrNarrowKeys if the index already has 32-bit keys.
+func (ix *Index) Add64(k uint64, m uint32) error {
+	if err := ix.writable(); err != nil {
+		return err
+	}
+
+	if ix.WideKeys == nil {
+		if ix.Size() > 0 {
+			return ErrNarrowKeys
+		}
+
+		ix.WideKeys = NewKeyMap()
+	}
+
+	return ix.Add(ix.WideKeys.Add(k), m)
+}
+
+// Keys64 returns the 64-bit keys of the ids. If the index does not have
+// 64-bit keys, the ids are the keys.
+func (ix *Index) Keys64(ids []uint32) []uint64 {
+	keys := make([]uint64, len(ids))
+
+	for i, id := range ids {
+		if ix.WideKeys == nil {
+			keys[i] = uint64(id)
+		} else {
+			keys[i], _ = ix.WideKeys.Key(id)
+		}
+	}
+
+	return keys
+}
+
+// LoadWideKeys loads only the map of the 64-bit keys from an io.Reader.
+// It returns nil if the index has 32-bit keys. The checksum is not
+// validated.
+func LoadWideKeys(r io.Reader) (*KeyMap, error) {
+	h, err := readHeader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if h.Flags&flagWideKeys == 0 {
+		return nil, nil
+	}
+
+	toc, err := readTOC(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readSection(r, h, toc, sectionWideKeys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := decodeKeyMap(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.load(), nil
+}
@@ -0,0 +1,166 @@
+package bitindex
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKeyMap(t *testing.T) {
+	km := NewKeyMap()
+
+	for i, k := range []uint64{1 << 40, 7, 1 << 40, 1<<63 + 1} {
+		id := km.Add(k)
+
+		if x, _ := km.Key(id); x != k {
+			t.Errorf("%d: expected key %d, got %d", i, k, x)
+		}
+	}
+
+	if km.Size() != 3 {
+		t.Errorf("expected size 3, got %d", km.Size())
+	}
+
+	if id, ok := km.ID(1<<63 + 1); !ok || id != 2 {
+		t.Errorf("expected id 2, got %d", id)
+	}
+
+	if _, ok := km.ID(8); ok {
+		t.Errorf("expected 8 to be absent")
+	}
+}
+
+func TestWideKeys(t *testing.T) {
+	ix1 := NewIndex(fruit)
+
+	// Keys that would collide if truncated to 32 bits.
+	ix1.Add64(1<<32+1, 1)
+	ix1.Add64(1<<32+1, 3)
+	ix1.Add64(1<<33+1, 3)
+	ix1.Add64(1, 2)
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix1); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	ix2, err := LoadIndex(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	ix3, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ix3.Close()
+
+	for name, ix := range map[string]*Index{"memory": ix1, "loaded": ix2, "mapped": ix3} {
+		if ix.WideKeys == nil {
+			t.Fatalf("%s: expected wide keys", name)
+		}
+
+		r, err := ix.Query([]uint32{3}, nil, nil, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := ix.KeyLabels(r.Items())
+		sort.Strings(got)
+
+		if strings.Join(got, ",") != "4294967297,8589934593" {
+			t.Errorf("%s: expected 4294967297,8589934593, got %s", name, got)
+		}
+
+		ks, err := ix.LookupKeys("1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ix.Has(ks[0], 2) {
+			t.Errorf("%s: expected key 1 to have 2", name)
+		}
+
+		if _, err := ix.LookupKeys("2"); err == nil {
+			t.Errorf("%s: expected lookup error", name)
+		}
+	}
+
+	km, err := LoadWideKeys(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if km == nil || km.Size() != 3 {
+		t.Fatalf("expected 3 keys, got %v", km)
+	}
+
+	buf.Reset()
+
+	if err = DumpIndex(buf, newPostingsIndex()); err != nil {
+		t.Fatal(err)
+	}
+
+	if km, err = LoadWideKeys(buf); err != nil || km != nil {
+		t.Errorf("expected no wide keys, got %v, %v", km, err)
+	}
+}
+
+func TestWideKeysMixed(t *testing.T) {
+	ix := NewIndex(nil)
+	ix.Add(1, 10)
+
+	if err := ix.Add64(1<<40, 10); err != ErrNarrowKeys {
+		t.Errorf("expected ErrNarrowKeys, got %v", err)
+	}
+
+	if ix.WideKeys != nil || ix.Size() != 1 {
+		t.Errorf("expected the index to be unchanged")
+	}
+}
+
+func TestCSVWideKeys(t *testing.T) {
+	in := "5000000000,1\n5000000001,2\n705032704,3\n"
+
+	p := NewCSVIndexer(strings.NewReader(in))
+	p.Parse64 = func(row []string) (uint64, uint32, error) {
+		k, err := strconv.ParseUint(row[0], 10, 64)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		m, err := strconv.ParseUint(row[1], 10, 32)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return k, uint32(m), nil
+	}
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 5000000000 and 705032704 are equal in their lower 32 bits.
+	if ix.Size() != 3 {
+		t.Errorf("expected 3 keys, got %d", ix.Size())
+	}
+}
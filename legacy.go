@@ -0,0 +1,137 @@
+package bitindex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The original index format has no header, footer or sections. It is
+// the domain followed by the table, with every integer written as a
+// uvarint padded to 5 bytes:
+//
+//	domain  length, members in bit order
+//	table   length, then for each key the key, the number of bytes
+//	        set and each byte as its position and a single byte value
+//
+// Bit i of the byte at position p is bit p*8+i of the domain.
+
+// Padded length of the integers of the legacy format.
+const legacyIntSize = binary.MaxVarintLen32
+
+func readLegacyInt(r io.Reader, b []byte) (uint32, error) {
+	if _, err := io.ReadFull(r, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, ErrTruncated
+	} else if err != nil {
+		return 0, err
+	}
+
+	v, n := binary.Uvarint(b)
+
+	if n <= 0 || v > math.MaxUint32 {
+		return 0, ErrCorrupt
+	}
+
+	return uint32(v), nil
+}
+
+// LoadLegacyIndex loads an index written in the format that preceded the
+// versioned header, so it can be written again with DumpIndex. The legacy
+// format has no magic bytes or checksum, so LoadIndex rejects it with
+// ErrNotIndex and this function cannot tell it apart from other input
+// that happens to decode.
+func LoadLegacyIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	b := make([]byte, legacyIntSize)
+
+	n, err := readLegacyInt(br, b)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding domain length: %s", err)
+	}
+
+	// Grown as members are read so a corrupt length does not cause a
+	// large allocation.
+	var ms []uint32
+
+	for i := uint32(0); i < n; i++ {
+		m, err := readLegacyInt(br, b)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding domain member at %d: %s", i, err)
+		}
+
+		ms = append(ms, m)
+	}
+
+	ix := NewIndex(ms)
+
+	// Duplicate members.
+	if len(ix.Domain.f) != len(ms) {
+		return nil, ErrCorrupt
+	}
+
+	if n, err = readLegacyInt(br, b); err != nil {
+		return nil, fmt.Errorf("Error decoding table length: %s", err)
+	}
+
+	for i := uint32(0); i < n; i++ {
+		k, err := readLegacyInt(br, b)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding array key: %s", err)
+		}
+
+		l, err := readLegacyInt(br, b)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding array length: %s", err)
+		}
+
+		if _, ok := ix.Table[k]; ok {
+			return nil, ErrCorrupt
+		}
+
+		// Keys without bits are kept.
+		a := NewArray()
+		ix.Table[k] = a
+
+		for j := uint32(0); j < l; j++ {
+			pos, err := readLegacyInt(br, b)
+
+			if err != nil {
+				return nil, fmt.Errorf("Error reading byte position at %d: %s", j, err)
+			}
+
+			c, err := br.ReadByte()
+
+			if err == io.EOF {
+				err = ErrTruncated
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("Error reading byte at %d: %s", j, err)
+			}
+
+			for x := uint32(0); x < 8; x++ {
+				if c&(1<<x) == 0 {
+					continue
+				}
+
+				bit := uint64(pos)*8 + uint64(x)
+
+				if bit >= uint64(len(ms)) {
+					return nil, ErrCorrupt
+				}
+
+				a.Set(uint32(bit))
+			}
+		}
+	}
+
+	ix.Pack()
+
+	return ix, nil
+}
@@ -0,0 +1,107 @@
+package bitindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// legacyBytes encodes the index in the legacy format.
+func legacyBytes(ms []uint32, rows map[uint32]map[uint32]byte) []byte {
+	var buf bytes.Buffer
+
+	put := func(v uint32) {
+		b := make([]byte, legacyIntSize)
+		binary.PutUvarint(b, uint64(v))
+		buf.Write(b)
+	}
+
+	put(uint32(len(ms)))
+
+	for _, m := range ms {
+		put(m)
+	}
+
+	put(uint32(len(rows)))
+
+	for k, a := range rows {
+		put(k)
+		put(uint32(len(a)))
+
+		for p, c := range a {
+			put(p)
+			buf.WriteByte(c)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func TestLoadLegacyIndex(t *testing.T) {
+	ms := make([]uint32, 12)
+
+	for i := range ms {
+		ms[i] = uint32(i + 1)
+	}
+
+	data := legacyBytes(ms, map[uint32]map[uint32]byte{
+		// Bits 0, 2 and 9.
+		100: {0: 0x05, 1: 0x02},
+		101: {1: 0x08},
+		102: {},
+	})
+
+	if _, err := LoadIndex(bytes.NewReader(data)); err != ErrNotIndex {
+		t.Fatalf("expected ErrNotIndex, got %v", err)
+	}
+
+	ix, err := LoadLegacyIndex(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ix.Domain.Size() != 12 || ix.Size() != 3 {
+		t.Fatalf("expected 12 members and 3 keys, got %d and %d", ix.Domain.Size(), ix.Size())
+	}
+
+	keys, err := ix.Any(1, 3, 10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sameKeys(keys, []uint32{100}) {
+		t.Errorf("expected [100], got %v", keys)
+	}
+
+	if !ix.Has(101, 12) {
+		t.Errorf("expected key 101 to have member 12")
+	}
+
+	// The index round trips in the current format.
+	buf := new(bytes.Buffer)
+
+	if err = DumpIndex(buf, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	if ix, err = LoadIndex(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ix.Has(100, 10) || ix.Size() != 3 {
+		t.Errorf("expected the loaded index to match")
+	}
+
+	// A bit beyond the domain.
+	bad := legacyBytes(ms, map[uint32]map[uint32]byte{100: {2: 0x01}})
+
+	if _, err = LoadLegacyIndex(bytes.NewReader(bad)); err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+
+	if _, err = LoadLegacyIndex(bytes.NewReader(data[:len(data)-1])); err == nil {
+		t.Errorf("expected truncated error")
+	}
+}
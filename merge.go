@@ -0,0 +1,270 @@
+package bitindex
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MergeMode determines how the rows of a key present in more than one
+// index are merged.
+type MergeMode int
+
+const (
+	// MergeUnion sets the members of the key in any of the indexes.
+	MergeUnion MergeMode = iota
+
+	// MergeOverride replaces the row of the key with the row in the
+	// last index that has the key.
+	MergeOverride
+)
+
+// merger merges indexes one at a time into an index held in memory.
+type merger struct {
+	mode MergeMode
+	out  *Index
+
+	// Number of indexes merged.
+	n int
+
+	// Set if any of the indexes has postings.
+	postings bool
+}
+
+func newMerger(mode MergeMode) *merger {
+	return &merger{
+		mode: mode,
+		out:  NewIndex(nil),
+	}
+}
+
+// add merges the index. Keys are merged in ascending order so the ids
+// assigned to labels do not depend on the order of the table.
+func (mg *merger) add(ix *Index) error {
+	out := mg.out
+
+	if mg.n == 0 {
+		if ix.KeyDict != nil {
+			out.KeyDict = NewDictionary()
+		}
+
+		if ix.MemberDict != nil {
+			out.MemberDict = NewDictionary()
+		}
+
+		if ix.WideKeys != nil {
+			out.WideKeys = NewKeyMap()
+		}
+	}
+
+	if (ix.KeyDict != nil) != (out.KeyDict != nil) || (ix.MemberDict != nil) != (out.MemberDict != nil) {
+		return fmt.Errorf("Index %d is labeled differently than index 0", mg.n)
+	}
+
+	if (ix.WideKeys != nil) != (out.WideKeys != nil) {
+		return fmt.Errorf("Index %d has a different key width than index 0", mg.n)
+	}
+
+	key := func(k uint32) uint32 {
+		if ix.KeyDict != nil {
+			s, _ := ix.KeyDict.Label(k)
+			return out.KeyDict.Add(s)
+		}
+
+		if ix.WideKeys != nil {
+			k64, _ := ix.WideKeys.Key(k)
+			return out.WideKeys.Add(k64)
+		}
+
+		return k
+	}
+
+	member := func(m uint32) uint32 {
+		if ix.MemberDict != nil {
+			s, _ := ix.MemberDict.Label(m)
+			return out.MemberDict.Add(s)
+		}
+
+		return m
+	}
+
+	// Bit in the index -> bit in the merged index.
+	bits := make([]uint32, len(ix.Domain.Members()))
+
+	for b, m := range ix.Domain.Members() {
+		if _, ok := ix.Domain.dead[uint32(b)]; !ok {
+			bits[b] = out.Domain.Add(member(m))
+		}
+	}
+
+	for _, k := range ix.Keys().Bits() {
+		a := ix.Get(k)
+		k = key(k)
+
+		row, ok := out.Table[k]
+
+		if !ok || mg.mode == MergeOverride {
+			row = NewArray()
+			out.Table[k] = row
+		}
+
+		for _, b := range a.Bits() {
+			row.Set(bits[b])
+		}
+	}
+
+	if ix.Hierarchy != nil {
+		if out.Hierarchy == nil {
+			out.Hierarchy = NewHierarchy()
+		}
+
+		ix.Hierarchy.each(func(p, c uint32) {
+			out.Hierarchy.AddEdge(member(p), member(c))
+		})
+	}
+
+	if ix.Postings != nil {
+		mg.postings = true
+	}
+
+	mg.n++
+
+	return nil
+}
+
+// index packs and returns the merged index.
+func (mg *merger) index() *Index {
+	mg.out.Table.Pack()
+
+	if mg.postings {
+		mg.out.Postings = BuildPostings(mg.out.Table)
+	}
+
+	return mg.out
+}
+
+// MergeIndexes merges the indexes into a new index held in memory. The
+// domains are reconciled by member, so a member may have different bits
+// in each index. If the indexes are labeled, keys and members are
+// reconciled by label and the ids of the merged index may differ from
+// the ids of the inputs. The indexes must either all be labeled or all
+// not be, and likewise for 64-bit keys. Postings are built if any of the
+// indexes has them.
+func MergeIndexes(mode MergeMode, idxs ...*Index) (*Index, error) {
+	mg := newMerger(mode)
+
+	for _, ix := range idxs {
+		if err := mg.add(ix); err != nil {
+			return nil, err
+		}
+
+		if err := ix.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return mg.index(), nil
+}
+
+// IndexParallel builds the indexes of the indexers with up to n
+// goroutines and merges them with MergeUnion. The partial indexes are
+// merged in the order of the indexers as they become available, so the
+// bits of the domain are assigned in the same order as if the inputs
+// were read one after the other. At most n partial indexes are built or
+// waiting to be merged at any time.
+func IndexParallel(n int, ixers ...Indexer) (*Index, error) {
+	if n < 1 {
+		n = 1
+	}
+
+	type result struct {
+		i   int
+		ix  *Index
+		err error
+	}
+
+	var (
+		jobs    = make(chan int)
+		results = make(chan result)
+		sem     = make(chan struct{}, n)
+		quit    = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				ix, err := ixers[i].Index()
+				results <- result{i, ix, err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+
+		for i := range ixers {
+			// A slot is released when the partial index is merged, so a
+			// slow input holds back the ones after it.
+			select {
+			case sem <- struct{}{}:
+			case <-quit:
+				return
+			}
+
+			select {
+			case jobs <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		mg      = newMerger(MergeUnion)
+		pending = make(map[int]*Index)
+		next    int
+		err     error
+	)
+
+	for r := range results {
+		if err != nil {
+			<-sem
+			continue
+		}
+
+		if r.err != nil {
+			err = fmt.Errorf("Error building index %d: %s", r.i, r.err)
+			close(quit)
+			<-sem
+			continue
+		}
+
+		pending[r.i] = r.ix
+
+		// Merge the partial indexes that are next in order.
+		for ix, ok := pending[next]; ok && err == nil; ix, ok = pending[next] {
+			delete(pending, next)
+			next++
+			<-sem
+
+			if err = mg.add(ix); err != nil {
+				close(quit)
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return mg.index(), nil
+}
@@ -0,0 +1,251 @@
+package bitindex
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMergeIndexes(t *testing.T) {
+	// Member 7 has bit 0 in the first index and bit 2 in the second.
+	a := NewIndex(nil)
+	a.Add(1, 7)
+	a.Add(1, 8)
+	a.Add(2, 8)
+
+	b := NewIndex(nil)
+	b.Add(1, 9)
+	b.Add(3, 10)
+	b.Add(1, 7)
+	b.Postings = BuildPostings(b.Table)
+
+	path, cleanup := writeIndex(t, b)
+	defer cleanup()
+
+	mb, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mb.Close()
+
+	tests := []struct {
+		mode MergeMode
+		exp  map[uint32][]uint32
+	}{
+		{MergeUnion, map[uint32][]uint32{1: {7, 8, 9}, 2: {8}, 3: {10}}},
+		{MergeOverride, map[uint32][]uint32{1: {7, 9}, 2: {8}, 3: {10}}},
+	}
+
+	for _, test := range tests {
+		ix, err := MergeIndexes(test.mode, a, mb)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ix.Postings == nil {
+			t.Errorf("mode %d: expected postings", test.mode)
+		}
+
+		if ix.Domain.Size() != 4 || ix.Size() != 3 {
+			t.Errorf("mode %d: expected 4 members and 3 keys, got %d and %d", test.mode, ix.Domain.Size(), ix.Size())
+		}
+
+		for k, ms := range test.exp {
+			var got []uint32
+
+			for _, b := range ix.Get(k).Bits() {
+				got = append(got, ix.Domain.Member(b))
+			}
+
+			if !sameKeys(got, ms) {
+				t.Errorf("mode %d: key %d: expected %v, got %v", test.mode, k, ms, got)
+			}
+		}
+
+		keys, err := ix.Any(7)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sameKeys(keys, []uint32{1}) {
+			t.Errorf("mode %d: expected [1], got %v", test.mode, keys)
+		}
+	}
+}
+
+func TestMergeLabeledIndexes(t *testing.T) {
+	a := newLabeledIndex(t)
+
+	p := NewCSVIndexer(strings.NewReader("mrn,code\nD400,K21\nA100,K21\n"))
+	p.Header = true
+	p.ParseLabels = func(row []string) (string, string, error) {
+		return row[0], row[1], nil
+	}
+
+	b, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := MergeIndexes(MergeUnion, a, b)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := ix.LookupMembers("K21")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ix.Any(ms...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameLabels(t, "any(K21)", ix.KeyLabels(keys), []string{"A100", "D400"})
+
+	if ms, err = ix.LookupMembers("I10"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err = ix.Any(ms...); err != nil {
+		t.Fatal(err)
+	}
+
+	sameLabels(t, "any(I10)", ix.KeyLabels(keys), []string{"A100"})
+
+	if _, err = MergeIndexes(MergeUnion, a, NewIndex(fruit)); err == nil {
+		t.Error("expected error merging labeled and unlabeled indexes")
+	}
+}
+
+func TestIndexParallel(t *testing.T) {
+	parts := []string{
+		"A100,J45\nB200,I10\n",
+		"C300,E11.9\nA100,I10\n",
+		"D400,K21\nB200,J45\nD400,E11.9\n",
+	}
+
+	csv := func(s string) *CSVIndexer {
+		p := NewCSVIndexer(strings.NewReader(s))
+		p.ParseLabels = func(row []string) (string, string, error) {
+			return row[0], row[1], nil
+		}
+
+		return p
+	}
+
+	exp, err := csv(strings.Join(parts, "")).Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= len(parts); n++ {
+		ixers := make([]Indexer, len(parts))
+
+		for i, s := range parts {
+			ixers[i] = csv(s)
+		}
+
+		ix, err := IndexParallel(n, ixers...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Bits and ids are assigned as if the inputs were concatenated.
+		if fmt.Sprint(ix.Domain.Members()) != fmt.Sprint(exp.Domain.Members()) {
+			t.Errorf("n=%d: expected members %v, got %v", n, exp.Domain.Members(), ix.Domain.Members())
+		}
+
+		for k, a := range exp.Table {
+			el, _ := exp.KeyDict.Label(k)
+			gl, _ := ix.KeyDict.Label(k)
+
+			if el != gl {
+				t.Errorf("n=%d: key %d: expected %s, got %s", n, k, el, gl)
+			}
+
+			if !sameKeys(ix.Get(k).Bits(), a.Bits()) {
+				t.Errorf("n=%d: key %s: expected %v, got %v", n, el, a.Bits(), ix.Get(k).Bits())
+			}
+		}
+	}
+
+	bad := csv(parts[1])
+	bad.ParseLabels = func(row []string) (string, string, error) {
+		return "", "", fmt.Errorf("bad row")
+	}
+
+	if _, err = IndexParallel(2, csv(parts[0]), bad, csv(parts[2])); err == nil {
+		t.Error("expected error from a failing input")
+	}
+}
+
+// countIndexer counts the inputs that have been read and optionally
+// waits for a signal before returning its index.
+type countIndexer struct {
+	n    *int32
+	wait chan struct{}
+}
+
+func (c *countIndexer) Index() (*Index, error) {
+	atomic.AddInt32(c.n, 1)
+
+	if c.wait != nil {
+		<-c.wait
+	}
+
+	ix := NewIndex(nil)
+	ix.Add(1, 1)
+
+	return ix, nil
+}
+
+func TestIndexParallelBounded(t *testing.T) {
+	var n int32
+
+	wait := make(chan struct{})
+
+	// The first input is slow, so the others finish first and wait to
+	// be merged.
+	ixers := []Indexer{&countIndexer{&n, wait}}
+
+	for i := 0; i < 20; i++ {
+		ixers = append(ixers, &countIndexer{&n, nil})
+	}
+
+	done := make(chan error)
+
+	go func() {
+		_, err := IndexParallel(3, ixers...)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if c := atomic.LoadInt32(&n); c > 3 {
+		t.Errorf("expected at most 3 inputs read before the first is merged, got %d", c)
+	}
+
+	close(wait)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if c := atomic.LoadInt32(&n); c != 21 {
+		t.Errorf("expected 21 inputs read, got %d", c)
+	}
+}
@@ -0,0 +1,313 @@
+package bitindex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrClosed is returned by the operations of an index opened with
+// OpenIndex once it is closed.
+var ErrClosed = errors.New("Index is closed")
+
+// mapping is an index file mapped into memory. Arrays and posting lists
+// are decoded from it on demand.
+type mapping struct {
+	data []byte
+	l    *layout
+
+	// Set of all keys, built on first use.
+	once sync.Once
+	keys *Bitmap
+
+	// First error decoding an array or posting list.
+	mu  sync.Mutex
+	err error
+}
+
+// fail records a decode error. The checksum is checked on the first
+// error so that a damaged file is reported as ErrChecksum rather than
+// as the symptom.
+func (m *mapping) fail() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return
+	}
+
+	if _, err := verify(m.data); err != nil {
+		m.err = err
+	} else {
+		m.err = ErrCorrupt
+	}
+}
+
+// Err returns the first decode error.
+func (m *mapping) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+// close records ErrClosed and drops the sections located in the data, so
+// that nothing reads the data once it is released. Arrays, posting lists,
+// labels, keys and edges read afterwards are empty. It returns the data.
+func (m *mapping) close() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, l := m.data, m.l
+
+	m.data = nil
+	m.err = ErrClosed
+
+	// The stats were decoded when the index was opened.
+	m.l = &layout{header: l.header, stats: l.stats}
+
+	// Keys built before are dropped too.
+	m.once.Do(func() {})
+	m.keys = NewBitmap()
+
+	// The dictionaries, key map and hierarchy of the index read the
+	// same sections.
+	for _, d := range []*dictLayout{l.keyDict, l.memberDict} {
+		if d != nil {
+			*d = dictLayout{}
+		}
+	}
+
+	if l.wideKeys != nil {
+		*l.wideKeys = keyMapLayout{}
+	}
+
+	if l.hierarchy != nil {
+		*l.hierarchy = hierarchyLayout{}
+	}
+
+	return data
+}
+
+// closed returns true if the mapping was closed.
+func (m *mapping) closed() bool {
+	return m.Err() == ErrClosed
+}
+
+// Keys returns the bitmap of all keys.
+func (m *mapping) Keys() *Bitmap {
+	m.once.Do(func() {
+		m.keys = NewBitmap()
+
+		for i, n := 0, m.l.size(); i < n; i++ {
+			m.keys.Set(m.l.key(i))
+		}
+
+		m.keys.RunOptimize()
+	})
+
+	return m.keys
+}
+
+// row decodes the i-th array. The layout is checked when the file is
+// opened, but the checksum is not, so a corrupt array is recorded as
+// an error and decoded as empty.
+func (m *mapping) row(i int) Array {
+	bm, err := m.l.row(i)
+
+	if err != nil {
+		m.fail()
+		return NewBitmap()
+	}
+
+	return bm
+}
+
+func (m *mapping) get(k uint32) Array {
+	i, ok := m.l.find(k)
+
+	if !ok {
+		return nil
+	}
+
+	return m.row(i)
+}
+
+func (m *mapping) each(f func(k uint32, a Array)) {
+	for i, n := 0, m.l.size(); i < n; i++ {
+		f(m.l.key(i), m.row(i))
+	}
+}
+
+func (m *mapping) posting(b uint32) *Bitmap {
+	if int(b) >= m.l.nbits() {
+		return NewBitmap()
+	}
+
+	bm, err := m.l.posting(int(b))
+
+	if err != nil {
+		m.fail()
+		return NewBitmap()
+	}
+
+	return bm
+}
+
+// OpenIndex opens an index file by mapping it into memory rather than
+// reading and decoding it. Only the domain is decoded up front. Arrays
+// and posting lists are decoded when an operation touches them, so
+// large indexes are available immediately and only the pages that are
+// used are read.
+//
+// The header and the length in the footer are validated, but the checksum
+// is not since that requires reading the whole file. Use Verify to check
+// it. An array or posting list that cannot be decoded is returned as an
+// error by the queries and by Err. The index is read-only and must be
+// closed to release the mapping.
+//
+// The sections of a compressed index cannot be used in place, so they are
+// decompressed into memory when the index is opened.
+func OpenIndex(path string) (*Index, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is validated before mapping so that arbitrary files
+	// are rejected with the usual errors.
+	if _, err = readHeader(f); err != nil {
+		return nil, err
+	}
+
+	data, err := mmapFile(f, fi.Size())
+
+	if err != nil {
+		return nil, fmt.Errorf("Error mapping index: %s", err)
+	}
+
+	ix, err := openMapped(data)
+
+	if err != nil {
+		munmapFile(data)
+		return nil, err
+	}
+
+	return ix, nil
+}
+
+// openMapped initializes an index on top of the mapped data.
+func openMapped(data []byte) (*Index, error) {
+	ab, err := trimFooter(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := parseLayout(ab)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mapping{
+		data: data,
+		l:    l,
+	}
+
+	ix := &Index{
+		Domain: l.domain(),
+		Table:  make(Table),
+		m:      m,
+	}
+
+	if l.Flags&flagPostings != 0 {
+		ix.Postings = &Postings{m: m}
+	}
+
+	if l.keyDict != nil {
+		ix.KeyDict = &Dictionary{m: l.keyDict}
+	}
+
+	if l.memberDict != nil {
+		ix.MemberDict = &Dictionary{m: l.memberDict}
+	}
+
+	if l.wideKeys != nil {
+		ix.WideKeys = &KeyMap{m: l.wideKeys}
+	}
+
+	if l.hierarchy != nil {
+		ix.Hierarchy = &Hierarchy{m: l.hierarchy}
+	}
+
+	return ix, nil
+}
+
+// Verify checks the checksum of an index opened with OpenIndex. It reads
+// the whole file. Indexes loaded with LoadIndex are verified on load.
+func (ix *Index) Verify() error {
+	if ix.m == nil {
+		return nil
+	}
+
+	if ix.m.closed() {
+		return ErrClosed
+	}
+
+	_, err := verify(ix.m.data)
+
+	return err
+}
+
+// Err returns the error of the first array or posting list of an index
+// opened with OpenIndex that could not be decoded, ErrChecksum if the
+// file is damaged or ErrCorrupt otherwise, and ErrClosed once the index
+// is closed. Get, Has and Each treat such arrays as empty, so callers
+// should check Err after using them. The query methods return the error
+// themselves.
+func (ix *Index) Err() error {
+	if ix.m == nil {
+		return nil
+	}
+
+	return ix.m.Err()
+}
+
+// writable returns ErrReadOnly if the index was opened with OpenIndex,
+// or ErrClosed once it is closed.
+func (ix *Index) writable() error {
+	if ix.m == nil {
+		return nil
+	}
+
+	if ix.m.closed() {
+		return ErrClosed
+	}
+
+	return ErrReadOnly
+}
+
+// Close releases the mapping of an index opened with OpenIndex. The index
+// cannot be used afterwards: its operations return ErrClosed, directly or
+// through Err, and it cannot be modified. Its postings, dictionaries, key
+// map and hierarchy read as empty, since they were read from the mapping.
+// Arrays and labels obtained before are copies and remain valid. Close
+// must not be called while the index is in use.
+func (ix *Index) Close() error {
+	if ix.m == nil || ix.m.closed() {
+		return nil
+	}
+
+	return munmapFile(ix.m.close())
+}
@@ -0,0 +1,382 @@
+package bitindex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeIndex dumps the index to a file in a temporary directory.
+func writeIndex(t *testing.T, ix *Index) (string, func()) {
+	dir, err := ioutil.TempDir("", "bitindex")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "index.bitx")
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = DumpIndex(f, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestOpenIndex(t *testing.T) {
+	ix1 := NewIndex(fruit)
+
+	for k, s := range pairs {
+		for _, b := range s {
+			ix1.Add(k, b)
+		}
+	}
+
+	for _, postings := range []bool{false, true} {
+		if postings {
+			ix1.Postings = BuildPostings(ix1.Table)
+		}
+
+		path, cleanup := writeIndex(t, ix1)
+		defer cleanup()
+
+		ix2, err := OpenIndex(path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (ix2.Postings != nil) != postings {
+			t.Errorf("postings %v: postings not opened", postings)
+		}
+
+		if ix2.Size() != 3 {
+			t.Errorf("postings %v: expected 3 keys, got %d", postings, ix2.Size())
+		}
+
+		if err = ix2.Verify(); err != nil {
+			t.Errorf("postings %v: %s", postings, err)
+		}
+
+		for k, ms := range pairs {
+			for _, m := range ms {
+				if !ix2.Has(k, m) {
+					t.Errorf("postings %v: key %d should have member %d", postings, k, m)
+				}
+			}
+		}
+
+		if ix2.Get(200) != nil || ix2.Has(200, 1) {
+			t.Errorf("postings %v: expected key 200 to be absent", postings)
+		}
+
+		r, err := ix2.Query([]uint32{1, 2}, nil, nil, []uint32{4, 2})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sameKeys(r.Items(), []uint32{100}) {
+			t.Errorf("postings %v: expected [100], got %v", postings, r.Items())
+		}
+
+		if !sameKeys(r.Complement(), []uint32{101, 102}) {
+			t.Errorf("postings %v: expected complement [101, 102], got %v", postings, r.Complement())
+		}
+
+		if err = ix2.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestOpenIndexErrors(t *testing.T) {
+	path, cleanup := writeIndex(t, newPostingsIndex())
+	defer cleanup()
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncated.
+	if err = ioutil.WriteFile(path, data[:len(data)-1], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = OpenIndex(path); err != ErrTruncated {
+		t.Errorf("expected ErrTruncated, got %v", err)
+	}
+
+	// Not an index.
+	if err = ioutil.WriteFile(path, []byte("person,fruit\n100,1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = OpenIndex(path); err != ErrNotIndex {
+		t.Errorf("expected ErrNotIndex, got %v", err)
+	}
+
+	// A corrupt byte is only detected by Verify.
+	bad := append([]byte(nil), data...)
+	bad[len(bad)-footerSize-1] ^= 0xff
+
+	if err = ioutil.WriteFile(path, bad, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ix.Close()
+
+	if err = ix.Verify(); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
+
+func TestOpenIndexReadOnly(t *testing.T) {
+	ix1 := NewIndex(fruit)
+	ix1.Add(1, fruit[0])
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	ix2, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ix2.Close()
+
+	if err = ix2.Add(2, fruit[0]); err != ErrReadOnly {
+		t.Errorf("add: expected ErrReadOnly, got %v", err)
+	}
+
+	if err = ix2.Add64(2, fruit[0]); err != ErrReadOnly {
+		t.Errorf("add64: expected ErrReadOnly, got %v", err)
+	}
+
+	if _, err = ix2.Remove(1, fruit[0]); err != ErrReadOnly {
+		t.Errorf("remove: expected ErrReadOnly, got %v", err)
+	}
+
+	if _, err = ix2.Delete(1); err != ErrReadOnly {
+		t.Errorf("delete: expected ErrReadOnly, got %v", err)
+	}
+
+	if _, err = ix2.Retire(fruit[0]); err != ErrReadOnly {
+		t.Errorf("retire: expected ErrReadOnly, got %v", err)
+	}
+
+	if err = ix2.Compact(); err != ErrReadOnly {
+		t.Errorf("compact: expected ErrReadOnly, got %v", err)
+	}
+
+	if err = ix2.Canonicalize(true); err != ErrReadOnly {
+		t.Errorf("canonicalize: expected ErrReadOnly, got %v", err)
+	}
+
+	// The index is unchanged.
+	if !ix2.Has(1, fruit[0]) || ix2.Has(2, fruit[0]) {
+		t.Errorf("expected the index to be unchanged")
+	}
+}
+
+func TestOpenIndexCorruptArrays(t *testing.T) {
+	ix1 := NewIndex(fruit)
+
+	for k, s := range pairs {
+		for _, b := range s {
+			ix1.Add(k, b)
+		}
+	}
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	ix2, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Locate the arrays in the file.
+	off := cap(ix2.m.data) - cap(ix2.m.l.rows)
+	n := len(ix2.m.l.rows)
+
+	ix2.Close()
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := off; i < off+n; i++ {
+		data[i] = 0xff
+	}
+
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix2, err = OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ix2.Close()
+
+	if _, err = ix2.Any(1); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	if _, err = ix2.Count([]uint32{1}, nil, nil, nil); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	if err = DumpIndex(ioutil.Discard, ix2); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	if a := ix2.Get(100); a == nil || len(a.Bits()) != 0 {
+		t.Errorf("expected an empty array")
+	}
+
+	if err = ix2.Err(); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
+
+func TestCloseIndex(t *testing.T) {
+	ix1 := newHierarchyIndex()
+	ix1.Postings = BuildPostings(ix1.Table)
+	ix1.MemberDict = NewDictionary()
+	ix1.MemberDict.Add("250")
+
+	path, cleanup := writeIndex(t, ix1)
+	defer cleanup()
+
+	ix2, err := OpenIndex(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys and labels obtained before remain valid.
+	keys := ix2.Keys()
+	a := ix2.Get(1)
+	l, _ := ix2.MemberDict.Label(0)
+
+	p, d, h := ix2.Postings, ix2.MemberDict, ix2.Hierarchy
+
+	if err = ix2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ix2.Close(); err != nil {
+		t.Errorf("expected a second close to succeed, got %v", err)
+	}
+
+	if keys.Cardinality() != 4 || !a.Has(0) || l != "250" {
+		t.Errorf("expected the keys and labels obtained before to remain valid")
+	}
+
+	if err = ix2.Err(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	if err = ix2.Verify(); err != ErrClosed {
+		t.Errorf("verify: expected ErrClosed, got %v", err)
+	}
+
+	// The operations fail rather than read the released mapping.
+	ops := map[string]func(...uint32) ([]uint32, error){
+		"any":  ix2.Any,
+		"all":  ix2.All,
+		"nany": ix2.NotAny,
+		"nall": ix2.NotAll,
+		"atleast": func(ms ...uint32) ([]uint32, error) {
+			return ix2.AtLeast(1, ms...)
+		},
+		"atmost": func(ms ...uint32) ([]uint32, error) {
+			return ix2.AtMost(1, ms...)
+		},
+	}
+
+	for name, op := range ops {
+		if _, err := op(401); err != ErrClosed {
+			t.Errorf("%s: expected ErrClosed, got %v", name, err)
+		}
+	}
+
+	if _, err = ix2.Query([]uint32{401}, nil, []uint32{25001}, nil); err == nil {
+		t.Errorf("query: expected an error")
+	}
+
+	if _, err = ix2.Count(nil, nil, []uint32{401}, nil); err == nil {
+		t.Errorf("count: expected an error")
+	}
+
+	e := &OrExpr{
+		X: &OpExpr{Op: "any", Members: []uint32{401}},
+		Y: &NotExpr{X: &OpExpr{Op: "all", Members: []uint32{25001}}},
+	}
+
+	if _, err = ix2.QueryExpr(e); err == nil {
+		t.Errorf("expr: expected an error")
+	}
+
+	if _, err = ix2.CountExpr(e); err == nil {
+		t.Errorf("count expr: expected an error")
+	}
+
+	if err = DumpIndex(bytes.NewBuffer(nil), ix2); err != ErrClosed {
+		t.Errorf("dump: expected ErrClosed, got %v", err)
+	}
+
+	// Nothing reads the released mapping.
+	if ix2.Keys().Cardinality() != 0 || ix2.Get(1) != nil || p.Get(0).Cardinality() != 0 {
+		t.Errorf("expected no keys, arrays or posting lists")
+	}
+
+	if d.Size() != 0 || h.Size() != 0 {
+		t.Errorf("expected no labels or edges")
+	}
+
+	if _, ok := d.Label(0); ok {
+		t.Errorf("expected no labels")
+	}
+
+	// The closed index cannot be built again.
+	if err = ix2.Add(5, 401); err != ErrClosed {
+		t.Errorf("add: expected ErrClosed, got %v", err)
+	}
+
+	if err = ix2.Add64(1<<40, 401); err != ErrClosed {
+		t.Errorf("add64: expected ErrClosed, got %v", err)
+	}
+
+	if _, err = ix2.Delete(1); err != ErrClosed {
+		t.Errorf("delete: expected ErrClosed, got %v", err)
+	}
+}
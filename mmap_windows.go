@@ -0,0 +1,21 @@
+package bitindex
+
+import (
+	"io"
+	"os"
+)
+
+// Files are read into memory rather than mapped on Windows.
+func mmapFile(f *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+
+	if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,474 @@
+package bitindex
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// ParquetIndexer is an indexer for Apache Parquet files. The key and
+// member are read from the columns at KeyColumn and MemberColumn. The
+// member column may be repeated, such as a list of codes, in which case
+// the key is indexed with each of its members. Rows with a null key or
+// member are skipped.
+type ParquetIndexer struct {
+	r    io.ReaderAt
+	size int64
+
+	// Paths of the key and member columns. Columns of nested groups are
+	// separated by dots, e.g. "patient.id".
+	KeyColumn    string
+	MemberColumn string
+
+	// Index of the row group to read or -1 to read all of them. Each row
+	// group can be read by its own indexer to index a file in parallel.
+	RowGroup int
+
+	// If true, the postings are built along with the table.
+	Postings bool
+
+	// If true, keys and members are labels encoded with the dictionaries.
+	// String and integer columns are both accepted as labels.
+	Labels bool
+
+	// If true, keys are 64-bit integers mapped to ids with WideKeys.
+	Keys64 bool
+
+	// Dictionaries of the key and member labels. They are initialized
+	// when Labels is set.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+
+	// Map of the 64-bit keys. It is initialized when Keys64 is set.
+	WideKeys *KeyMap
+}
+
+// NewParquetIndexer initializes a new Parquet reader of all of the row
+// groups of the file for building an index.
+func NewParquetIndexer(r io.ReaderAt, size int64) *ParquetIndexer {
+	return &ParquetIndexer{
+		r:            r,
+		size:         size,
+		KeyColumn:    "key",
+		MemberColumn: "member",
+		RowGroup:     -1,
+	}
+}
+
+// ParquetRowGroups returns the number of row groups of the Parquet file.
+func ParquetRowGroups(r io.ReaderAt, size int64) (int, error) {
+	f, err := parquet.OpenFile(r, size)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(f.RowGroups()), nil
+}
+
+// parquetColumn is a leaf column of a Parquet file.
+type parquetColumn struct {
+	parquet.LeafColumn
+
+	// Set if the column is an unsigned integer.
+	unsigned bool
+}
+
+func lookupColumn(f *parquet.File, path string) (*parquetColumn, error) {
+	leaf, ok := f.Schema().Lookup(strings.Split(path, ".")...)
+
+	if !ok {
+		return nil, fmt.Errorf("No column %s", path)
+	}
+
+	c := &parquetColumn{LeafColumn: leaf}
+
+	if lt := leaf.Node.Type().LogicalType(); lt != nil {
+		if it, ok := lt.Value.(*format.IntType); ok {
+			c.unsigned = !it.IsSigned
+		}
+	}
+
+	return c, nil
+}
+
+// label returns the value of a string or integer column as a label.
+func (c *parquetColumn) label(v parquet.Value) (string, error) {
+	switch v.Kind() {
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		return string(v.ByteArray()), nil
+
+	case parquet.Int32:
+		if c.unsigned {
+			return strconv.FormatUint(uint64(v.Uint32()), 10), nil
+		}
+
+		return strconv.FormatInt(int64(v.Int32()), 10), nil
+
+	case parquet.Int64:
+		if c.unsigned {
+			return strconv.FormatUint(v.Uint64(), 10), nil
+		}
+
+		return strconv.FormatInt(v.Int64(), 10), nil
+	}
+
+	return "", fmt.Errorf("Column %s: Expected a string or integer, got %s", strings.Join(c.Path, "."), v.Kind())
+}
+
+// uint returns the value of a string or integer column as an integer of
+// the bit size. Values out of range are errors rather than truncated.
+func (c *parquetColumn) uint(v parquet.Value, bits int) (uint64, error) {
+	s, err := c.label(v)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(s, 10, bits)
+}
+
+// columnValues reads the values of a column chunk page by page.
+type columnValues struct {
+	pages  parquet.Pages
+	values parquet.ValueReader
+	buf    []parquet.Value
+	i, n   int
+}
+
+func newColumnValues(c parquet.ColumnChunk) *columnValues {
+	return &columnValues{
+		pages: c.Pages(),
+		buf:   make([]parquet.Value, 1024),
+	}
+}
+
+// next returns the next value or io.EOF.
+func (c *columnValues) next() (parquet.Value, error) {
+	for c.i == c.n {
+		if c.values == nil {
+			page, err := c.pages.ReadPage()
+
+			if err != nil {
+				return parquet.Value{}, err
+			}
+
+			c.values = page.Values()
+		}
+
+		n, err := c.values.ReadValues(c.buf)
+
+		if err == io.EOF {
+			c.values = nil
+		} else if err != nil {
+			return parquet.Value{}, err
+		}
+
+		c.i, c.n = 0, n
+	}
+
+	v := c.buf[c.i]
+	c.i++
+
+	return v, nil
+}
+
+func (c *columnValues) Close() error {
+	return c.pages.Close()
+}
+
+// pair returns the key and member of the values.
+func (p *ParquetIndexer) pair(kc, mc *parquetColumn, kv, mv parquet.Value) (uint32, uint32, error) {
+	if p.Labels {
+		kl, err := kc.label(kv)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		ml, err := mc.label(mv)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return p.KeyDict.Add(kl), p.MemberDict.Add(ml), nil
+	}
+
+	var k uint32
+
+	if p.Keys64 {
+		k64, err := kc.uint(kv, 64)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		k = p.WideKeys.Add(k64)
+	} else {
+		k32, err := kc.uint(kv, 32)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		k = uint32(k32)
+	}
+
+	m, err := mc.uint(mv, 32)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return k, uint32(m), nil
+}
+
+// streamRowGroup calls f for each key/member pair in the row group. The
+// member values are read along with the key of their row, which starts
+// at each value with a repetition level of zero.
+func (p *ParquetIndexer) streamRowGroup(rg parquet.RowGroup, kc, mc *parquetColumn, f func(k uint32, m uint32) error) error {
+	chunks := rg.ColumnChunks()
+
+	keys := newColumnValues(chunks[kc.ColumnIndex])
+	defer keys.Close()
+
+	members := newColumnValues(chunks[mc.ColumnIndex])
+	defer members.Close()
+
+	var kv parquet.Value
+
+	for n := 0; ; {
+		mv, err := members.next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if mv.RepetitionLevel() == 0 {
+			if kv, err = keys.next(); err == io.EOF {
+				return fmt.Errorf("Row %d: Missing key", n)
+			} else if err != nil {
+				return err
+			}
+
+			n++
+		}
+
+		if kv.IsNull() || mv.IsNull() {
+			continue
+		}
+
+		k, m, err := p.pair(kc, mc, kv, mv)
+
+		if err != nil {
+			return fmt.Errorf("Row %d: %s", n, err)
+		}
+
+		if err = f(k, m); err != nil {
+			return err
+		}
+	}
+}
+
+// Stream implements the Streamer interface and calls f for each
+// key/member pair in the row groups.
+func (p *ParquetIndexer) Stream(f func(k uint32, m uint32) error) error {
+	if p.Labels && p.KeyDict == nil {
+		p.KeyDict = NewDictionary()
+		p.MemberDict = NewDictionary()
+	}
+
+	if p.Keys64 && p.WideKeys == nil {
+		p.WideKeys = NewKeyMap()
+	}
+
+	file, err := parquet.OpenFile(p.r, p.size)
+
+	if err != nil {
+		return err
+	}
+
+	kc, err := lookupColumn(file, p.KeyColumn)
+
+	if err != nil {
+		return err
+	}
+
+	if kc.MaxRepetitionLevel > 0 {
+		return fmt.Errorf("Key column %s must not be repeated", p.KeyColumn)
+	}
+
+	mc, err := lookupColumn(file, p.MemberColumn)
+
+	if err != nil {
+		return err
+	}
+
+	groups := file.RowGroups()
+
+	if p.RowGroup >= 0 {
+		if p.RowGroup >= len(groups) {
+			return fmt.Errorf("No row group %d, the file has %d", p.RowGroup, len(groups))
+		}
+
+		groups = groups[p.RowGroup : p.RowGroup+1]
+	}
+
+	for _, rg := range groups {
+		if err = p.streamRowGroup(rg, kc, mc, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Index implements the Indexer interface and builds an index from the
+// Parquet file.
+func (p *ParquetIndexer) Index() (*Index, error) {
+	ix := NewIndex(nil)
+
+	if p.Postings {
+		ix.Postings = NewPostings()
+	}
+
+	err := p.Stream(ix.Add)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ix.KeyDict = p.KeyDict
+	ix.MemberDict = p.MemberDict
+	ix.WideKeys = p.WideKeys
+
+	ix.Pack()
+
+	return ix, nil
+}
+
+// eachPair calls f for each key/member pair of the index. Keys are
+// visited in ascending order and their members in bit order.
+func eachPair(ix *Index, f func(k uint32, m uint32) error) error {
+	for _, k := range ix.Keys().Bits() {
+		a := ix.Get(k)
+
+		if err := ix.Err(); err != nil {
+			return err
+		}
+
+		for _, b := range a.Bits() {
+			if err := f(k, ix.Domain.Member(b)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DumpParquet writes the key/member pairs of the index as a Parquet file
+// with the key and member columns. Labels and 64-bit keys are written in
+// place of their ids. A row group is written every rowGroupSize pairs,
+// or a single one if it is zero.
+func DumpParquet(w io.Writer, ix *Index, keyColumn, memberColumn string, rowGroupSize int) error {
+	if keyColumn == memberColumn {
+		return fmt.Errorf("The key and member columns must have different names")
+	}
+
+	key, member := parquet.Uint(32), parquet.Uint(32)
+
+	switch {
+	case ix.KeyDict != nil:
+		key = parquet.String()
+
+	case ix.WideKeys != nil:
+		key = parquet.Uint(64)
+	}
+
+	if ix.MemberDict != nil {
+		member = parquet.String()
+	}
+
+	schema := parquet.NewSchema("pairs", parquet.Group{
+		keyColumn:    key,
+		memberColumn: member,
+	})
+
+	// Columns are ordered by name in the schema.
+	kc, _ := schema.Lookup(keyColumn)
+	mc, _ := schema.Lookup(memberColumn)
+
+	pw := parquet.NewWriter(w, schema)
+
+	value := func(id uint32, d *Dictionary, km *KeyMap) parquet.Value {
+		switch {
+		case d != nil:
+			s, _ := d.Label(id)
+			return parquet.ByteArrayValue([]byte(s))
+
+		case km != nil:
+			k, _ := km.Key(id)
+			return parquet.Int64Value(int64(k))
+		}
+
+		return parquet.Int32Value(int32(id))
+	}
+
+	rows := make([]parquet.Row, 0, 1024)
+	var n int
+
+	flush := func() error {
+		if _, err := pw.WriteRows(rows); err != nil {
+			return err
+		}
+
+		rows = rows[:0]
+		return nil
+	}
+
+	err := eachPair(ix, func(k uint32, m uint32) error {
+		row := make(parquet.Row, 2)
+		row[kc.ColumnIndex] = value(k, ix.KeyDict, ix.WideKeys).Level(0, 0, kc.ColumnIndex)
+		row[mc.ColumnIndex] = value(m, ix.MemberDict, nil).Level(0, 0, mc.ColumnIndex)
+
+		rows = append(rows, row)
+		n++
+
+		if len(rows) == cap(rows) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if rowGroupSize > 0 && n%rowGroupSize == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+
+			return pw.Flush()
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = flush()
+	}
+
+	if err != nil {
+		pw.Close()
+		return fmt.Errorf("Error writing parquet: %s", err)
+	}
+
+	return pw.Close()
+}
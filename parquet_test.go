@@ -0,0 +1,209 @@
+package bitindex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	exp := newLabeledIndex(t)
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpParquet(buf, exp, "mrn", "code", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(buf.Bytes())
+
+	n, err := ParquetRowGroups(r, r.Size())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 6 pairs, 2 per row group.
+	if n != 3 {
+		t.Errorf("expected 3 row groups, got %d", n)
+	}
+
+	ixers := make([]Indexer, n)
+
+	for i := range ixers {
+		p := NewParquetIndexer(r, r.Size())
+		p.KeyColumn = "mrn"
+		p.MemberColumn = "code"
+		p.RowGroup = i
+		p.Labels = true
+
+		ixers[i] = p
+	}
+
+	ix, err := IndexParallel(2, ixers...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range exp.Keys().Bits() {
+		kl := exp.KeyLabels([]uint32{k})
+		ks, err := ix.LookupKeys(kl...)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sameLabels(t, kl[0], ix.MemberLabels(members(ix, ks[0])), exp.MemberLabels(members(exp, k)))
+	}
+}
+
+// members returns the members of the key.
+func members(ix *Index, k uint32) []uint32 {
+	var ms []uint32
+
+	for _, b := range ix.Get(k).Bits() {
+		ms = append(ms, ix.Domain.Member(b))
+	}
+
+	return ms
+}
+
+type visitRecord struct {
+	Patient struct {
+		ID int64 `parquet:"id"`
+	} `parquet:"patient"`
+	Codes []int32 `parquet:"codes"`
+	Dx    *string `parquet:"dx,optional"`
+}
+
+func TestParquetIndexer(t *testing.T) {
+	dx := "I10"
+	recs := []visitRecord{
+		{Codes: []int32{1, 3}, Dx: &dx},
+		{Codes: []int32{4, 9}},
+		{Codes: nil},
+		{Codes: []int32{4, 2, 3}, Dx: &dx},
+	}
+
+	for i, id := range []int64{100, 101, 103, 102} {
+		recs[i].Patient.ID = id
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w := parquet.NewWriter(buf, parquet.SchemaOf(visitRecord{}))
+
+	for _, rec := range recs {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(buf.Bytes())
+
+	p := NewParquetIndexer(r, r.Size())
+	p.KeyColumn = "patient.id"
+	p.MemberColumn = "codes"
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ix.Size() != 3 {
+		t.Errorf("expected 3 keys, got %d", ix.Size())
+	}
+
+	for k, exp := range pairs {
+		if got := members(ix, k); !sameKeys(got, exp) {
+			t.Errorf("key %d: expected %v, got %v", k, exp, got)
+		}
+	}
+
+	// Null members are skipped.
+	p = NewParquetIndexer(r, r.Size())
+	p.KeyColumn = "patient.id"
+	p.MemberColumn = "dx"
+	p.Labels = true
+
+	if ix, err = p.Index(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ix.Any(0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameLabels(t, "any(I10)", ix.KeyLabels(keys), []string{"100", "102"})
+
+	// Labels cannot be parsed as integers.
+	p = NewParquetIndexer(r, r.Size())
+	p.KeyColumn = "patient.id"
+	p.MemberColumn = "dx"
+
+	if _, err = p.Index(); err == nil || !strings.Contains(err.Error(), "Row 1") {
+		t.Errorf("expected error at row 1, got %v", err)
+	}
+
+	p = NewParquetIndexer(r, r.Size())
+	p.KeyColumn = "codes"
+
+	if _, err = p.Index(); err == nil {
+		t.Error("expected error for a repeated key column")
+	}
+
+	p = NewParquetIndexer(r, r.Size())
+
+	if _, err = p.Index(); err == nil || !strings.Contains(err.Error(), "No column key") {
+		t.Errorf("expected missing column error, got %v", err)
+	}
+}
+
+func TestParquetUnsigned(t *testing.T) {
+	exp := NewIndex(nil)
+	exp.Add(4000000000, 3000000000)
+	exp.Add(1, 2)
+
+	wide := NewIndex(nil)
+	wide.Add64(1<<40, 3000000000)
+
+	for _, ix := range []*Index{exp, wide} {
+		buf := bytes.NewBuffer(nil)
+
+		if err := DumpParquet(buf, ix, "key", "member", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		r := bytes.NewReader(buf.Bytes())
+
+		p := NewParquetIndexer(r, r.Size())
+		p.Keys64 = ix.WideKeys != nil
+
+		got, err := p.Index()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ix.WideKeys != nil {
+			if k, _ := got.WideKeys.Key(0); k != 1<<40 || !got.Has(0, 3000000000) {
+				t.Errorf("expected key %d with member 3000000000", uint64(1<<40))
+			}
+
+			continue
+		}
+
+		if !got.Has(4000000000, 3000000000) || !got.Has(1, 2) {
+			t.Error("expected keys 4000000000 and 1")
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package bitindex
+
+// Postings is an inverted index that maps each bit in the domain to
+// the set of keys that have the bit set. Operations on the postings
+// only touch the keys of the bits being tested rather than scanning
+// the whole table.
+type Postings struct {
+	// All keys in the index.
+	keys *Bitmap
+
+	// Bit -> Keys
+	bits []*Bitmap
+
+	// Set if the index was opened with OpenIndex. Posting lists are
+	// decoded from the mapping on demand.
+	m *mapping
+}
+
+// Keys returns the bitmap of all keys.
+func (p *Postings) Keys() *Bitmap {
+	if p.m != nil {
+		return p.m.Keys()
+	}
+
+	return p.keys
+}
+
+// Get returns the keys that have the bit set.
+func (p *Postings) Get(b uint32) *Bitmap {
+	if p.m != nil {
+		return p.m.posting(b)
+	}
+
+	if int(b) >= len(p.bits) || p.bits[b] == nil {
+		return NewBitmap()
+	}
+
+	return p.bits[b]
+}
+
+// Set adds key `k` to the posting list of the bit.
+func (p *Postings) Set(b uint32, k uint32) {
+	if p.m != nil {
+		panic("postings are read-only")
+	}
+
+	if int(b) >= len(p.bits) {
+		bits := make([]*Bitmap, b+1)
+		copy(bits, p.bits)
+		p.bits = bits
+	}
+
+	if p.bits[b] == nil {
+		p.bits[b] = NewBitmap()
+	}
+
+	p.bits[b].Set(k)
+	p.keys.Set(k)
+}
+
+// Size returns the number of posting lists.
+func (p *Postings) Size() int {
+	if p.m != nil {
+		return p.m.l.nbits()
+	}
+
+	return len(p.bits)
+}
+
+// RunOptimize compresses the posting lists.
+func (p *Postings) RunOptimize() {
+	if p.m != nil {
+		return
+	}
+
+	p.keys.RunOptimize()
+
+	for _, b := range p.bits {
+		if b != nil {
+			b.RunOptimize()
+		}
+	}
+}
+
+// Any returns the keys that have any of the bits set.
+func (p *Postings) Any(bs ...uint32) *Bitmap {
+	r := NewBitmap()
+
+	for _, b := range bs {
+		r = r.Or(p.Get(b))
+	}
+
+	return r
+}
+
+// All returns the keys that have all of the bits set.
+func (p *Postings) All(bs ...uint32) *Bitmap {
+	if len(bs) == 0 {
+		return p.Keys().Clone()
+	}
+
+	r := p.Get(bs[0])
+
+	for _, b := range bs[1:] {
+		r = r.And(p.Get(b))
+	}
+
+	return r.Clone()
+}
+
+// NotAny returns the keys that have none of the bits set.
+func (p *Postings) NotAny(bs ...uint32) *Bitmap {
+	return p.Keys().AndNot(p.Any(bs...))
+}
+
+// NotAll returns the keys that do not have all of the bits set.
+func (p *Postings) NotAll(bs ...uint32) *Bitmap {
+	return p.Keys().AndNot(p.All(bs...))
+}
+
+// AtLeast returns the keys that have at least n of the bits set. It keeps
+// a bitmap of keys seen at least j times for each j up to n and carries
+// them forward one posting list at a time.
+func (p *Postings) AtLeast(n int, bs ...uint32) *Bitmap {
+	if n <= 0 {
+		return p.Keys().Clone()
+	}
+
+	// Repeated bits count once.
+	bs = NewBitmap(bs...).Bits()
+
+	if n > len(bs) {
+		return NewBitmap()
+	}
+
+	seen := make([]*Bitmap, n+1)
+
+	for j := range seen {
+		seen[j] = NewBitmap()
+	}
+
+	for _, b := range bs {
+		l := p.Get(b)
+
+		for j := n; j > 1; j-- {
+			seen[j] = seen[j].Or(seen[j-1].And(l))
+		}
+
+		seen[1] = seen[1].Or(l)
+	}
+
+	return seen[n]
+}
+
+// AtMost returns the keys that have at most n of the bits set.
+func (p *Postings) AtMost(n int, bs ...uint32) *Bitmap {
+	return p.Keys().AndNot(p.AtLeast(n+1, bs...))
+}
+
+// NewPostings initializes empty postings.
+func NewPostings() *Postings {
+	return &Postings{
+		keys: NewBitmap(),
+	}
+}
+
+// BuildPostings builds the postings from a table.
+func BuildPostings(t Table) *Postings {
+	p := NewPostings()
+
+	for k, a := range t {
+		for _, b := range a.Bits() {
+			p.Set(b, k)
+		}
+	}
+
+	p.RunOptimize()
+
+	return p
+}
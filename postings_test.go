@@ -0,0 +1,121 @@
+package bitindex
+
+import (
+	"sort"
+	"testing"
+)
+
+func newPostingsIndex() *Index {
+	ix := NewIndex(fruit)
+	ix.Postings = NewPostings()
+
+	for k, s := range pairs {
+		for _, b := range s {
+			ix.Add(k, b)
+		}
+	}
+
+	ix.Pack()
+
+	return ix
+}
+
+func sameKeys(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	x := append(Uint32Array(nil), a...)
+	y := append(Uint32Array(nil), b...)
+
+	sort.Sort(x)
+	sort.Sort(y)
+
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestPostings(t *testing.T) {
+	ix := newPostingsIndex()
+
+	// Bit 3 is Grape.
+	if !sameKeys(ix.Postings.Get(3).Bits(), []uint32{101, 102}) {
+		t.Errorf("expected [101, 102], got %v", ix.Postings.Get(3).Bits())
+	}
+
+	if ix.Postings.Keys().Cardinality() != 3 {
+		t.Errorf("expected 3 keys, got %d", ix.Postings.Keys().Cardinality())
+	}
+
+	// Postings built from the table are equivalent.
+	p := BuildPostings(ix.Table)
+
+	for i := 0; i < ix.Domain.Size(); i++ {
+		if !sameKeys(p.Get(uint32(i)).Bits(), ix.Postings.Get(uint32(i)).Bits()) {
+			t.Errorf("bit %d: postings differ", i)
+		}
+	}
+}
+
+func TestPostingsOperations(t *testing.T) {
+	ix := newPostingsIndex()
+
+	// Same index without postings.
+	sx := &Index{
+		Domain: ix.Domain,
+		Table:  ix.Table,
+	}
+
+	ops := []struct {
+		name string
+		a, b func(...uint32) ([]uint32, error)
+	}{
+		{"any", ix.Any, sx.Any},
+		{"all", ix.All, sx.All},
+		{"nany", ix.NotAny, sx.NotAny},
+		{"nall", ix.NotAll, sx.NotAll},
+	}
+
+	sets := [][]uint32{{1, 2}, {1, 3}, {3, 1}, {4, 2}, {9}}
+
+	for _, op := range ops {
+		for _, ms := range sets {
+			a, err := op.a(ms...)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, _ := op.b(ms...)
+
+			if !sameKeys(a, b) {
+				t.Errorf("%s %v: expected %v, got %v", op.name, ms, b, a)
+			}
+		}
+	}
+
+	r1, err := ix.Query([]uint32{3, 4}, nil, nil, []uint32{1, 3})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r2, _ := sx.Query([]uint32{3, 4}, nil, nil, []uint32{1, 3})
+
+	if !sameKeys(r1.Items(), r2.Items()) {
+		t.Errorf("expected %v, got %v", r2.Items(), r1.Items())
+	}
+
+	if !sameKeys(r1.Complement(), r2.Complement()) {
+		t.Errorf("expected complement %v, got %v", r2.Complement(), r1.Complement())
+	}
+
+	if _, err := ix.Query([]uint32{42}, nil, nil, nil); err == nil {
+		t.Errorf("expected error for unknown member")
+	}
+}
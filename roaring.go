@@ -0,0 +1,907 @@
+package bitindex
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Container types. These values are also used in the binary encoding.
+const (
+	arrayType  byte = 0
+	bitmapType byte = 1
+	runType    byte = 2
+)
+
+const (
+	// Maximum number of values stored in an array container before it
+	// is converted into a bitmap container.
+	arrayMaxSize = 4096
+
+	// Number of 64-bit words in a bitmap container (2^16 bits).
+	bitmapWords = 1024
+)
+
+// container holds the low 16 bits of the values sharing the same high
+// 16 bits in a Bitmap.
+type container interface {
+	// kind returns the container type.
+	kind() byte
+
+	// add adds the value and returns the container that holds the result
+	// which may have a different type.
+	add(v uint16) container
+
+	// remove removes the value and returns the container that holds the
+	// result which may have a different type.
+	remove(v uint16) container
+
+	contains(v uint16) bool
+
+	cardinality() int
+
+	// bytes returns the number of bytes used by the values.
+	bytes() int
+
+	// each calls f for each value in ascending order.
+	each(f func(v uint16))
+
+	// intersects returns true if any bit in the mask words is set.
+	intersects(m []uint64) bool
+
+	// covers returns true if all bits in the mask words are set.
+	covers(m []uint64) bool
+
+	// countMask returns the number of bits in the mask words that are set.
+	countMask(m []uint64) int
+}
+
+// arrayContainer is a sorted list of values.
+type arrayContainer struct {
+	vals []uint16
+}
+
+func (c *arrayContainer) kind() byte {
+	return arrayType
+}
+
+func (c *arrayContainer) search(v uint16) int {
+	return sort.Search(len(c.vals), func(i int) bool {
+		return c.vals[i] >= v
+	})
+}
+
+func (c *arrayContainer) add(v uint16) container {
+	i := c.search(v)
+
+	if i < len(c.vals) && c.vals[i] == v {
+		return c
+	}
+
+	if len(c.vals) >= arrayMaxSize {
+		return toBitmapContainer(c).add(v)
+	}
+
+	c.vals = append(c.vals, 0)
+	copy(c.vals[i+1:], c.vals[i:])
+	c.vals[i] = v
+
+	return c
+}
+
+func (c *arrayContainer) remove(v uint16) container {
+	i := c.search(v)
+
+	if i < len(c.vals) && c.vals[i] == v {
+		c.vals = append(c.vals[:i], c.vals[i+1:]...)
+	}
+
+	return c
+}
+
+func (c *arrayContainer) contains(v uint16) bool {
+	i := c.search(v)
+	return i < len(c.vals) && c.vals[i] == v
+}
+
+func (c *arrayContainer) cardinality() int {
+	return len(c.vals)
+}
+
+func (c *arrayContainer) bytes() int {
+	return len(c.vals) * 2
+}
+
+func (c *arrayContainer) each(f func(v uint16)) {
+	for _, v := range c.vals {
+		f(v)
+	}
+}
+
+func (c *arrayContainer) intersects(m []uint64) bool {
+	for _, v := range c.vals {
+		w := int(v / 64)
+
+		if w >= len(m) {
+			break
+		}
+
+		if m[w]&(1<<(v%64)) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *arrayContainer) covers(m []uint64) bool {
+	return coversValues(c, m)
+}
+
+func (c *arrayContainer) countMask(m []uint64) int {
+	var n int
+
+	for _, v := range c.vals {
+		w := int(v / 64)
+
+		if w >= len(m) {
+			break
+		}
+
+		if m[w]&(1<<(v%64)) != 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// bitmapContainer is a fixed array of 2^16 bits.
+type bitmapContainer struct {
+	words [bitmapWords]uint64
+	card  int
+}
+
+func (c *bitmapContainer) kind() byte {
+	return bitmapType
+}
+
+func (c *bitmapContainer) add(v uint16) container {
+	w, b := v/64, uint64(1)<<(v%64)
+
+	if c.words[w]&b == 0 {
+		c.words[w] |= b
+		c.card++
+	}
+
+	return c
+}
+
+func (c *bitmapContainer) remove(v uint16) container {
+	w, b := v/64, uint64(1)<<(v%64)
+
+	if c.words[w]&b != 0 {
+		c.words[w] &= ^b
+		c.card--
+	}
+
+	if c.card <= arrayMaxSize {
+		return toArrayContainer(c)
+	}
+
+	return c
+}
+
+func (c *bitmapContainer) contains(v uint16) bool {
+	return c.words[v/64]&(1<<(v%64)) != 0
+}
+
+func (c *bitmapContainer) cardinality() int {
+	return c.card
+}
+
+func (c *bitmapContainer) bytes() int {
+	return bitmapWords * 8
+}
+
+func (c *bitmapContainer) each(f func(v uint16)) {
+	for i, w := range c.words {
+		for w != 0 {
+			t := bits.TrailingZeros64(w)
+			f(uint16(i*64 + t))
+			w &= w - 1
+		}
+	}
+}
+
+func (c *bitmapContainer) intersects(m []uint64) bool {
+	for i, w := range m {
+		if c.words[i]&w != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *bitmapContainer) covers(m []uint64) bool {
+	for i, w := range m {
+		if c.words[i]&w != w {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *bitmapContainer) countMask(m []uint64) int {
+	var n int
+
+	for i, w := range m {
+		n += bits.OnesCount64(c.words[i] & w)
+	}
+
+	return n
+}
+
+// interval is an inclusive run of values.
+type interval struct {
+	start uint16
+	last  uint16
+}
+
+// runContainer is a sorted list of non-overlapping runs of values.
+type runContainer struct {
+	runs []interval
+}
+
+func (c *runContainer) kind() byte {
+	return runType
+}
+
+// Runs are only produced by optimization, so modifications fall back
+// to the array or bitmap form.
+func (c *runContainer) add(v uint16) container {
+	if c.contains(v) {
+		return c
+	}
+
+	if c.cardinality() < arrayMaxSize {
+		return toArrayContainer(c).add(v)
+	}
+
+	return toBitmapContainer(c).add(v)
+}
+
+func (c *runContainer) remove(v uint16) container {
+	if !c.contains(v) {
+		return c
+	}
+
+	if c.cardinality() <= arrayMaxSize+1 {
+		return toArrayContainer(c).remove(v)
+	}
+
+	return toBitmapContainer(c).remove(v)
+}
+
+func (c *runContainer) contains(v uint16) bool {
+	i := sort.Search(len(c.runs), func(i int) bool {
+		return c.runs[i].last >= v
+	})
+
+	return i < len(c.runs) && c.runs[i].start <= v
+}
+
+func (c *runContainer) cardinality() int {
+	var n int
+
+	for _, r := range c.runs {
+		n += int(r.last-r.start) + 1
+	}
+
+	return n
+}
+
+func (c *runContainer) bytes() int {
+	return len(c.runs) * 4
+}
+
+func (c *runContainer) each(f func(v uint16)) {
+	for _, r := range c.runs {
+		for v := int(r.start); v <= int(r.last); v++ {
+			f(uint16(v))
+		}
+	}
+}
+
+// eachSpan calls f with the index of each mask word overlapped by the
+// runs and the bits of the runs within that word. Iteration stops when
+// f returns false.
+func (c *runContainer) eachSpan(m []uint64, f func(w int, span uint64) bool) {
+	for _, r := range c.runs {
+		for v := int(r.start); v <= int(r.last); {
+			w := v / 64
+
+			if w >= len(m) {
+				return
+			}
+
+			hi := w*64 + 63
+
+			if hi > int(r.last) {
+				hi = int(r.last)
+			}
+
+			// A shift of 64 yields zero, so a full word becomes all ones.
+			span := uint64(1)<<uint(hi-v+1) - 1
+
+			if !f(w, span<<uint(v%64)) {
+				return
+			}
+
+			v = hi + 1
+		}
+	}
+}
+
+func (c *runContainer) intersects(m []uint64) bool {
+	found := false
+
+	c.eachSpan(m, func(w int, span uint64) bool {
+		found = m[w]&span != 0
+		return !found
+	})
+
+	return found
+}
+
+func (c *runContainer) covers(m []uint64) bool {
+	return coversValues(c, m)
+}
+
+func (c *runContainer) countMask(m []uint64) int {
+	var n int
+
+	c.eachSpan(m, func(w int, span uint64) bool {
+		n += bits.OnesCount64(m[w] & span)
+		return true
+	})
+
+	return n
+}
+
+// coversValues tests each bit in the mask against the container.
+func coversValues(c container, m []uint64) bool {
+	for i, w := range m {
+		for w != 0 {
+			t := bits.TrailingZeros64(w)
+
+			if !c.contains(uint16(i*64 + t)) {
+				return false
+			}
+
+			w &= w - 1
+		}
+	}
+
+	return true
+}
+
+func toArrayContainer(c container) *arrayContainer {
+	a := &arrayContainer{
+		vals: make([]uint16, 0, c.cardinality()),
+	}
+
+	c.each(func(v uint16) {
+		a.vals = append(a.vals, v)
+	})
+
+	return a
+}
+
+func toBitmapContainer(c container) *bitmapContainer {
+	if b, ok := c.(*bitmapContainer); ok {
+		return b
+	}
+
+	b := &bitmapContainer{}
+
+	c.each(func(v uint16) {
+		b.words[v/64] |= 1 << (v % 64)
+	})
+
+	b.card = c.cardinality()
+
+	return b
+}
+
+func toRunContainer(c container) *runContainer {
+	r := &runContainer{}
+
+	c.each(func(v uint16) {
+		n := len(r.runs)
+
+		if n > 0 && int(r.runs[n-1].last)+1 == int(v) {
+			r.runs[n-1].last = v
+		} else {
+			r.runs = append(r.runs, interval{v, v})
+		}
+	})
+
+	return r
+}
+
+// countRuns returns the number of runs in the container.
+func countRuns(c container) int {
+	var (
+		n    int
+		prev = -2
+	)
+
+	c.each(func(v uint16) {
+		if int(v) != prev+1 {
+			n++
+		}
+
+		prev = int(v)
+	})
+
+	return n
+}
+
+// optimize returns the smallest representation of the container.
+func optimize(c container) container {
+	card := c.cardinality()
+
+	best, size := bitmapType, bitmapWords*8
+
+	if card <= arrayMaxSize {
+		best, size = arrayType, card*2
+	}
+
+	if n := countRuns(c) * 4; n < size {
+		best = runType
+	}
+
+	if best == c.kind() {
+		return c
+	}
+
+	switch best {
+	case arrayType:
+		return toArrayContainer(c)
+	case runType:
+		return toRunContainer(c)
+	}
+
+	return toBitmapContainer(c)
+}
+
+// fromWords returns the container for a set of bitmap words.
+func fromWords(w *[bitmapWords]uint64) container {
+	b := &bitmapContainer{words: *w}
+
+	for _, x := range w {
+		b.card += bits.OnesCount64(x)
+	}
+
+	if b.card <= arrayMaxSize {
+		return toArrayContainer(b)
+	}
+
+	return b
+}
+
+func andContainers(a, b container) container {
+	if a.cardinality() > b.cardinality() {
+		a, b = b, a
+	}
+
+	// The result of an array has at most as many values, so it stays an
+	// array. Runs go through the words since the result may not fit one.
+	if a.kind() == arrayType {
+		r := &arrayContainer{}
+
+		a.each(func(v uint16) {
+			if b.contains(v) {
+				r.vals = append(r.vals, v)
+			}
+		})
+
+		return r
+	}
+
+	var w [bitmapWords]uint64
+
+	x, y := toBitmapContainer(a), toBitmapContainer(b)
+
+	for i := range w {
+		w[i] = x.words[i] & y.words[i]
+	}
+
+	return fromWords(&w)
+}
+
+func orContainers(a, b container) container {
+	if a.kind() == arrayType && b.kind() == arrayType && a.cardinality()+b.cardinality() <= arrayMaxSize {
+		x, y := a.(*arrayContainer).vals, b.(*arrayContainer).vals
+		r := &arrayContainer{
+			vals: make([]uint16, 0, len(x)+len(y)),
+		}
+
+		i, j := 0, 0
+
+		for i < len(x) && j < len(y) {
+			switch {
+			case x[i] < y[j]:
+				r.vals = append(r.vals, x[i])
+				i++
+			case x[i] > y[j]:
+				r.vals = append(r.vals, y[j])
+				j++
+			default:
+				r.vals = append(r.vals, x[i])
+				i++
+				j++
+			}
+		}
+
+		r.vals = append(r.vals, x[i:]...)
+		r.vals = append(r.vals, y[j:]...)
+
+		return r
+	}
+
+	var w [bitmapWords]uint64
+
+	x, y := toBitmapContainer(a), toBitmapContainer(b)
+
+	for i := range w {
+		w[i] = x.words[i] | y.words[i]
+	}
+
+	return fromWords(&w)
+}
+
+func andNotContainers(a, b container) container {
+	if a.kind() == arrayType {
+		r := &arrayContainer{}
+
+		a.each(func(v uint16) {
+			if !b.contains(v) {
+				r.vals = append(r.vals, v)
+			}
+		})
+
+		return r
+	}
+
+	var w [bitmapWords]uint64
+
+	x, y := toBitmapContainer(a), toBitmapContainer(b)
+
+	for i := range w {
+		w[i] = x.words[i] &^ y.words[i]
+	}
+
+	return fromWords(&w)
+}
+
+// Bitmap is a compressed bitmap of uint32 values using the roaring layout.
+// Values are partitioned by their high 16 bits into containers that store
+// the low 16 bits as a sorted array, a bitmap or a list of runs, whichever
+// is smaller. Bitmap implements the Array interface.
+type Bitmap struct {
+	keys []uint16
+	cs   []container
+}
+
+// NewBitmap initializes a new empty bitmap.
+func NewBitmap(vs ...uint32) *Bitmap {
+	b := &Bitmap{}
+
+	for _, v := range vs {
+		b.Set(v)
+	}
+
+	return b
+}
+
+// find returns the position of the container for the key.
+func (b *Bitmap) find(k uint16) (int, bool) {
+	i := sort.Search(len(b.keys), func(i int) bool {
+		return b.keys[i] >= k
+	})
+
+	return i, i < len(b.keys) && b.keys[i] == k
+}
+
+// Bytes returns the number of bytes used by the bitmap.
+func (b *Bitmap) Bytes() int {
+	n := len(b.keys) * 2
+
+	for _, c := range b.cs {
+		n += c.bytes()
+	}
+
+	return n
+}
+
+// Set sets the bit to 1.
+func (b *Bitmap) Set(v uint32) {
+	k := uint16(v >> 16)
+	i, ok := b.find(k)
+
+	if ok {
+		b.cs[i] = b.cs[i].add(uint16(v))
+		return
+	}
+
+	b.keys = append(b.keys, 0)
+	copy(b.keys[i+1:], b.keys[i:])
+	b.keys[i] = k
+
+	b.cs = append(b.cs, nil)
+	copy(b.cs[i+1:], b.cs[i:])
+	b.cs[i] = &arrayContainer{vals: []uint16{uint16(v)}}
+}
+
+// Clear sets the bit to 0.
+func (b *Bitmap) Clear(v uint32) {
+	i, ok := b.find(uint16(v >> 16))
+
+	if !ok {
+		return
+	}
+
+	c := b.cs[i].remove(uint16(v))
+
+	if c.cardinality() > 0 {
+		b.cs[i] = c
+		return
+	}
+
+	b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	b.cs = append(b.cs[:i], b.cs[i+1:]...)
+}
+
+// Flip flips the bit.
+func (b *Bitmap) Flip(v uint32) bool {
+	t := b.Has(v)
+
+	if t {
+		b.Clear(v)
+	} else {
+		b.Set(v)
+	}
+
+	return !t
+}
+
+// Has returns true if the bit is set.
+func (b *Bitmap) Has(v uint32) bool {
+	i, ok := b.find(uint16(v >> 16))
+	return ok && b.cs[i].contains(uint16(v))
+}
+
+// Loc returns the location of the bit in this array.
+func (b *Bitmap) Loc(v uint32) Loc {
+	return Loc{v / 8, v % 8}
+}
+
+// Any returns true any of the bits set.
+func (b *Bitmap) Any(vs ...uint32) bool {
+	for _, v := range vs {
+		if b.Has(v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// All returns true if all of the bits are set.
+func (b *Bitmap) All(vs ...uint32) bool {
+	for _, v := range vs {
+		if !b.Has(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NotAny returns true if any of the bits are not set.
+func (b *Bitmap) NotAny(vs ...uint32) bool {
+	return !b.Any(vs...)
+}
+
+// NotAll returns true if all of the bits are not set.
+func (b *Bitmap) NotAll(vs ...uint32) bool {
+	return !b.All(vs...)
+}
+
+// chunk returns the mask words covered by the container key.
+func chunk(m Mask, k uint16) []uint64 {
+	lo := int(k) * bitmapWords
+
+	if lo >= len(m) {
+		return nil
+	}
+
+	hi := lo + bitmapWords
+
+	if hi > len(m) {
+		hi = len(m)
+	}
+
+	return m[lo:hi]
+}
+
+// AnyMask returns true if any of the bits in the mask are set.
+func (b *Bitmap) AnyMask(m Mask) bool {
+	for i, k := range b.keys {
+		w := chunk(m, k)
+
+		if w == nil {
+			break
+		}
+
+		if b.cs[i].intersects(w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllMask returns true if all of the bits in the mask are set.
+func (b *Bitmap) AllMask(m Mask) bool {
+	for k := 0; k*bitmapWords < len(m); k++ {
+		w := chunk(m, uint16(k))
+
+		empty := true
+
+		for _, x := range w {
+			if x != 0 {
+				empty = false
+				break
+			}
+		}
+
+		if empty {
+			continue
+		}
+
+		i, ok := b.find(uint16(k))
+
+		if !ok || !b.cs[i].covers(w) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CountMask returns the number of bits in the mask that are set.
+func (b *Bitmap) CountMask(m Mask) int {
+	var n int
+
+	for i, k := range b.keys {
+		w := chunk(m, k)
+
+		if w == nil {
+			break
+		}
+
+		n += b.cs[i].countMask(w)
+	}
+
+	return n
+}
+
+// Bits returns the set bits in ascending order.
+func (b *Bitmap) Bits() []uint32 {
+	a := make([]uint32, 0, b.Cardinality())
+
+	for i, k := range b.keys {
+		hi := uint32(k) << 16
+
+		b.cs[i].each(func(v uint16) {
+			a = append(a, hi|uint32(v))
+		})
+	}
+
+	return a
+}
+
+// Cardinality returns the number of bits set.
+func (b *Bitmap) Cardinality() int {
+	var n int
+
+	for _, c := range b.cs {
+		n += c.cardinality()
+	}
+
+	return n
+}
+
+// RunOptimize converts each container into its smallest representation.
+func (b *Bitmap) RunOptimize() {
+	for i, c := range b.cs {
+		b.cs[i] = optimize(c)
+	}
+}
+
+// Clone returns a copy of the bitmap.
+func (b *Bitmap) Clone() *Bitmap {
+	return b.Or(&Bitmap{})
+}
+
+// merge combines two bitmaps container by container. Containers that only
+// exist in one of the bitmaps are included if the corresponding flag is set.
+func (b *Bitmap) merge(o *Bitmap, f func(x, y container) container, left, right bool) *Bitmap {
+	r := &Bitmap{}
+
+	push := func(k uint16, c container) {
+		if c.cardinality() > 0 {
+			r.keys = append(r.keys, k)
+			r.cs = append(r.cs, c)
+		}
+	}
+
+	i, j := 0, 0
+
+	for i < len(b.keys) || j < len(o.keys) {
+		switch {
+		case j == len(o.keys) || i < len(b.keys) && b.keys[i] < o.keys[j]:
+			if left {
+				push(b.keys[i], toArrayOrSelf(b.cs[i]))
+			}
+			i++
+
+		case i == len(b.keys) || b.keys[i] > o.keys[j]:
+			if right {
+				push(o.keys[j], toArrayOrSelf(o.cs[j]))
+			}
+			j++
+
+		default:
+			push(b.keys[i], f(b.cs[i], o.cs[j]))
+			i++
+			j++
+		}
+	}
+
+	return r
+}
+
+// toArrayOrSelf returns a copy of the container so results never share
+// storage with their operands.
+func toArrayOrSelf(c container) container {
+	if c.kind() == bitmapType {
+		x := *c.(*bitmapContainer)
+		return &x
+	}
+
+	if c.kind() == runType {
+		return &runContainer{runs: append([]interval(nil), c.(*runContainer).runs...)}
+	}
+
+	return toArrayContainer(c)
+}
+
+// And returns the intersection of the two bitmaps.
+func (b *Bitmap) And(o *Bitmap) *Bitmap {
+	return b.merge(o, andContainers, false, false)
+}
+
+// Or returns the union of the two bitmaps.
+func (b *Bitmap) Or(o *Bitmap) *Bitmap {
+	return b.merge(o, orContainers, true, true)
+}
+
+// AndNot returns the values in b that are not in o.
+func (b *Bitmap) AndNot(o *Bitmap) *Bitmap {
+	return b.merge(o, andNotContainers, true, false)
+}
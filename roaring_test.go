@@ -0,0 +1,217 @@
+package bitindex
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomBitmap returns a bitmap and the reference set of its values.
+func randomBitmap(r *rand.Rand, n int, max uint32) (*Bitmap, Uint32Set) {
+	b := NewBitmap()
+	s := make(Uint32Set)
+
+	for i := 0; i < n; i++ {
+		v := uint32(r.Int63n(int64(max)))
+		b.Set(v)
+		s.Add(v)
+	}
+
+	return b, s
+}
+
+func checkBitmap(t *testing.T, name string, b *Bitmap, s Uint32Set) {
+	if b.Cardinality() != s.Len() {
+		t.Errorf("%s: expected cardinality %d, got %d", name, s.Len(), b.Cardinality())
+	}
+
+	var prev int64 = -1
+
+	for _, v := range b.Bits() {
+		if int64(v) <= prev {
+			t.Fatalf("%s: values not in ascending order", name)
+		}
+
+		if !s.Contains(v) {
+			t.Errorf("%s: unexpected value %d", name, v)
+		}
+
+		prev = int64(v)
+	}
+}
+
+func TestBitmapContainers(t *testing.T) {
+	b := NewBitmap()
+
+	// Array container.
+	for i := uint32(0); i < 100; i += 3 {
+		b.Set(i)
+	}
+
+	if b.cs[0].kind() != arrayType {
+		t.Errorf("expected array container")
+	}
+
+	// Bitmap container once the array is full.
+	for i := uint32(0); i < 2*arrayMaxSize; i++ {
+		b.Set(i)
+	}
+
+	if b.cs[0].kind() != bitmapType {
+		t.Errorf("expected bitmap container")
+	}
+
+	// A single contiguous run.
+	b.RunOptimize()
+
+	if b.cs[0].kind() != runType {
+		t.Errorf("expected run container")
+	}
+
+	if b.Cardinality() != 2*arrayMaxSize {
+		t.Errorf("expected cardinality %d, got %d", 2*arrayMaxSize, b.Cardinality())
+	}
+
+	// Values in another container.
+	b.Set(1 << 20)
+
+	if len(b.keys) != 2 {
+		t.Errorf("expected 2 containers, got %d", len(b.keys))
+	}
+
+	for _, v := range []uint32{0, 1, 4000, 2*arrayMaxSize - 1, 1 << 20} {
+		if !b.Has(v) {
+			t.Errorf("bit %d should be set", v)
+		}
+	}
+
+	// Modifying a run container.
+	b.Clear(10)
+
+	if b.Has(10) || !b.Has(11) {
+		t.Errorf("expected bit 10 to be cleared")
+	}
+
+	b.Clear(1 << 20)
+
+	if len(b.keys) != 1 {
+		t.Errorf("expected empty container to be removed")
+	}
+}
+
+func TestBitmapOperations(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, n := range []int{10, 5000, 100000} {
+		x, xs := randomBitmap(r, n, 1<<18)
+		y, ys := randomBitmap(r, n, 1<<18)
+
+		if n > 10 {
+			x.RunOptimize()
+		}
+
+		and := make(Uint32Set)
+		or := make(Uint32Set)
+		andNot := make(Uint32Set)
+
+		for v := range xs {
+			or.Add(v)
+
+			if ys.Contains(v) {
+				and.Add(v)
+			} else {
+				andNot.Add(v)
+			}
+		}
+
+		for v := range ys {
+			or.Add(v)
+		}
+
+		checkBitmap(t, "and", x.And(y), and)
+		checkBitmap(t, "or", x.Or(y), or)
+		checkBitmap(t, "andnot", x.AndNot(y), andNot)
+
+		// Operands are unchanged.
+		checkBitmap(t, "x", x, xs)
+		checkBitmap(t, "y", y, ys)
+	}
+}
+
+func TestBitmapRunOperations(t *testing.T) {
+	// A full container of one run.
+	x := NewBitmap()
+
+	for i := uint32(0); i < 1<<16; i++ {
+		x.Set(i)
+	}
+
+	x.RunOptimize()
+
+	y := NewBitmap(1, 2, 3)
+	z := x.Clone()
+
+	for _, r := range []*Bitmap{x.AndNot(y), x.And(z)} {
+		// Results too large for an array are bitmaps.
+		if k := r.cs[0].kind(); k != bitmapType {
+			t.Errorf("expected bitmap container, got %v", k)
+		}
+	}
+
+	if n := x.AndNot(y).Cardinality(); n != 1<<16-3 {
+		t.Errorf("expected cardinality %d, got %d", 1<<16-3, n)
+	}
+
+	if n := x.And(z).Cardinality(); n != 1<<16 {
+		t.Errorf("expected cardinality %d, got %d", 1<<16, n)
+	}
+
+	// Results that fit an array are arrays.
+	if k := x.And(y).cs[0].kind(); k != arrayType {
+		t.Errorf("expected array container, got %v", k)
+	}
+}
+
+func TestBitmapMask(t *testing.T) {
+	b := NewBitmap(3, 70, 130, 70000)
+
+	for i := uint32(200); i < 300; i++ {
+		b.Set(i)
+	}
+
+	b.RunOptimize()
+
+	if !b.AnyMask(NewMask(1, 250)) {
+		t.Errorf("expected any to match")
+	}
+
+	if !b.AnyMask(NewMask(70000)) {
+		t.Errorf("expected any to match in second container")
+	}
+
+	if b.AnyMask(NewMask(1, 71, 500, 70001)) {
+		t.Errorf("expected any not to match")
+	}
+
+	if !b.AllMask(NewMask(3, 130, 200, 299, 70000)) {
+		t.Errorf("expected all to match")
+	}
+
+	if b.AllMask(NewMask(3, 130, 300)) {
+		t.Errorf("expected all not to match")
+	}
+
+	if b.AllMask(NewMask(3, 1<<20)) {
+		t.Errorf("expected all not to match beyond the bitmap")
+	}
+}
+
+func BenchmarkBitmapAnd(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+
+	x, _ := randomBitmap(r, 100000, 1<<22)
+	y, _ := randomBitmap(r, 100000, 1<<22)
+
+	for i := 0; i < b.N; i++ {
+		x.And(y)
+	}
+}
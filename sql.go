@@ -0,0 +1,159 @@
+package bitindex
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// SQLIndexer is an indexer for the result set of a SQL query run through
+// database/sql. The query must return two columns, the key and member of
+// each pair. Rows with a NULL key or member are skipped. The driver of
+// the database must be registered by the caller.
+type SQLIndexer struct {
+	DB *sql.DB
+
+	// The query and its arguments.
+	Query string
+	Args  []interface{}
+
+	// If true, the postings are built along with the table.
+	Postings bool
+
+	// If true, keys and members are labels encoded with the dictionaries.
+	Labels bool
+
+	// If true, keys are 64-bit integers mapped to ids with WideKeys.
+	Keys64 bool
+
+	// Dictionaries of the key and member labels. They are initialized
+	// when Labels is set.
+	KeyDict    *Dictionary
+	MemberDict *Dictionary
+
+	// Map of the 64-bit keys. It is initialized when Keys64 is set.
+	WideKeys *KeyMap
+}
+
+// NewSQLIndexer initializes a new indexer for the query.
+func NewSQLIndexer(db *sql.DB, query string, args ...interface{}) *SQLIndexer {
+	return &SQLIndexer{
+		DB:    db,
+		Query: query,
+		Args:  args,
+	}
+}
+
+// pair returns the key and member of the columns.
+func (p *SQLIndexer) pair(kv, mv string) (uint32, uint32, error) {
+	if p.Labels {
+		return p.KeyDict.Add(kv), p.MemberDict.Add(mv), nil
+	}
+
+	var k uint32
+
+	if p.Keys64 {
+		k64, err := strconv.ParseUint(kv, 10, 64)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		k = p.WideKeys.Add(k64)
+	} else {
+		k32, err := strconv.ParseUint(kv, 10, 32)
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		k = uint32(k32)
+	}
+
+	m, err := strconv.ParseUint(mv, 10, 32)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return k, uint32(m), nil
+}
+
+// Stream implements the Streamer interface and calls f for each
+// key/member pair in the result set.
+func (p *SQLIndexer) Stream(f func(k uint32, m uint32) error) error {
+	if p.Labels && p.KeyDict == nil {
+		p.KeyDict = NewDictionary()
+		p.MemberDict = NewDictionary()
+	}
+
+	if p.Keys64 && p.WideKeys == nil {
+		p.WideKeys = NewKeyMap()
+	}
+
+	rows, err := p.DB.Query(p.Query, p.Args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+
+	if err != nil {
+		return err
+	}
+
+	if len(cols) != 2 {
+		return fmt.Errorf("The query must return 2 columns, the key and member, got %d", len(cols))
+	}
+
+	var kv, mv sql.NullString
+
+	for n := 1; rows.Next(); n++ {
+		if err = rows.Scan(&kv, &mv); err != nil {
+			return fmt.Errorf("Row %d: %s", n, err)
+		}
+
+		if !kv.Valid || !mv.Valid {
+			continue
+		}
+
+		k, m, err := p.pair(kv.String, mv.String)
+
+		if err != nil {
+			return fmt.Errorf("Row %d: %s", n, err)
+		}
+
+		if err = f(k, m); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Index implements the Indexer interface and builds an index from the
+// result set.
+func (p *SQLIndexer) Index() (*Index, error) {
+	ix := NewIndex(nil)
+
+	if p.Postings {
+		ix.Postings = NewPostings()
+	}
+
+	err := p.Stream(ix.Add)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ix.KeyDict = p.KeyDict
+	ix.MemberDict = p.MemberDict
+	ix.WideKeys = p.WideKeys
+
+	ix.Pack()
+
+	return ix, nil
+}
@@ -0,0 +1,132 @@
+package bitindex
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) (*sql.DB, func()) {
+	dir, err := ioutil.TempDir("", "bitindex")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, "test.db"))
+
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+
+	stmts := []string{
+		"create table visit (patient integer, mrn text, code integer, dx text)",
+		"insert into visit values (100, 'A100', 1, 'E11.9')",
+		"insert into visit values (100, 'A100', 3, 'I10')",
+		"insert into visit values (101, 'B200', 4, 'J45')",
+		"insert into visit values (101, 'B200', 9, null)",
+		"insert into visit values (102, 'C300', 4, 'J45')",
+		"insert into visit values (102, 'C300', 2, 'I10')",
+		"insert into visit values (102, 'C300', 3, 'I10')",
+	}
+
+	for _, s := range stmts {
+		if _, err = db.Exec(s); err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+	}
+
+	return db, cleanup
+}
+
+func TestSQLIndexer(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	p := NewSQLIndexer(db, "select patient, code from visit")
+	p.Postings = true
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ix.Postings == nil {
+		t.Error("expected postings")
+	}
+
+	for k, exp := range pairs {
+		var got []uint32
+
+		for _, b := range ix.Get(k).Bits() {
+			got = append(got, ix.Domain.Member(b))
+		}
+
+		if !sameKeys(got, exp) {
+			t.Errorf("key %d: expected %v, got %v", k, exp, got)
+		}
+	}
+}
+
+func TestSQLIndexerLabels(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	p := NewSQLIndexer(db, "select mrn, dx from visit where patient > ?", 100)
+	p.Labels = true
+
+	ix, err := p.Index()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The null diagnosis is skipped.
+	if ix.Domain.Size() != 2 {
+		t.Errorf("expected 2 members, got %d", ix.Domain.Size())
+	}
+
+	ms, err := ix.LookupMembers("J45")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ix.Any(ms...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameLabels(t, "any(J45)", ix.KeyLabels(keys), []string{"B200", "C300"})
+}
+
+func TestSQLIndexerErrors(t *testing.T) {
+	db, cleanup := openTestDB(t)
+	defer cleanup()
+
+	tests := map[string]string{
+		"select patient from visit":          "must return 2 columns",
+		"select mrn, code from visit":        "Row 1: strconv.ParseUint",
+		"select patient, code from no_table": "no such table",
+	}
+
+	for q, exp := range tests {
+		if _, err := NewSQLIndexer(db, q).Index(); err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("%s: expected error %q, got %v", q, exp, err)
+		}
+	}
+}
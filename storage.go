@@ -4,52 +4,194 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math/bits"
+	"sort"
 )
 
-// Resets the buffer
-func clearBuffer(s []byte) {
-	for i, _ := range s {
-		s[i] = 0x0
+// The binary format of an index is a header, a table of contents, the
+// sections it lists and a footer. Sections are laid out so that they
+// can be queried in place once mapped into memory and so that readers
+// can skip the sections they do not need. All integers are little
+// endian.
+//
+//	header    magic "BITX", uint16 version, uint16 flags
+//	toc       uint32 n, n x (uint32 id, uint64 offset, uint64 length)
+//	stats     uint32 domain size, uint32 table size, uint64 array bytes
+//	domain    uint32 n, n x uint32 members in bit order
+//	keys      uint32 n, n x uint32 keys in ascending order
+//	rows      n+1 x uint64 offsets relative to the first row, n roaring bitmaps
+//	postings  uint32 n, n+1 x uint64 offsets, n roaring bitmaps (optional)
+//	keydict   dictionary of the key labels (optional)
+//	memdict   dictionary of the member labels (optional)
+//	widekeys  uint32 n, n x uint64 keys in id order, n x uint32 ids in key order (optional)
+//	hierarchy uint32 n, n x (uint32 parent, uint32 child) ordered by parent (optional)
+//	footer    uint64 length of the preceding data, uint32 CRC-32C
+//
+// A dictionary is encoded as uint32 n, n+1 x uint64 offsets of the labels
+// in id order, n x uint32 ids in label order and the labels. Offsets in
+// the table of contents are relative to the start of the file.
+// Sections with an unknown id are ignored.
+//
+// The flags mark the optional sections a reader must understand: bit 0
+// the postings, bit 1 the wide keys, bit 2 the key dictionary, bit 3
+// the member dictionary and bit 4 the hierarchy.
+//
+// Bits 8-11 of the flags hold the codec of the sections. If it is set,
+// each section is a uint64 uncompressed length followed by the section
+// compressed by the codec, and the table of contents locates the
+// compressed sections.
+
+// FormatVersion is the version of the binary format written by DumpIndex.
+const FormatVersion uint16 = 3
+
+const (
+	// Magic bytes, format version and flags.
+	headerSize = 8
+
+	// Length of the data preceding the footer and its checksum.
+	footerSize = 12
+)
+
+// Section identifiers in the table of contents.
+const (
+	sectionStats uint32 = iota + 1
+	sectionDomain
+	sectionKeys
+	sectionRows
+	sectionPostings
+	sectionKeyDict
+	sectionMemberDict
+	sectionWideKeys
+	sectionHierarchy
+)
+
+const (
+	// Section id, offset and length.
+	tocEntrySize = 20
+
+	// Domain size, table size and array bytes.
+	statsSize = 16
+)
+
+// Header flags. A flag is set for each section that changes what the
+// keys and members are, so readers that do not know the section reject
+// the file rather than return raw ids.
+const (
+	flagPostings uint16 = 1 << iota
+	flagWideKeys
+	flagKeyDict
+	flagMemberDict
+	flagHierarchy
+)
+
+// Flags understood by this version of the format.
+const knownFlags = flagPostings | flagWideKeys | flagKeyDict | flagMemberDict | flagHierarchy
+
+// Bits of the flags holding the codec of the sections.
+const (
+	codecShift = 8
+
+	flagCodec uint16 = 0xf << codecShift
+)
+
+var (
+	magic = []byte("BITX")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+var (
+	// ErrNotIndex is returned when the input does not begin with the
+	// magic bytes of an index file.
+	ErrNotIndex = errors.New("Not a bitindex file")
+
+	// ErrTruncated is returned when the input is shorter than the length
+	// recorded when the index was written.
+	ErrTruncated = errors.New("Index file is truncated")
+
+	// ErrChecksum is returned when the checksum of the input does not
+	// match the one recorded when the index was written.
+	ErrChecksum = errors.New("Index checksum mismatch")
+
+	// ErrCorrupt is returned when a value in the input cannot be decoded.
+	ErrCorrupt = errors.New("Index file is corrupt")
+)
+
+// VersionError is returned when the index was written in a format
+// version or with flags that are not supported.
+type VersionError struct {
+	Version uint16
+	Flags   uint16
+}
+
+func (e *VersionError) Error() string {
+	if e.Version != FormatVersion {
+		return fmt.Sprintf("Unsupported index format version %d, expected %d", e.Version, FormatVersion)
 	}
+
+	if c := (header{Flags: e.Flags}).codec(); !c.known() {
+		return fmt.Sprintf("Unsupported index codec %d", c)
+	}
+
+	return fmt.Sprintf("Unsupported index flags %#x", e.Flags&^(knownFlags|flagCodec))
+}
+
+// header is the fixed-size header at the start of an index file.
+type header struct {
+	Version uint16
+	Flags   uint16
 }
 
-func writeInt(w io.Writer, b []byte, i int) error {
-	binary.PutUvarint(b, uint64(i))
+// codec returns the codec of the sections.
+func (h header) codec() Codec {
+	return Codec(h.Flags & flagCodec >> codecShift)
+}
+
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n uint64
+}
 
-	if n, err := w.Write(b); err != nil {
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+func writeUint32(w io.Writer, b []byte, i uint32) error {
+	binary.LittleEndian.PutUint32(b, i)
+
+	if _, err := w.Write(b[:4]); err != nil {
 		return err
-	} else if n != binary.MaxVarintLen32 {
-		return fmt.Errorf("Expected to write 5 bytes, wrote %d", n)
 	}
 
 	return nil
 }
 
-func writeUint32(w io.Writer, b []byte, i uint32) error {
-	binary.PutUvarint(b, uint64(i))
+func writeUint64(w io.Writer, b []byte, i uint64) error {
+	binary.LittleEndian.PutUint64(b, i)
 
-	if n, err := w.Write(b); err != nil {
+	if _, err := w.Write(b[:8]); err != nil {
 		return err
-	} else if n != binary.MaxVarintLen32 {
-		return fmt.Errorf("Expected to write 5 bytes, wrote %d", n)
 	}
 
 	return nil
 }
 
 func dumpDomain(w io.Writer, d *Domain, b []byte) error {
-	// Length of the domain. 5 bytes.
-	if err := writeInt(w, b, d.Size()); err != nil {
+	// Length of the domain.
+	if err := writeUint32(w, b, uint32(d.Size())); err != nil {
 		return fmt.Errorf("Error writing domain length: %s", err)
 	}
 
-	// Domain members. 5 bytes each.
+	// Domain members in bit order.
 	for _, n := range d.Members() {
-		clearBuffer(b)
-
 		if err := writeUint32(w, b, n); err != nil {
 			return fmt.Errorf("Error writing domain member: %s", err)
 		}
@@ -58,53 +200,338 @@ func dumpDomain(w io.Writer, d *Domain, b []byte) error {
 	return nil
 }
 
-func dumpArray(w io.Writer, a Array, b []byte) error {
-	clearBuffer(b)
+// writeUvarint writes a variable length integer, at most 5 bytes.
+func writeUvarint(w io.Writer, b []byte, i uint32) error {
+	n := binary.PutUvarint(b, uint64(i))
+
+	if _, err := w.Write(b[:n]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func dumpContainer(w io.Writer, k uint16, c container, b []byte) error {
+	n := containerLen(c)
+
+	// Container key and type. 3 bytes.
+	binary.LittleEndian.PutUint16(b, k)
+	b[2] = c.kind()
+
+	if _, err := w.Write(b[:3]); err != nil {
+		return fmt.Errorf("Error writing container header: %s", err)
+	}
 
-	// Array length. 5 bytes.
-	if err := writeInt(w, b, len(a)); err != nil {
-		return fmt.Errorf("Error writing array length: %s", err)
+	// Number of values or runs.
+	if err := writeUvarint(w, b, uint32(n)); err != nil {
+		return fmt.Errorf("Error writing container length: %s", err)
 	}
 
-	// Encode array items (which is a map).
-	for p, y := range a {
-		clearBuffer(b)
+	var err error
+
+	switch c := c.(type) {
+	case *arrayContainer:
+		err = binary.Write(w, binary.LittleEndian, c.vals)
+
+	case *bitmapContainer:
+		err = binary.Write(w, binary.LittleEndian, c.words[:])
+
+	case *runContainer:
+		// Runs are encoded as the start and length minus one.
+		rs := make([]uint16, 2*len(c.runs))
 
-		// Byte position.
-		if err := writeUint32(w, b, p); err != nil {
-			return fmt.Errorf("Error writing byte position: %s", err)
+		for i, r := range c.runs {
+			rs[2*i] = r.start
+			rs[2*i+1] = r.last - r.start
 		}
 
-		b[0] = y
+		err = binary.Write(w, binary.LittleEndian, rs)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Error writing container: %s", err)
+	}
 
-		if n, err := w.Write(b[:1]); err != nil {
-			return fmt.Errorf("Error writing byte: %s", err)
-		} else if n != 1 {
-			return fmt.Errorf("Expected to write 1 byte, wrote %d", n)
+	return nil
+}
+
+func dumpBitmap(w io.Writer, bm *Bitmap, b []byte) error {
+	// Number of containers.
+	if err := writeUvarint(w, b, uint32(len(bm.keys))); err != nil {
+		return fmt.Errorf("Error writing bitmap length: %s", err)
+	}
+
+	for i, k := range bm.keys {
+		if err := dumpContainer(w, k, bm.cs[i], b); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func dumpTable(w io.Writer, t Table, b []byte) error {
-	clearBuffer(b)
+// uvarintLen returns the number of bytes needed to encode i.
+func uvarintLen(i int) int {
+	n := 1
 
-	// Length of the table. 5 bytes.
-	if err := writeInt(w, b, t.Size()); err != nil {
-		return fmt.Errorf("Error writing table length: %s", err)
+	for ; i >= 0x80; i >>= 7 {
+		n++
 	}
 
-	// Encode table entries.
-	for k, a := range t {
-		clearBuffer(b)
+	return n
+}
+
+// containerLen returns the number of values or runs in the container.
+func containerLen(c container) int {
+	if r, ok := c.(*runContainer); ok {
+		return len(r.runs)
+	}
+
+	return c.cardinality()
+}
+
+// bitmapSize returns the number of bytes of the encoded bitmap.
+func bitmapSize(bm *Bitmap) int {
+	n := uvarintLen(len(bm.keys))
+
+	for _, c := range bm.cs {
+		n += 3 + uvarintLen(containerLen(c)) + c.bytes()
+	}
+
+	return n
+}
+
+// toBitmap returns the array as a roaring bitmap.
+func toBitmap(a Array) *Bitmap {
+	bm, ok := a.(*Bitmap)
+
+	if !ok {
+		bm = NewBitmap(a.Bits()...)
+		bm.RunOptimize()
+	}
+
+	return bm
+}
+
+// bitmapList is a list of bitmaps preceded by their offsets so each one
+// can be located without decoding the others. The bitmaps are kept from
+// sizing the list to dumping it, so each one is converted or decoded once.
+type bitmapList struct {
+	bms  []*Bitmap
+	offs []uint64
+}
+
+func newBitmapList(n int, get func(i int) *Bitmap) *bitmapList {
+	bms := make([]*Bitmap, n)
+	offs := make([]uint64, n+1)
+
+	for i := 0; i < n; i++ {
+		bms[i] = get(i)
+		offs[i+1] = offs[i] + uint64(bitmapSize(bms[i]))
+	}
+
+	return &bitmapList{
+		bms:  bms,
+		offs: offs,
+	}
+}
+
+// size returns the number of bytes of the encoded list.
+func (l *bitmapList) size() uint64 {
+	return uint64(len(l.offs))*8 + l.offs[len(l.offs)-1]
+}
+
+func (l *bitmapList) dump(w io.Writer, b []byte) error {
+	for _, off := range l.offs {
+		if err := writeUint64(w, b, off); err != nil {
+			return fmt.Errorf("Error writing offset: %s", err)
+		}
+	}
+
+	for _, bm := range l.bms {
+		if err := dumpBitmap(w, bm, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dumpStats(w io.Writer, s *Stats, b []byte) error {
+	if err := writeUint32(w, b, uint32(s.DomainSize)); err != nil {
+		return fmt.Errorf("Error writing stats: %s", err)
+	}
+
+	if err := writeUint32(w, b, uint32(s.TableSize)); err != nil {
+		return fmt.Errorf("Error writing stats: %s", err)
+	}
+
+	if err := writeUint64(w, b, uint64(s.Bytes)); err != nil {
+		return fmt.Errorf("Error writing stats: %s", err)
+	}
+
+	return nil
+}
 
-		// Entry key. 5 bytes.
+func dumpKeys(w io.Writer, keys []uint32, b []byte) error {
+	// Number of keys.
+	if err := writeUint32(w, b, uint32(len(keys))); err != nil {
+		return fmt.Errorf("Error writing table length: %s", err)
+	}
+
+	// Keys in ascending order.
+	for _, k := range keys {
 		if err := writeUint32(w, b, k); err != nil {
 			return fmt.Errorf("Error writing array key: %s", err)
 		}
+	}
+
+	return nil
+}
+
+// sectionWriter writes a section of a known size.
+type sectionWriter struct {
+	id   uint32
+	size uint64
+	dump func(w io.Writer, b []byte) error
+}
+
+// indexSections returns the sections of the index.
+func indexSections(idx *Index) []sectionWriter {
+	keys := idx.Keys().Bits()
+	stats := idx.Stats()
+
+	// Arrays are encoded as roaring bitmaps regardless
+	// of the in-memory representation.
+	rows := newBitmapList(len(keys), func(i int) *Bitmap {
+		return toBitmap(idx.Get(keys[i]))
+	})
+
+	secs := []sectionWriter{
+		{sectionStats, statsSize, func(w io.Writer, b []byte) error {
+			return dumpStats(w, stats, b)
+		}},
+		{sectionDomain, 4 + 4*uint64(idx.Domain.Size()), func(w io.Writer, b []byte) error {
+			return dumpDomain(w, idx.Domain, b)
+		}},
+		{sectionKeys, 4 + 4*uint64(len(keys)), func(w io.Writer, b []byte) error {
+			return dumpKeys(w, keys, b)
+		}},
+		{sectionRows, rows.size(), rows.dump},
+	}
+
+	// Postings are optional and follow the rows. Their presence
+	// is also recorded in the header flags.
+	if p := idx.Postings; p != nil {
+		ps := newBitmapList(p.Size(), func(i int) *Bitmap {
+			return p.Get(uint32(i))
+		})
+
+		secs = append(secs, sectionWriter{sectionPostings, 4 + ps.size(), func(w io.Writer, b []byte) error {
+			// Number of posting lists.
+			if err := writeUint32(w, b, uint32(p.Size())); err != nil {
+				return fmt.Errorf("Error writing postings length: %s", err)
+			}
+
+			return ps.dump(w, b)
+		}})
+	}
+
+	secs = appendDictionaries(secs, idx.KeyDict, idx.MemberDict)
+
+	secs = appendKeyMap(secs, idx.WideKeys)
+
+	return appendHierarchy(secs, idx.Hierarchy)
+}
+
+// appendHierarchy appends the section of the member hierarchy if it is set.
+func appendHierarchy(secs []sectionWriter, h *Hierarchy) []sectionWriter {
+	if h == nil {
+		return secs
+	}
+
+	return append(secs, sectionWriter{sectionHierarchy, hierarchySize(h), func(w io.Writer, b []byte) error {
+		return dumpHierarchy(w, h, b)
+	}})
+}
+
+// appendKeyMap appends the section of the 64-bit keys if they are set.
+func appendKeyMap(secs []sectionWriter, km *KeyMap) []sectionWriter {
+	if km == nil {
+		return secs
+	}
+
+	return append(secs, sectionWriter{sectionWideKeys, keyMapSize(km), func(w io.Writer, b []byte) error {
+		return dumpKeyMap(w, km, b)
+	}})
+}
+
+// dictionaryFlags returns the flags of the dictionaries that are set.
+func dictionaryFlags(keys, members *Dictionary) uint16 {
+	var flags uint16
+
+	if keys != nil {
+		flags |= flagKeyDict
+	}
+
+	if members != nil {
+		flags |= flagMemberDict
+	}
+
+	return flags
+}
+
+// appendDictionaries appends the sections of the dictionaries that are set.
+func appendDictionaries(secs []sectionWriter, keys, members *Dictionary) []sectionWriter {
+	dicts := []struct {
+		id uint32
+		d  *Dictionary
+	}{
+		{sectionKeyDict, keys},
+		{sectionMemberDict, members},
+	}
+
+	for _, x := range dicts {
+		if d := x.d; d != nil {
+			secs = append(secs, sectionWriter{x.id, dictionarySize(d), func(w io.Writer, b []byte) error {
+				return dumpDictionary(w, d, b)
+			}})
+		}
+	}
+
+	return secs
+}
 
-		if err := dumpArray(w, a, b); err != nil {
+// dumpSections writes the table of contents followed by the sections.
+func dumpSections(w io.Writer, secs []sectionWriter) error {
+	// Shared buffer. Nothing exceeds 8 bytes.
+	b := make([]byte, 8)
+
+	// Table of contents.
+	if err := writeUint32(w, b, uint32(len(secs))); err != nil {
+		return fmt.Errorf("Error writing table of contents: %s", err)
+	}
+
+	off := uint64(headerSize + 4 + tocEntrySize*len(secs))
+
+	for _, sec := range secs {
+		if err := writeUint32(w, b, sec.id); err != nil {
+			return fmt.Errorf("Error writing table of contents: %s", err)
+		}
+
+		if err := writeUint64(w, b, off); err != nil {
+			return fmt.Errorf("Error writing table of contents: %s", err)
+		}
+
+		if err := writeUint64(w, b, sec.size); err != nil {
+			return fmt.Errorf("Error writing table of contents: %s", err)
+		}
+
+		off += sec.size
+	}
+
+	for _, sec := range secs {
+		if err := sec.dump(w, b); err != nil {
 			return err
 		}
 	}
@@ -112,199 +539,750 @@ func dumpTable(w io.Writer, t Table, b []byte) error {
 	return nil
 }
 
-func dumpIndex(w io.Writer, idx *Index) error {
-	// Shared buffer. Nothing exceeds 5 bytes.
-	b := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32)
+func dumpHeader(w io.Writer, h header) error {
+	b := make([]byte, headerSize)
 
-	if err := dumpDomain(w, idx.Domain, b); err != nil {
-		return err
-	}
+	copy(b, magic)
+	binary.LittleEndian.PutUint16(b[4:], h.Version)
+	binary.LittleEndian.PutUint16(b[6:], h.Flags)
 
-	if err := dumpTable(w, idx.Table, b); err != nil {
-		return err
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("Error writing header: %s", err)
 	}
 
 	return nil
 }
 
-// DumpIndex writes an Index to it binary representation.
+// DumpIndex writes an Index to it binary representation. The index is
+// preceded by a header and followed by a footer containing the length
+// and checksum of the data.
 func DumpIndex(w io.Writer, idx *Index) error {
+	return DumpCompressed(w, idx, CodecNone)
+}
+
+// DumpCompressed writes an Index like DumpIndex with its sections
+// compressed by the codec. The compressed sections are held in memory
+// until they are written. A compressed index is smaller, but it cannot
+// be mapped in place, so OpenIndex decompresses it into memory.
+func DumpCompressed(w io.Writer, idx *Index, c Codec) error {
+	// Retired members still hold their bits.
+	if idx.Domain.Retired() > 0 {
+		return ErrRetired
+	}
+
+	var flags uint16
+
+	if idx.Postings != nil {
+		flags |= flagPostings
+	}
+
+	// Keys are 64-bit and the table holds their ids.
+	if idx.WideKeys != nil {
+		flags |= flagWideKeys
+	}
+
+	flags |= dictionaryFlags(idx.KeyDict, idx.MemberDict)
+
+	if idx.Hierarchy != nil {
+		flags |= flagHierarchy
+	}
+
+	if err := dumpFile(w, flags, c, indexSections(idx), nil); err != nil {
+		return err
+	}
+
+	// Arrays of a mapped index that could not be decoded were written
+	// as empty.
+	return idx.Err()
+}
+
+// dumpFile writes the header, sections and footer. The sections are
+// compressed first if a codec is set, into spools created by newSpool
+// if it is set or in memory otherwise.
+func dumpFile(w io.Writer, flags uint16, c Codec, secs []sectionWriter, newSpool func() (*spool, error)) error {
+	if c != CodecNone {
+		var err error
+
+		if secs, err = compressSections(c, secs, newSpool); err != nil {
+			return err
+		}
+
+		flags |= uint16(c) << codecShift
+	}
+
 	bw := bufio.NewWriter(w)
 
-	if err := dumpIndex(bw, idx); err != nil {
+	crc := crc32.New(crcTable)
+	cw := &countWriter{w: io.MultiWriter(bw, crc)}
+
+	h := header{
+		Version: FormatVersion,
+		Flags:   flags,
+	}
+
+	if err := dumpHeader(cw, h); err != nil {
 		return err
 	}
 
-	bw.Flush()
+	if err := dumpSections(cw, secs); err != nil {
+		return err
+	}
 
-	return nil
+	f := make([]byte, footerSize)
+
+	binary.LittleEndian.PutUint64(f, cw.n)
+	binary.LittleEndian.PutUint32(f[8:], crc.Sum32())
+
+	if _, err := bw.Write(f); err != nil {
+		return fmt.Errorf("Error writing footer: %s", err)
+	}
+
+	return bw.Flush()
 }
 
-func readUint32(r io.Reader, b []byte) (uint32, error) {
-	if len(b) != binary.MaxVarintLen32 {
-		panic("need 5 bytes")
+// parseHeader validates the magic bytes, version and flags.
+func parseHeader(b []byte) (header, error) {
+	var h header
+
+	n := len(b)
+
+	if n > len(magic) {
+		n = len(magic)
+	}
+
+	if !bytes.Equal(b[:n], magic[:n]) {
+		return h, ErrNotIndex
 	}
-	if n, err := r.Read(b); err != nil {
-		return 0, err
-	} else if n != binary.MaxVarintLen32 {
-		return 0, fmt.Errorf("Expected to read 5 bytes; read %d", n)
+
+	if len(b) < headerSize {
+		return h, ErrTruncated
+	}
+
+	h.Version = binary.LittleEndian.Uint16(b[4:])
+	h.Flags = binary.LittleEndian.Uint16(b[6:])
+
+	if h.Version != FormatVersion || h.Flags&^(knownFlags|flagCodec) != 0 || !h.codec().known() {
+		return h, &VersionError{h.Version, h.Flags}
 	}
 
-	val, ierr := binary.Uvarint(b)
+	return h, nil
+}
+
+func readHeader(r io.Reader) (header, error) {
+	b := make([]byte, headerSize)
 
-	if ierr <= 0 {
-		panic("error decoding int")
+	n, err := io.ReadFull(r, b)
+
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return header{}, err
 	}
 
-	return uint32(val), nil
+	return parseHeader(b[:n])
 }
 
-func readInt(r io.Reader, b []byte) (int, error) {
-	if n, err := r.Read(b); err != nil {
-		return 0, err
-	} else if n != binary.MaxVarintLen32 {
-		return 0, fmt.Errorf("Expected to read 5 bytes; read %d", n)
+// trimFooter checks the length recorded in the footer of an index held
+// in memory and returns the data preceding it.
+func trimFooter(ab []byte) ([]byte, error) {
+	if len(ab) < headerSize+footerSize {
+		return nil, ErrTruncated
 	}
 
-	val, ierr := binary.Uvarint(b)
+	n := len(ab) - footerSize
 
-	if ierr <= 0 {
-		panic("error decoding int")
+	if binary.LittleEndian.Uint64(ab[n:]) != uint64(n) {
+		return nil, ErrTruncated
 	}
 
-	return int(val), nil
+	return ab[:n], nil
 }
 
-func readByte(r io.Reader, b []byte) (byte, error) {
-	if n, err := r.Read(b[:1]); err != nil {
-		return 0, err
-	} else if n != 1 {
-		return 0, fmt.Errorf("Expected to read 1 byte; read %d", n)
+// verify checks the footer of an index held in memory, including the
+// checksum, and returns the data preceding it.
+func verify(ab []byte) ([]byte, error) {
+	data, err := trimFooter(ab)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(ab[len(data)+8:]) {
+		return nil, ErrChecksum
 	}
 
-	return b[0], nil
+	return data, nil
 }
 
-func readArray(r io.Reader, n int, b []byte) (Array, error) {
-	a := make(Array, n)
+// byteReader is implemented by the in-memory reader used when loading
+// an index which allows decoding variable length integers.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
 
-	var (
-		pos uint32
-		bb  byte
-		err error
-	)
+func readContainer(r byteReader, b []byte) (uint16, container, error) {
+	if _, err := io.ReadFull(r, b[:3]); err != nil {
+		return 0, nil, fmt.Errorf("Error reading container header: %s", err)
+	}
 
-	for i := 0; i < n; i++ {
-		// Read byte position.
-		if pos, err = readUint32(r, b); err != nil {
-			return nil, fmt.Errorf("Error reading byte position at %d: %s", i, err)
+	k := binary.LittleEndian.Uint16(b)
+	t := b[2]
+
+	n, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("Error reading container length: %s", err)
+	}
+
+	var c container
+
+	switch t {
+	case arrayType:
+		if n > arrayMaxSize {
+			return 0, nil, fmt.Errorf("Array container too large: %d", n)
+		}
+
+		a := &arrayContainer{
+			vals: make([]uint16, n),
+		}
+
+		if err = binary.Read(r, binary.LittleEndian, a.vals); err != nil {
+			break
+		}
+
+		// Values must be strictly ascending.
+		for i := 1; i < len(a.vals); i++ {
+			if a.vals[i] <= a.vals[i-1] {
+				return 0, nil, ErrCorrupt
+			}
+		}
+
+		c = a
+
+	case bitmapType:
+		m := &bitmapContainer{
+			card: int(n),
 		}
 
-		// Byte entry.
-		if bb, err = readByte(r, b); err != nil {
-			return nil, fmt.Errorf("Error reading byte at %d: %s", i, err)
+		if err = binary.Read(r, binary.LittleEndian, m.words[:]); err != nil {
+			break
 		}
 
-		a[pos] = bb
+		var card uint64
+
+		for _, w := range m.words {
+			card += uint64(bits.OnesCount64(w))
+		}
+
+		if card != n {
+			return 0, nil, ErrCorrupt
+		}
+
+		c = m
+
+	case runType:
+		if n > bitmapWords*64 {
+			return 0, nil, fmt.Errorf("Run container too large: %d", n)
+		}
+
+		rs := make([]uint16, 2*n)
+
+		if err = binary.Read(r, binary.LittleEndian, rs); err != nil {
+			break
+		}
+
+		rc := &runContainer{
+			runs: make([]interval, n),
+		}
+
+		// Runs are a start and a length, must not extend past the
+		// container and must ascend without overlapping.
+		for i := range rc.runs {
+			start, last := uint32(rs[2*i]), uint32(rs[2*i])+uint32(rs[2*i+1])
+
+			if last > 0xffff || (i > 0 && start <= uint32(rc.runs[i-1].last)) {
+				return 0, nil, ErrCorrupt
+			}
+
+			rc.runs[i] = interval{uint16(start), uint16(last)}
+		}
+
+		c = rc
+
+	default:
+		return 0, nil, fmt.Errorf("Unknown container type: %d", t)
+	}
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("Error reading container: %s", err)
 	}
 
-	return a, nil
+	return k, c, nil
 }
 
-func readDomain(r io.Reader, b []byte) (*Domain, error) {
-	var (
-		n   int
-		m   uint32
-		err error
-	)
+func readBitmap(r byteReader, b []byte) (*Bitmap, error) {
+	n, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error reading bitmap length: %s", err)
+	}
 
-	// N members in the domain.
-	if n, err = readInt(r, b); err != nil {
-		return nil, fmt.Errorf("Error decoding domain length: %s", err)
+	if n > 1<<16 {
+		return nil, fmt.Errorf("Bitmap too large: %d containers", n)
 	}
 
-	// Initialize array for members.
-	ms := make([]uint32, n)
+	bm := &Bitmap{
+		keys: make([]uint16, n),
+		cs:   make([]container, n),
+	}
 
-	// Domain members are encoded as an array of 5 bytes
-	for i := 0; i < n; i++ {
-		if m, err = readUint32(r, b); err != nil {
-			return nil, fmt.Errorf("Error decoding domain member at %d: %s", i, err)
+	for i := 0; i < int(n); i++ {
+		if bm.keys[i], bm.cs[i], err = readContainer(r, b); err != nil {
+			return nil, err
 		}
 
-		ms[i] = m
+		// Containers are looked up by a binary search of their keys.
+		if i > 0 && bm.keys[i] <= bm.keys[i-1] {
+			return nil, ErrCorrupt
+		}
 	}
 
-	// Initialize and set the domain.
-	return NewDomain(ms), nil
+	return bm, nil
 }
 
-func readTable(r io.Reader, b []byte) (Table, error) {
-	var (
-		n   int
-		err error
-	)
+// decodeBitmap decodes a bitmap held in memory.
+func decodeBitmap(data []byte) (*Bitmap, error) {
+	b := make([]byte, 8)
+
+	bm, err := readBitmap(bytes.NewReader(data), b)
 
-	// N entries in the table.
-	if n, err = readInt(r, b); err != nil {
-		return nil, fmt.Errorf("Error decoding table length: %s", err)
+	if err != nil {
+		return nil, ErrCorrupt
 	}
 
-	t := make(Table, n)
+	return bm, nil
+}
 
-	var (
-		l int
-		k uint32
-		a Array
-	)
+// tocEntry locates a section in the file.
+type tocEntry struct {
+	id   uint32
+	off  uint64
+	size uint64
+}
 
-	// Decode all table entries.
-	for i := 0; i < n; i++ {
-		// Key for the entry.
-		if k, err = readUint32(r, b); err != nil {
-			return nil, fmt.Errorf("Error decoding array key: %s", err)
+// readAll reads exactly n bytes. The buffer grows with the data read so
+// a corrupt length does not cause a large allocation.
+func readAll(r io.Reader, n uint64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(b)) != n {
+		return nil, ErrTruncated
+	}
+
+	return b, nil
+}
+
+// readTOC reads the table of contents following the header.
+func readTOC(r io.Reader) ([]tocEntry, error) {
+	b, err := readAll(r, 4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n := uint64(binary.LittleEndian.Uint32(b))
+
+	if b, err = readAll(r, n*tocEntrySize); err != nil {
+		return nil, err
+	}
+
+	toc := make([]tocEntry, n)
+
+	for i := range toc {
+		e := b[i*tocEntrySize:]
+
+		toc[i] = tocEntry{
+			id:   binary.LittleEndian.Uint32(e),
+			off:  binary.LittleEndian.Uint64(e[4:]),
+			size: binary.LittleEndian.Uint64(e[12:]),
+		}
+	}
+
+	return toc, nil
+}
+
+// readSection reads a section from a reader positioned just after the
+// table of contents. Preceding sections are skipped, by seeking if
+// the reader supports it. The section is decompressed if the header
+// has a codec.
+func readSection(r io.Reader, h header, toc []tocEntry, id uint32) ([]byte, error) {
+	pos := uint64(headerSize + 4 + tocEntrySize*len(toc))
+
+	for _, e := range toc {
+		if e.id != id {
+			continue
 		}
 
-		// Decode array length.
-		if l, err = readInt(r, b); err != nil {
-			return nil, fmt.Errorf("Error decoding array length: %s", err)
+		if e.off < pos {
+			return nil, ErrCorrupt
 		}
 
-		if a, err = readArray(r, l, b); err != nil {
+		var err error
+
+		if s, ok := r.(io.Seeker); ok {
+			_, err = s.Seek(int64(e.off-pos), io.SeekCurrent)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, r, int64(e.off-pos))
+		}
+
+		if err == io.EOF {
+			return nil, ErrTruncated
+		} else if err != nil {
 			return nil, err
 		}
 
-		// Add entry to table.
-		t[k] = a
+		b, err := readAll(r, e.size)
+
+		if err != nil || h.codec() == CodecNone {
+			return b, err
+		}
+
+		return inflateSection(h.codec(), b)
 	}
 
-	return t, nil
+	return nil, ErrCorrupt
 }
 
-// LoadDomain loads only the domain from an io.Reader.
+// openSection reads the header and table of contents and then a section.
+func openSection(r io.Reader, id uint32) ([]byte, error) {
+	h, err := readHeader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	toc, err := readTOC(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return readSection(r, h, toc, id)
+}
+
+func decodeStats(b []byte, h header) (*Stats, error) {
+	// Later versions may append to the stats.
+	if len(b) < statsSize {
+		return nil, ErrCorrupt
+	}
+
+	return &Stats{
+		DomainSize: int(binary.LittleEndian.Uint32(b)),
+		TableSize:  int(binary.LittleEndian.Uint32(b[4:])),
+		Bytes:      int(binary.LittleEndian.Uint64(b[8:])),
+		Postings:   h.Flags&flagPostings != 0,
+		Codec:      h.codec(),
+	}, nil
+}
+
+// decodeUint32s checks the length of a section holding a list of
+// integers and returns the list without the length.
+func decodeUint32s(b []byte) ([]byte, error) {
+	if len(b) < 4 || uint64(len(b)-4) != uint64(binary.LittleEndian.Uint32(b))*4 {
+		return nil, ErrCorrupt
+	}
+
+	return b[4:], nil
+}
+
+// decodeBitmapList splits a list of n bitmaps into offsets and data.
+func decodeBitmapList(b []byte, n uint64) ([]byte, []byte, error) {
+	if uint64(len(b)) < (n+1)*8 {
+		return nil, nil, ErrCorrupt
+	}
+
+	offs, data := b[:(n+1)*8], b[(n+1)*8:]
+
+	if binary.LittleEndian.Uint64(offs[n*8:]) != uint64(len(data)) {
+		return nil, nil, ErrCorrupt
+	}
+
+	return offs, data, nil
+}
+
+// layout locates the sections of an index held in memory. The sections
+// are slices of the underlying data and decoded on demand. Compressed
+// sections are decompressed when the layout is parsed.
+type layout struct {
+	header
+
+	stats    *Stats
+	members  []byte
+	keys     []byte
+	offsets  []byte
+	rows     []byte
+	poffsets []byte
+	postings []byte
+
+	keyDict    *dictLayout
+	memberDict *dictLayout
+	wideKeys   *keyMapLayout
+	hierarchy  *hierarchyLayout
+}
+
+// parseLayout locates the sections of the data preceding the footer.
+func parseLayout(data []byte) (*layout, error) {
+	h, err := parseHeader(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	toc, err := readTOC(bytes.NewReader(data[headerSize:]))
+
+	if err == ErrTruncated {
+		return nil, ErrCorrupt
+	} else if err != nil {
+		return nil, err
+	}
+
+	secs := make(map[uint32][]byte, len(toc))
+
+	for _, e := range toc {
+		if e.off > uint64(len(data)) || e.size > uint64(len(data))-e.off {
+			return nil, ErrCorrupt
+		}
+
+		secs[e.id] = data[e.off : e.off+e.size]
+
+		if c := h.codec(); c != CodecNone {
+			if secs[e.id], err = inflateSection(c, secs[e.id]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, id := range []uint32{sectionStats, sectionDomain, sectionKeys, sectionRows} {
+		if _, ok := secs[id]; !ok {
+			return nil, ErrCorrupt
+		}
+	}
+
+	l := &layout{header: h}
+
+	if l.stats, err = decodeStats(secs[sectionStats], h); err != nil {
+		return nil, err
+	}
+
+	if l.members, err = decodeUint32s(secs[sectionDomain]); err != nil {
+		return nil, err
+	}
+
+	if l.keys, err = decodeUint32s(secs[sectionKeys]); err != nil {
+		return nil, err
+	}
+
+	if l.offsets, l.rows, err = decodeBitmapList(secs[sectionRows], uint64(l.size())); err != nil {
+		return nil, err
+	}
+
+	if h.Flags&flagPostings != 0 {
+		b, ok := secs[sectionPostings]
+
+		if !ok || len(b) < 4 {
+			return nil, ErrCorrupt
+		}
+
+		n := uint64(binary.LittleEndian.Uint32(b))
+
+		if l.poffsets, l.postings, err = decodeBitmapList(b[4:], n); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Flags&flagKeyDict != 0 {
+		b, ok := secs[sectionKeyDict]
+
+		if !ok {
+			return nil, ErrCorrupt
+		}
+
+		if l.keyDict, err = decodeDictionary(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Flags&flagMemberDict != 0 {
+		b, ok := secs[sectionMemberDict]
+
+		if !ok {
+			return nil, ErrCorrupt
+		}
+
+		if l.memberDict, err = decodeDictionary(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Flags&flagHierarchy != 0 {
+		b, ok := secs[sectionHierarchy]
+
+		if !ok {
+			return nil, ErrCorrupt
+		}
+
+		if l.hierarchy, err = decodeHierarchy(b); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.Flags&flagWideKeys != 0 {
+		b, ok := secs[sectionWideKeys]
+
+		if !ok {
+			return nil, ErrCorrupt
+		}
+
+		if l.wideKeys, err = decodeKeyMap(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// uint32s decodes a list of integers.
+func uint32s(b []byte) []uint32 {
+	a := make([]uint32, len(b)/4)
+
+	for i := range a {
+		a[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+
+	return a
+}
+
+// domain decodes the domain.
+func (l *layout) domain() *Domain {
+	return NewDomain(uint32s(l.members))
+}
+
+// size returns the number of keys.
+func (l *layout) size() int {
+	return len(l.keys) / 4
+}
+
+// key returns the i-th key.
+func (l *layout) key(i int) uint32 {
+	return binary.LittleEndian.Uint32(l.keys[i*4:])
+}
+
+// find returns the position of the key.
+func (l *layout) find(k uint32) (int, bool) {
+	n := l.size()
+
+	i := sort.Search(n, func(i int) bool {
+		return l.key(i) >= k
+	})
+
+	return i, i < n && l.key(i) == k
+}
+
+// bitmapAt decodes the i-th bitmap of a list.
+func bitmapAt(offs, data []byte, i int) (*Bitmap, error) {
+	lo := binary.LittleEndian.Uint64(offs[i*8:])
+	hi := binary.LittleEndian.Uint64(offs[(i+1)*8:])
+
+	if lo > hi || hi > uint64(len(data)) {
+		return nil, ErrCorrupt
+	}
+
+	return decodeBitmap(data[lo:hi])
+}
+
+// row decodes the array of the i-th key.
+func (l *layout) row(i int) (*Bitmap, error) {
+	return bitmapAt(l.offsets, l.rows, i)
+}
+
+// nbits returns the number of posting lists.
+func (l *layout) nbits() int {
+	if l.poffsets == nil {
+		return 0
+	}
+
+	return len(l.poffsets)/8 - 1
+}
+
+// posting decodes the posting list of the bit.
+func (l *layout) posting(b int) (*Bitmap, error) {
+	return bitmapAt(l.poffsets, l.postings, b)
+}
+
+// LoadDomain loads only the domain from an io.Reader. The header is
+// validated, but since the rest of the index is not read, the checksum
+// is not.
 func LoadDomain(r io.Reader) (*Domain, error) {
-	var (
-		d   *Domain
-		err error
-	)
+	b, err := openSection(r, sectionDomain)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = decodeUint32s(b); err != nil {
+		return nil, err
+	}
+
+	return NewDomain(uint32s(b)), nil
+}
+
+// LoadStats loads only the summary statistics from an io.Reader. They
+// are computed when the index is written, so this takes constant time.
+// The checksum is not validated.
+func LoadStats(r io.Reader) (*Stats, error) {
+	h, err := readHeader(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	toc, err := readTOC(r)
+
+	if err != nil {
+		return nil, err
+	}
 
-	b := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32)
+	b, err := readSection(r, h, toc, sectionStats)
 
-	if d, err = readDomain(r, b); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	return d, nil
+	return decodeStats(b, h)
 }
 
-// LoadIndex loads the index from an io.Reader.
-func LoadIndex(r io.Reader) (*Index, error) {
-	var (
-		d   *Domain
-		t   Table
-		err error
-	)
+// LoadKeys loads only the keys, in ascending order, from an io.Reader.
+// The checksum is not validated.
+func LoadKeys(r io.Reader) ([]uint32, error) {
+	b, err := openSection(r, sectionKeys)
+
+	if err != nil {
+		return nil, err
+	}
 
+	if b, err = decodeUint32s(b); err != nil {
+		return nil, err
+	}
+
+	return uint32s(b), nil
+}
+
+// LoadIndex loads the index from an io.Reader. The header and checksum
+// are validated before the index is decoded.
+func LoadIndex(r io.Reader) (*Index, error) {
 	// Read everything in memory.
 	ab, err := ioutil.ReadAll(r)
 
@@ -312,22 +1290,75 @@ func LoadIndex(r io.Reader) (*Index, error) {
 		return nil, err
 	}
 
-	r = bytes.NewReader(ab)
-
-	b := make([]byte, binary.MaxVarintLen32, binary.MaxVarintLen32)
+	if _, err = parseHeader(ab); err != nil {
+		return nil, err
+	}
 
-	if d, err = readDomain(r, b); err != nil {
+	if ab, err = verify(ab); err != nil {
 		return nil, err
 	}
 
-	if t, err = readTable(r, b); err != nil {
+	l, err := parseLayout(ab)
+
+	if err != nil {
 		return nil, err
 	}
 
+	n := l.size()
+
+	t := make(Table, n)
+
+	var bm *Bitmap
+
+	// Decode all table entries.
+	for i := 0; i < n; i++ {
+		if bm, err = l.row(i); err != nil {
+			return nil, fmt.Errorf("Error decoding array %d: %s", i, err)
+		}
+
+		t[l.key(i)] = Pack(bm)
+	}
+
 	idx := &Index{
-		Domain: d,
+		Domain: l.domain(),
 		Table:  t,
 	}
 
+	if l.Flags&flagPostings != 0 {
+		p := NewPostings()
+		p.bits = make([]*Bitmap, l.nbits())
+
+		for i := range p.bits {
+			if p.bits[i], err = l.posting(i); err != nil {
+				return nil, fmt.Errorf("Error decoding postings %d: %s", i, err)
+			}
+		}
+
+		// The set of all keys is derived from the table.
+		for i := 0; i < n; i++ {
+			p.keys.Set(l.key(i))
+		}
+
+		p.keys.RunOptimize()
+
+		idx.Postings = p
+	}
+
+	if l.keyDict != nil {
+		idx.KeyDict = l.keyDict.load()
+	}
+
+	if l.memberDict != nil {
+		idx.MemberDict = l.memberDict.load()
+	}
+
+	if l.wideKeys != nil {
+		idx.WideKeys = l.wideKeys.load()
+	}
+
+	if l.hierarchy != nil {
+		idx.Hierarchy = l.hierarchy.load()
+	}
+
 	return idx, nil
 }
@@ -2,6 +2,7 @@ package bitindex
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -50,6 +51,208 @@ func TestDumpLoadIndex(t *testing.T) {
 	}
 }
 
+func TestDumpLoadArrays(t *testing.T) {
+	ms := make([]uint32, 200000)
+
+	for i := range ms {
+		ms[i] = uint32(i)
+	}
+
+	ix1 := NewIndex(ms)
+
+	rows := map[uint32][]uint32{
+		// Sparse, array containers in several chunks.
+		1: {5, 70000, 199999},
+		// Contiguous, run container.
+		2: make([]uint32, 0, 10000),
+		// Dense.
+		3: make([]uint32, 0, 1000),
+	}
+
+	for i := uint32(100); i < 10100; i++ {
+		rows[2] = append(rows[2], i)
+	}
+
+	for i := uint32(0); i < 2000; i += 2 {
+		rows[3] = append(rows[3], i)
+	}
+
+	for k, bs := range rows {
+		for _, b := range bs {
+			ix1.Table.Set(k, b)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix1); err != nil {
+		t.Fatal(err)
+	}
+
+	ix2, err := LoadIndex(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, bs := range rows {
+		a := ix2.Table.Get(k)
+
+		if len(a.Bits()) != len(bs) {
+			t.Errorf("key %d: expected %d bits, got %d", k, len(bs), len(a.Bits()))
+		}
+
+		for _, b := range bs {
+			if !a.Has(b) {
+				t.Errorf("key %d: bit %d should be set", k, b)
+			}
+		}
+	}
+
+	if _, ok := ix2.Table.Get(3).(*DenseArray); !ok {
+		t.Errorf("expected key 3 to be loaded as a dense array")
+	}
+}
+
+func TestDumpLoadPostings(t *testing.T) {
+	ix1 := newPostingsIndex()
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix1); err != nil {
+		t.Fatal(err)
+	}
+
+	ix2, err := LoadIndex(buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ix2.Postings == nil {
+		t.Fatal("expected postings to be loaded")
+	}
+
+	for i := 0; i < ix1.Domain.Size(); i++ {
+		if !sameKeys(ix1.Postings.Get(uint32(i)).Bits(), ix2.Postings.Get(uint32(i)).Bits()) {
+			t.Errorf("bit %d: postings differ", i)
+		}
+	}
+
+	if ix2.Postings.Keys().Cardinality() != 3 {
+		t.Errorf("expected 3 keys, got %d", ix2.Postings.Keys().Cardinality())
+	}
+}
+
+func TestLoadIndexErrors(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, newPostingsIndex()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// Truncated at various points.
+	for _, n := range []int{0, 3, 10, len(data) / 2, len(data) - 1} {
+		if _, err := LoadIndex(bytes.NewReader(data[:n])); err != ErrTruncated {
+			t.Errorf("truncated at %d: expected ErrTruncated, got %v", n, err)
+		}
+	}
+
+	// Not an index.
+	if _, err := LoadIndex(bytes.NewReader([]byte("person,fruit\n100,1\n"))); err != ErrNotIndex {
+		t.Errorf("expected ErrNotIndex, got %v", err)
+	}
+
+	if _, err := LoadDomain(bytes.NewReader([]byte("person,fruit\n100,1\n"))); err != ErrNotIndex {
+		t.Errorf("expected ErrNotIndex, got %v", err)
+	}
+
+	// Corrupt byte in the table.
+	bad := append([]byte(nil), data...)
+	bad[len(bad)/2] ^= 0xff
+
+	if _, err := LoadIndex(bytes.NewReader(bad)); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	// Unsupported version.
+	bad = append([]byte(nil), data...)
+	bad[4] = 0xff
+
+	if _, err := LoadIndex(bytes.NewReader(bad)); err == nil {
+		t.Errorf("expected version error")
+	} else if verr, ok := err.(*VersionError); !ok || verr.Version != 0xff {
+		t.Errorf("expected VersionError, got %v", err)
+	}
+
+	if _, err := LoadDomain(bytes.NewReader(bad)); err == nil {
+		t.Errorf("expected version error")
+	} else if _, ok := err.(*VersionError); !ok {
+		t.Errorf("expected VersionError, got %v", err)
+	}
+
+	// The domain is still readable on its own.
+	d, err := LoadDomain(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Size() != len(fruit) {
+		t.Errorf("expected %d members, got %d", len(fruit), d.Size())
+	}
+}
+
+func TestLoadStatsKeys(t *testing.T) {
+	ix := newPostingsIndex()
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := DumpIndex(buf, ix); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	s, err := LoadStats(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *s != *ix.Stats() {
+		t.Errorf("expected %+v, got %+v", ix.Stats(), s)
+	}
+
+	if s.Sparsity() != ix.Sparsity() {
+		t.Errorf("expected sparsity %f, got %f", ix.Sparsity(), s.Sparsity())
+	}
+
+	// Sections are skipped whether or not the reader can seek.
+	readers := map[string]io.Reader{
+		"seeker": bytes.NewReader(data),
+		"reader": struct{ io.Reader }{bytes.NewReader(data)},
+	}
+
+	for name, r := range readers {
+		keys, err := LoadKeys(r)
+
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if len(keys) != 3 || keys[0] != 100 || keys[1] != 101 || keys[2] != 102 {
+			t.Errorf("%s: expected [100, 101, 102], got %v", name, keys)
+		}
+	}
+
+	if _, err = LoadKeys(bytes.NewReader(data[:40])); err != ErrTruncated {
+		t.Errorf("expected ErrTruncated, got %v", err)
+	}
+}
+
 func BenchmarkDumpIndex(b *testing.B) {
 	ix := NewIndex(fruit)
 
@@ -68,3 +271,50 @@ func BenchmarkLoadIndex(b *testing.B) {
 		LoadIndex(buf)
 	}
 }
+
+func TestDecodeBitmapErrors(t *testing.T) {
+	// Encodes containers given as key, type, length and 16-bit values.
+	encode := func(cs ...[]uint16) []byte {
+		var buf bytes.Buffer
+
+		buf.WriteByte(byte(len(cs)))
+
+		for _, c := range cs {
+			buf.Write([]byte{byte(c[0]), byte(c[0] >> 8), byte(c[1]), byte(c[2])})
+
+			for _, v := range c[3:] {
+				buf.Write([]byte{byte(v), byte(v >> 8)})
+			}
+		}
+
+		return buf.Bytes()
+	}
+
+	at, bt, rt := uint16(arrayType), uint16(bitmapType), uint16(runType)
+
+	words := make([]uint16, bitmapWords*4)
+	words[0] = 0x7
+
+	if _, err := decodeBitmap(encode([]uint16{0, at, 2, 3, 5}, []uint16{1, rt, 1, 0, 9})); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decodeBitmap(encode(append([]uint16{0, bt, 3}, words...))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]byte{
+		"unsorted array":     encode([]uint16{0, at, 2, 5, 3}),
+		"duplicate value":    encode([]uint16{0, at, 2, 5, 5}),
+		"bitmap cardinality": encode(append([]uint16{0, bt, 4}, words...)),
+		"run overflow":       encode([]uint16{0, rt, 1, 0xfff0, 0x20}),
+		"overlapping runs":   encode([]uint16{0, rt, 2, 0, 10, 5, 1}),
+		"unsorted keys":      encode([]uint16{1, at, 1, 3}, []uint16{0, at, 1, 3}),
+	}
+
+	for name, data := range tests {
+		if _, err := decodeBitmap(data); err != ErrCorrupt {
+			t.Errorf("%s: expected ErrCorrupt, got %v", name, err)
+		}
+	}
+}
@@ -59,14 +59,14 @@ func (s Uint32Set) Intersect(b Uint32Set) Uint32Set {
 	o := make(Uint32Set)
 
 	// Pick the smallest set.
-	x := s
+	x, y := s, b
 
 	if len(b) < len(s) {
-		x = b
+		x, y = b, s
 	}
 
 	for k, _ := range x {
-		if _, ok := b[k]; ok {
+		if _, ok := y[k]; ok {
 			o[k] = struct{}{}
 		}
 	}